@@ -0,0 +1,369 @@
+// Package backtest replays the heikin ashi sentiment strategy over stored
+// prices and compares it with buying and holding.
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/calculation"
+	"github.com/jingen11/stonk-tracker/internal/models"
+)
+
+// actions a sentiment maps to
+const (
+	// BUY invests all the cash when no share is held
+	BUY = "buy"
+	// ADD invests Options.AddFraction of the equity, or what is left of the cash
+	ADD  = "add"
+	SELL = "sell"
+	HOLD = "hold"
+)
+
+// DefaultRules maps every sentiment of calculation.Patterns to an action.
+var DefaultRules = map[string]string{
+	"buy":        BUY,
+	"hold, add":  ADD,
+	"sell":       SELL,
+	"SELL":       SELL,
+	"hold, sell": HOLD,
+	"hold":       HOLD,
+	"no action":  HOLD,
+}
+
+type Options struct {
+	Capital float64
+	// Commission is paid on every fill, CommissionRate on top of it as a
+	// fraction of the value filled
+	Commission     float64
+	CommissionRate float64
+	// Slippage moves every fill against the strategy, as a fraction of the
+	// open it fills at
+	Slippage float64
+	// AddFraction is the share of the equity an add invests, Run reads zero
+	// as the one of DefaultOptions
+	AddFraction float64
+	// Rules maps sentiments to actions, sentiments missing from it hold
+	Rules map[string]string
+	// Start is the first bar traded, the bars before it only seed the heikin
+	// ashi chain. Zero trades from the first bar possible.
+	Start time.Time
+}
+
+// Validate rejects options no account could trade with.
+func (o Options) Validate() error {
+	if o.Capital <= 0 {
+		return errors.New("backtesting needs a positive capital")
+	}
+	if o.Commission < 0 || o.CommissionRate < 0 {
+		return errors.New("commissions cannot be negative")
+	}
+	if o.Slippage < 0 {
+		return errors.New("slippage cannot be negative")
+	}
+	if o.AddFraction <= 0 || o.AddFraction > 1 {
+		return errors.New(fmt.Sprintf("invalid add fraction: %g, expected more than 0 and at most 1", o.AddFraction))
+	}
+	return nil
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Capital:     10000,
+		Commission:  1,
+		Slippage:    0.0005,
+		AddFraction: 0.25,
+		Rules:       DefaultRules,
+	}
+}
+
+// ParseRules reads sentiment=action pairs separated by semicolons, sentiments
+// hold commas, on top of DefaultRules:
+//
+//	hold, sell=sell;hold, add=hold
+func ParseRules(s string) (map[string]string, error) {
+	rules := map[string]string{}
+	for sentiment, action := range DefaultRules {
+		rules[sentiment] = action
+	}
+	if strings.TrimSpace(s) == "" {
+		return rules, nil
+	}
+
+	for _, pair := range strings.Split(s, ";") {
+		sentiment, action, found := strings.Cut(pair, "=")
+		sentiment, action = strings.TrimSpace(sentiment), strings.ToLower(strings.TrimSpace(action))
+		if !found || sentiment == "" {
+			return nil, errors.New(fmt.Sprintf("invalid rule: %s, expected sentiment=action", pair))
+		}
+		switch action {
+		case BUY, ADD, SELL, HOLD:
+		default:
+			return nil, errors.New(fmt.Sprintf("unknown action: %s, expected buy, add, sell or hold", action))
+		}
+		if _, ok := DefaultRules[sentiment]; !ok {
+			return nil, errors.New(fmt.Sprintf("unknown sentiment: %s", sentiment))
+		}
+		rules[sentiment] = action
+	}
+	return rules, nil
+}
+
+// Fill is an order executed at the open of Date.
+type Fill struct {
+	Date   time.Time
+	Action string
+	Shares float64
+	// Price includes the slippage
+	Price      float64
+	Commission float64
+	// Sentiment is the sentiment of the bar before, the fill acts on it
+	Sentiment string
+}
+
+// Trade runs from the first buy while flat to the sell closing the position.
+type Trade struct {
+	Entry  time.Time
+	Exit   time.Time
+	Shares float64
+	// Cost and Proceeds include commissions
+	Cost     float64
+	Proceeds float64
+	// Open trades are valued at the last close, without selling costs
+	Open bool
+}
+
+func (t Trade) Profit() float64 {
+	return t.Proceeds - t.Cost
+}
+
+func (t Trade) Return() float64 {
+	if t.Cost == 0 {
+		return 0
+	}
+	return t.Profit() / t.Cost
+}
+
+type Stats struct {
+	Start       time.Time
+	End         time.Time
+	Capital     float64
+	Final       float64
+	TotalReturn float64
+	CAGR        float64
+	MaxDrawdown float64
+	// WinRate is the share of closed trades with a profit
+	WinRate float64
+	// Exposure is the share of bars closed with shares held
+	Exposure float64
+}
+
+type Result struct {
+	Symbol     string
+	Strategy   Stats
+	BuyAndHold Stats
+	Trades     []Trade
+	Fills      []Fill
+}
+
+// equityCurve accumulates the value of a portfolio at every close.
+type equityCurve struct {
+	peak     float64
+	drawdown float64
+	exposed  int
+	bars     int
+}
+
+func (e *equityCurve) mark(equity float64, holding bool) {
+	e.bars++
+	if holding {
+		e.exposed++
+	}
+	e.peak = math.Max(e.peak, equity)
+	if e.peak > 0 {
+		e.drawdown = math.Max(e.drawdown, (e.peak-equity)/e.peak)
+	}
+}
+
+func (e *equityCurve) stats(start, end time.Time, capital, final float64) Stats {
+	s := Stats{
+		Start:       start,
+		End:         end,
+		Capital:     capital,
+		Final:       final,
+		TotalReturn: final/capital - 1,
+		MaxDrawdown: e.drawdown,
+	}
+	if e.bars > 0 {
+		s.Exposure = float64(e.exposed) / float64(e.bars)
+	}
+	years := end.Sub(start).Hours() / 24 / 365.25
+	if years > 0 && final > 0 {
+		s.CAGR = math.Pow(final/capital, 1/years) - 1
+	}
+	return s
+}
+
+type account struct {
+	opt    Options
+	cash   float64
+	shares float64
+	fills  []Fill
+	trades []Trade
+}
+
+// buy spends up to budget on whole shares at open, slippage and commissions
+// included, and returns false when not even one share is affordable.
+func (a *account) buy(date time.Time, open float64, budget float64, sentiment string) bool {
+	price := open * (1 + a.opt.Slippage)
+	shares := math.Floor((budget - a.opt.Commission) / (price * (1 + a.opt.CommissionRate)))
+	if shares < 1 {
+		return false
+	}
+	commission := a.opt.Commission + shares*price*a.opt.CommissionRate
+	cost := shares*price + commission
+
+	if a.shares == 0 {
+		a.trades = append(a.trades, Trade{Entry: date})
+	}
+	trade := &a.trades[len(a.trades)-1]
+	trade.Shares += shares
+	trade.Cost += cost
+
+	a.cash -= cost
+	a.shares += shares
+	a.fills = append(a.fills, Fill{Date: date, Action: BUY, Shares: shares, Price: price, Commission: commission, Sentiment: sentiment})
+	return true
+}
+
+func (a *account) sell(date time.Time, open float64, sentiment string) {
+	price := open * (1 - a.opt.Slippage)
+	commission := a.opt.Commission + a.shares*price*a.opt.CommissionRate
+	proceeds := a.shares*price - commission
+
+	trade := &a.trades[len(a.trades)-1]
+	trade.Exit = date
+	trade.Proceeds = proceeds
+
+	a.cash += proceeds
+	a.fills = append(a.fills, Fill{Date: date, Action: SELL, Shares: a.shares, Price: price, Commission: commission, Sentiment: sentiment})
+	a.shares = 0
+}
+
+// Run walks prices bar by bar, chaining the heikin ashi candles from the first
+// one. The sentiment of a bar is only known at its close, so the action it
+// maps to fills at the open of the next bar, from the third bar or
+// opt.Start when later. Buy and hold buys at the same open as the first
+// possible fill of the strategy, with the same costs.
+func Run(symbol string, prices []models.Price, opt Options) (*Result, error) {
+	if len(prices) < 3 {
+		return nil, errors.New(fmt.Sprintf("%s: backtesting needs at least 3 prices, got %d", symbol, len(prices)))
+	}
+	if opt.Rules == nil {
+		opt.Rules = DefaultRules
+	}
+	if opt.AddFraction == 0 {
+		opt.AddFraction = DefaultOptions().AddFraction
+	}
+	err := opt.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	prices = append([]models.Price{}, prices...)
+	sort.Slice(prices, func(i, j int) bool {
+		return prices[i].Date < prices[j].Date
+	})
+
+	// the first bar with a sentiment before it to act on
+	begin := sort.Search(len(prices), func(i int) bool {
+		return !prices[i].Date.Time().Before(opt.Start)
+	})
+	begin = max(begin, 2)
+	if begin == len(prices) {
+		return nil, errors.New(fmt.Sprintf("%s: no price to backtest from %s", symbol, opt.Start.UTC().Format("2006-01-02")))
+	}
+
+	strategy := &account{opt: opt, cash: opt.Capital}
+	benchmark := &account{opt: opt, cash: opt.Capital}
+	// drawdowns count from the capital, entry costs included
+	strategyCurve, benchmarkCurve := &equityCurve{peak: opt.Capital}, &equityCurve{peak: opt.Capital}
+
+	var ha calculation.PriceCal
+	sentiment := ""
+	for i, p := range prices {
+		date := p.Date.Time().UTC()
+		price := calculation.PriceCal{Open: p.Open, Close: p.Close, High: p.High, Low: p.Low}
+
+		// act at the open on the sentiment of the previous close
+		if i >= begin {
+			switch opt.Rules[sentiment] {
+			case BUY:
+				if strategy.shares == 0 {
+					strategy.buy(date, p.Open, strategy.cash, sentiment)
+				}
+			case ADD:
+				equity := strategy.cash + strategy.shares*p.Open
+				strategy.buy(date, p.Open, math.Min(strategy.cash, equity*opt.AddFraction), sentiment)
+			case SELL:
+				if strategy.shares > 0 {
+					strategy.sell(date, p.Open, sentiment)
+				}
+			}
+		}
+		if i == begin {
+			benchmark.buy(date, p.Open, benchmark.cash, "")
+		}
+
+		if i == 0 {
+			ha = calculation.GetHeikinAshi(&price, &price)
+		} else {
+			sentiment = calculation.GetPatterns(&price, &ha).Sentiment()
+			ha = calculation.GetHeikinAshi(&price, &ha)
+		}
+
+		// the strategy cannot trade before begin, nor can buy and hold
+		if i >= begin {
+			strategyCurve.mark(strategy.cash+strategy.shares*p.Close, strategy.shares > 0)
+			benchmarkCurve.mark(benchmark.cash+benchmark.shares*p.Close, benchmark.shares > 0)
+		}
+	}
+
+	last := prices[len(prices)-1]
+	start := prices[begin].Date.Time().UTC()
+	end := last.Date.Time().UTC()
+
+	if strategy.shares > 0 {
+		trade := &strategy.trades[len(strategy.trades)-1]
+		trade.Open = true
+		trade.Exit = end
+		trade.Proceeds = strategy.shares * last.Close
+	}
+
+	result := &Result{
+		Symbol:     symbol,
+		Strategy:   strategyCurve.stats(start, end, opt.Capital, strategy.cash+strategy.shares*last.Close),
+		BuyAndHold: benchmarkCurve.stats(start, end, opt.Capital, benchmark.cash+benchmark.shares*last.Close),
+		Trades:     strategy.trades,
+		Fills:      strategy.fills,
+	}
+
+	closed, won := 0, 0
+	for _, t := range strategy.trades {
+		if t.Open {
+			continue
+		}
+		closed++
+		if t.Profit() > 0 {
+			won++
+		}
+	}
+	if closed > 0 {
+		result.Strategy.WinRate = float64(won) / float64(closed)
+	}
+	return result, nil
+}
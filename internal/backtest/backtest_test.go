@@ -0,0 +1,179 @@
+package backtest
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// trending returns bars from open, each moving by step: rising bars open at
+// their low and close at their high, falling bars the other way round.
+func trending(start time.Time, open float64, step float64, n int) []models.Price {
+	prices := []models.Price{}
+	for i := 0; i < n; i++ {
+		o, c := open+float64(i)*step, open+float64(i+1)*step
+		prices = append(prices, models.Price{
+			Date:  primitive.NewDateTimeFromTime(start.AddDate(0, 0, i)),
+			Open:  o,
+			Close: c,
+			High:  math.Max(o, c),
+			Low:   math.Min(o, c),
+		})
+	}
+	return prices
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestRunAlwaysBuy(t *testing.T) {
+	rules := map[string]string{}
+	for sentiment := range DefaultRules {
+		rules[sentiment] = BUY
+	}
+	opt := Options{Capital: 1000, Commission: 1, CommissionRate: 0.001, Slippage: 0.01, Rules: rules}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	prices := append(trending(start, 10, 1, 5), trending(start.AddDate(0, 0, 5), 15, -1, 5)...)
+	result, err := Run("IBM", prices, opt)
+	if err != nil {
+		t.Fatalf("error running backtest: %v", err)
+	}
+
+	if len(result.Fills) != 1 || !near(result.Fills[0].Price, 12*1.01) || !near(result.Fills[0].Commission, 1+result.Fills[0].Shares*12*1.01*0.001) {
+		t.Fatalf("expected a single buy at the open of the third bar, got %+v", result.Fills)
+	}
+	if result.Strategy != result.BuyAndHold {
+		t.Fatalf("expected always buying to be buy and hold, got %+v and %+v", result.Strategy, result.BuyAndHold)
+	}
+	if len(result.Trades) != 1 || !result.Trades[0].Open || result.Strategy.WinRate != 0 || result.Strategy.Exposure != 1 {
+		t.Fatalf("expected one open trade held throughout, got %+v, %+v", result.Trades, result.Strategy)
+	}
+	if !result.Strategy.Start.Equal(start.AddDate(0, 0, 2)) || !result.Strategy.End.Equal(start.AddDate(0, 0, 9)) {
+		t.Fatalf("expected stats from the third bar to the last, got %+v", result.Strategy)
+	}
+}
+
+func TestRun(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	opt := DefaultOptions()
+	opt.Commission, opt.Slippage = 0, 0
+
+	// rising bars read as hold, add and falling ones as SELL
+	result, err := Run("IBM", append(trending(start, 100, 1, 10), trending(start.AddDate(0, 0, 10), 110, -1, 10)...), opt)
+	if err != nil {
+		t.Fatalf("error running backtest: %v", err)
+	}
+
+	if len(result.Trades) != 1 || result.Trades[0].Open || !result.Trades[0].Entry.Equal(start.AddDate(0, 0, 2)) {
+		t.Fatalf("expected one closed trade entered on the third bar, got %+v", result.Trades)
+	}
+	buys := 0
+	for _, f := range result.Fills {
+		if f.Action == BUY {
+			buys++
+		}
+	}
+	if buys < 2 || result.Fills[len(result.Fills)-1].Action != SELL {
+		t.Fatalf("expected the position built up over several bars then sold, got %+v", result.Fills)
+	}
+	if result.Trades[0].Exit.Before(start.AddDate(0, 0, 10)) {
+		t.Fatalf("expected the trade closed once prices fell, got %+v", result.Trades[0])
+	}
+
+	s := result.Strategy
+	if s.WinRate != 1 || s.Exposure <= 0 || s.Exposure >= 1 || s.MaxDrawdown <= 0 || !near(s.TotalReturn, s.Final/s.Capital-1) {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if s.Final <= result.BuyAndHold.Final {
+		t.Fatalf("expected selling the fall to beat buy and hold, got %+v and %+v", s, result.BuyAndHold)
+	}
+
+	if _, err := Run("IBM", trending(start, 100, 1, 2), opt); err == nil {
+		t.Fatalf("expected an error with less than 3 prices")
+	}
+}
+
+func TestRunStart(t *testing.T) {
+	rules := map[string]string{}
+	for sentiment := range DefaultRules {
+		rules[sentiment] = BUY
+	}
+	opt := Options{Capital: 1000, Rules: rules}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	prices := trending(start, 10, 1, 10)
+	opt.Start = start.AddDate(0, 0, 5)
+	result, err := Run("IBM", prices, opt)
+	if err != nil {
+		t.Fatalf("error running backtest: %v", err)
+	}
+
+	// the bars before Start seed the candles, the first of them is traded on
+	if len(result.Fills) != 1 || !result.Fills[0].Date.Equal(opt.Start) || !near(result.Fills[0].Price, 15) {
+		t.Fatalf("expected a single buy at the open of Start, got %+v", result.Fills)
+	}
+	if !result.Strategy.Start.Equal(opt.Start) || !result.BuyAndHold.Start.Equal(opt.Start) || result.Strategy.Exposure != 1 {
+		t.Fatalf("expected stats from Start, got %+v and %+v", result.Strategy, result.BuyAndHold)
+	}
+
+	opt.Start = start.AddDate(0, 0, 10)
+	if _, err := Run("IBM", prices, opt); err == nil {
+		t.Fatalf("expected an error starting after the last price")
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	cases := []struct {
+		change func(o *Options)
+		valid  bool
+	}{
+		{change: func(o *Options) {}, valid: true},
+		{change: func(o *Options) { o.AddFraction = 1 }, valid: true},
+		{change: func(o *Options) { o.AddFraction = 0 }, valid: false},
+		{change: func(o *Options) { o.AddFraction = 1.5 }, valid: false},
+		{change: func(o *Options) { o.Capital = -1 }, valid: false},
+		{change: func(o *Options) { o.Commission = -1 }, valid: false},
+		{change: func(o *Options) { o.CommissionRate = -0.01 }, valid: false},
+		{change: func(o *Options) { o.Slippage = -0.001 }, valid: false},
+	}
+
+	for i, c := range cases {
+		opt := DefaultOptions()
+		c.change(&opt)
+		err := opt.Validate()
+		if (err == nil) != c.valid {
+			t.Fatalf("Test case %d: expected valid %v, got %v", i, c.valid, err)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	cases := []struct {
+		rules    string
+		expected map[string]string
+		fails    bool
+	}{
+		{rules: "", expected: map[string]string{"hold, sell": HOLD, "buy": BUY}},
+		{rules: "hold, sell=sell; buy = ADD", expected: map[string]string{"hold, sell": SELL, "buy": ADD, "SELL": SELL}},
+		{rules: "hold, sell", fails: true},
+		{rules: "hold=short", fails: true},
+		{rules: "maybe=buy", fails: true},
+	}
+
+	for i, c := range cases {
+		rules, err := ParseRules(c.rules)
+		if (err != nil) != c.fails {
+			t.Fatalf("Test case %d: expected failure %v, got %v", i, c.fails, err)
+		}
+		for sentiment, action := range c.expected {
+			if rules[sentiment] != action {
+				t.Fatalf("Test case %d: expected %s=%s, got %v", i, sentiment, action, rules)
+			}
+		}
+	}
+}
@@ -32,6 +32,17 @@ func GetHeikinDailyLow(price *PriceCal, prev *PriceCal) float64 {
 	return math.Min(math.Min(open, close), price.Low)
 }
 
+// GetHeikinAshi returns the heikin ashi candle of price, prev being the previous
+// heikin ashi candle. The first candle of a chain uses its own price as prev.
+func GetHeikinAshi(price *PriceCal, prev *PriceCal) PriceCal {
+	return PriceCal{
+		Open:  GetHeikinDailyOpen(prev),
+		Close: GetHeikinDailyClose(price),
+		High:  GetHeikinDailyHigh(price, prev),
+		Low:   GetHeikinDailyLow(price, prev),
+	}
+}
+
 // prev should be heikin, price should be normal
 func GetIsSpinningTop(price *PriceCal, prev *PriceCal) bool {
 	open := GetHeikinDailyOpen(prev)
@@ -139,3 +150,61 @@ func GetIsBear(price *PriceCal, prev *PriceCal) bool {
 
 	return close < open && int(open*100) == int(high*100)
 }
+
+// Patterns are the candle patterns of price, prev being the previous heikin
+// ashi candle.
+type Patterns struct {
+	Uptrend     bool
+	Bull        bool
+	Bear        bool
+	SpinningTop bool
+	Doji        bool
+	Gravestone  bool
+}
+
+func GetPatterns(price *PriceCal, prev *PriceCal) Patterns {
+	return Patterns{
+		Uptrend:     GetIsUptrend(price, prev),
+		Bull:        GetIsBull(price, prev),
+		Bear:        GetIsBear(price, prev),
+		SpinningTop: GetIsSpinningTop(price, prev),
+		Doji:        GetIsDojiStar(price, prev),
+		Gravestone:  GetIsGravestoneDoji(price, prev),
+	}
+}
+
+// RULE_VERSION identifies the rules of GetPatterns and Sentiment, stored with
+// every signal. Bump it whenever they change.
+const RULE_VERSION = 1
+
+func (p Patterns) Sentiment() string {
+	u, bu, be, st, ds, g := p.Uptrend, p.Bull, p.Bear, p.SpinningTop, p.Doji, p.Gravestone
+	if !u && ds {
+		return "buy"
+	}
+	if u && ds {
+		return "sell"
+	}
+	if u && st {
+		return "hold, sell"
+	}
+	if be {
+		return "SELL"
+	}
+	if bu {
+		return "hold, add"
+	}
+	if g {
+		return "sell"
+	}
+	if u && st {
+		return "hold"
+	}
+	if !u {
+		return "sell"
+	}
+	if u {
+		return "hold"
+	}
+	return "no action"
+}
@@ -629,3 +629,33 @@ func TestGetIsBear(t *testing.T) {
 		}
 	}
 }
+
+func TestGetHeikinAshi(t *testing.T) {
+	cases := []struct {
+		input    PriceCal
+		prev     PriceCal
+		expected [4]int
+	}{{
+		// ARM 2024-08-06
+		input: PriceCal{
+			Open:  115.53,
+			High:  117.97,
+			Low:   109.50,
+			Close: 113.39,
+		},
+		prev: PriceCal{
+			Open:  123.73,
+			Close: 104.78,
+		},
+		expected: [4]int{11425, 11797, 10950, 11409},
+	}}
+
+	for i, c := range cases {
+		ha := GetHeikinAshi(&c.input, &c.prev)
+		actual := [4]int{int(ha.Open * 100), int(ha.High * 100), int(ha.Low * 100), int(ha.Close * 100)}
+
+		if actual != c.expected {
+			t.Fatalf("Test case %d: expected OHLC: %v, actual OHLC: %v", i, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Package calendar knows the trading days of the NYSE and NASDAQ, which share
+// the same holiday schedule. Holidays are computed from the exchange rules so
+// any year works without a lookup table.
+package calendar
+
+import (
+	"time"
+)
+
+// unscheduled closures that no rule can predict, listed from 1990: the
+// calendar does not know the earlier ones, e.g. Hurricane Gloria in 1985
+var specialClosures = map[string]string{
+	"1994-04-27": "National Day of Mourning for Richard Nixon",
+	"2001-09-11": "September 11 attacks",
+	"2001-09-12": "September 11 attacks",
+	"2001-09-13": "September 11 attacks",
+	"2001-09-14": "September 11 attacks",
+	"2004-06-11": "National Day of Mourning for Ronald Reagan",
+	"2007-01-02": "National Day of Mourning for Gerald Ford",
+	"2012-10-29": "Hurricane Sandy",
+	"2012-10-30": "Hurricane Sandy",
+	"2018-12-05": "National Day of Mourning for George H.W. Bush",
+	"2025-01-09": "National Day of Mourning for Jimmy Carter",
+}
+
+// truncate drops the time of day while keeping the calendar date of t.
+func truncate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// nthWeekday returns the nth weekday of the month, counting from the end when n is negative.
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+	if n > 0 {
+		first := date(year, month, 1)
+		offset := (int(weekday) - int(first.Weekday()) + 7) % 7
+		return first.AddDate(0, 0, offset+(n-1)*7)
+	}
+	last := date(year, month+1, 0)
+	offset := (int(last.Weekday()) - int(weekday) + 7) % 7
+	return last.AddDate(0, 0, -offset+(n+1)*7)
+}
+
+// observed moves a holiday falling on a weekend to the nearest weekday.
+func observed(t time.Time) time.Time {
+	switch t.Weekday() {
+	case time.Saturday:
+		return t.AddDate(0, 0, -1)
+	case time.Sunday:
+		return t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// easter uses the anonymous gregorian algorithm.
+func easter(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return date(year, time.Month(month), day)
+}
+
+// Holidays returns the full-day closures of year keyed by date (2006-01-02).
+func Holidays(year int) map[string]string {
+	holidays := map[string]string{}
+	add := func(t time.Time, name string) {
+		// a holiday observed in another year belongs to that year's calendar
+		if t.Year() == year {
+			holidays[t.Format("2006-01-02")] = name
+		}
+	}
+
+	// new year's day on a saturday is not observed on the previous friday
+	newYear := date(year, time.January, 1)
+	if newYear.Weekday() != time.Saturday {
+		add(observed(newYear), "New Year's Day")
+	}
+	if year >= 1998 {
+		add(nthWeekday(year, time.January, time.Monday, 3), "Martin Luther King Jr. Day")
+	}
+	add(nthWeekday(year, time.February, time.Monday, 3), "Washington's Birthday")
+	add(easter(year).AddDate(0, 0, -2), "Good Friday")
+	add(nthWeekday(year, time.May, time.Monday, -1), "Memorial Day")
+	if year >= 2022 {
+		add(observed(date(year, time.June, 19)), "Juneteenth")
+	}
+	add(observed(date(year, time.July, 4)), "Independence Day")
+	add(nthWeekday(year, time.September, time.Monday, 1), "Labor Day")
+	add(nthWeekday(year, time.November, time.Thursday, 4), "Thanksgiving Day")
+	add(observed(date(year, time.December, 25)), "Christmas Day")
+
+	for d, name := range specialClosures {
+		t, _ := time.Parse("2006-01-02", d)
+		add(t, name)
+	}
+
+	return holidays
+}
+
+// Holiday returns the name of the holiday on t, if any.
+func Holiday(t time.Time) (string, bool) {
+	t = truncate(t)
+	name, ok := Holidays(t.Year())[t.Format("2006-01-02")]
+	return name, ok
+}
+
+func IsTradingDay(t time.Time) bool {
+	t = truncate(t)
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	_, holiday := Holiday(t)
+	return !holiday
+}
+
+// IsEarlyClose reports whether the market closes at 13:00 on t: the day before
+// Independence Day, the day after Thanksgiving and Christmas Eve.
+func IsEarlyClose(t time.Time) bool {
+	t = truncate(t)
+	if !IsTradingDay(t) {
+		return false
+	}
+
+	year := t.Year()
+	earlyCloses := []time.Time{
+		date(year, time.July, 3),
+		nthWeekday(year, time.November, time.Thursday, 4).AddDate(0, 0, 1),
+		date(year, time.December, 24),
+	}
+	for _, d := range earlyCloses {
+		if t.Equal(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrevTradingDay returns the last trading day strictly before t.
+func PrevTradingDay(t time.Time) time.Time {
+	t = truncate(t).AddDate(0, 0, -1)
+	for !IsTradingDay(t) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// NextTradingDay returns the first trading day strictly after t.
+func NextTradingDay(t time.Time) time.Time {
+	t = truncate(t).AddDate(0, 0, 1)
+	for !IsTradingDay(t) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// TradingDaysBetween returns the trading days from from to to, both included, in ascending order.
+func TradingDaysBetween(from, to time.Time) []time.Time {
+	days := []time.Time{}
+	to = truncate(to)
+	for d := truncate(from); !d.After(to); d = d.AddDate(0, 0, 1) {
+		if IsTradingDay(d) {
+			days = append(days, d)
+		}
+	}
+	return days
+}
@@ -0,0 +1,113 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+)
+
+func parse(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestIsTradingDay(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected bool
+	}{
+		{input: "2025-01-01", expected: false}, // New Year's Day
+		{input: "2025-01-09", expected: false}, // Jimmy Carter
+		{input: "1994-04-27", expected: false}, // Richard Nixon
+		{input: "2001-09-13", expected: false}, // September 11
+		{input: "2001-09-17", expected: true},  // reopened after September 11
+		{input: "2004-06-11", expected: false}, // Ronald Reagan
+		{input: "2007-01-02", expected: false}, // Gerald Ford
+		{input: "2012-10-30", expected: false}, // Hurricane Sandy
+		{input: "2025-01-20", expected: false}, // MLK
+		{input: "2025-02-17", expected: false}, // Washington's Birthday
+		{input: "2025-04-18", expected: false}, // Good Friday
+		{input: "2025-05-26", expected: false}, // Memorial Day
+		{input: "2025-06-19", expected: false}, // Juneteenth
+		{input: "2025-07-04", expected: false}, // Independence Day
+		{input: "2025-09-01", expected: false}, // Labor Day
+		{input: "2025-11-27", expected: false}, // Thanksgiving
+		{input: "2025-12-25", expected: false}, // Christmas
+		{input: "2026-07-03", expected: false}, // Independence Day observed on friday
+		{input: "2021-12-31", expected: true},  // New Year's Day 2022 is a saturday and not observed
+		{input: "2022-12-26", expected: false}, // Christmas observed on monday
+		{input: "2021-06-18", expected: true},  // before Juneteenth became a market holiday
+		{input: "2024-03-29", expected: false}, // Good Friday
+		{input: "2025-02-08", expected: false}, // saturday
+		{input: "2025-02-10", expected: true},
+		{input: "2025-11-28", expected: true},
+	}
+
+	for i, c := range cases {
+		actual := IsTradingDay(parse(c.input))
+		if actual != c.expected {
+			t.Fatalf("Test case %d: %s expected trading day: %v, actual: %v", i, c.input, c.expected, actual)
+		}
+	}
+}
+
+func TestIsEarlyClose(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected bool
+	}{
+		{input: "2025-07-03", expected: true},
+		{input: "2025-11-28", expected: true},
+		{input: "2025-12-24", expected: true},
+		{input: "2022-12-23", expected: false}, // christmas eve is a saturday
+		{input: "2021-12-24", expected: false}, // christmas observed on christmas eve
+		{input: "2025-02-10", expected: false},
+	}
+
+	for i, c := range cases {
+		actual := IsEarlyClose(parse(c.input))
+		if actual != c.expected {
+			t.Fatalf("Test case %d: %s expected early close: %v, actual: %v", i, c.input, c.expected, actual)
+		}
+	}
+}
+
+func TestPrevTradingDay(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "2025-02-11", expected: "2025-02-10"},
+		{input: "2025-02-10", expected: "2025-02-07"},
+		{input: "2025-01-21", expected: "2025-01-17"},
+		{input: "2025-04-21", expected: "2025-04-17"},
+		{input: "2025-01-02", expected: "2024-12-31"},
+	}
+
+	for i, c := range cases {
+		actual := PrevTradingDay(parse(c.input)).Format("2006-01-02")
+		if actual != c.expected {
+			t.Fatalf("Test case %d: expected: %s, actual: %s", i, c.expected, actual)
+		}
+	}
+}
+
+func TestTradingDaysBetween(t *testing.T) {
+	cases := []struct {
+		from     string
+		to       string
+		expected int
+	}{
+		{from: "2025-02-03", to: "2025-02-07", expected: 5},
+		{from: "2025-02-08", to: "2025-02-09", expected: 0},
+		{from: "2025-02-10", to: "2025-02-03", expected: 0},
+		{from: "2025-02-14", to: "2025-02-18", expected: 2},
+		{from: "2025-01-01", to: "2025-12-31", expected: 250},
+	}
+
+	for i, c := range cases {
+		actual := TradingDaysBetween(parse(c.from), parse(c.to))
+		if len(actual) != c.expected {
+			t.Fatalf("Test case %d: expected %d trading days, actual: %d", i, c.expected, len(actual))
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/backtest"
+	"github.com/jingen11/stonk-tracker/internal/db"
+	"github.com/jingen11/stonk-tracker/internal/models"
+)
+
+// HandleBacktest replays the sentiment strategy over the stored prices of the
+// given symbols, or of those of -watchlist and -tag, and compares it with
+// buying and holding:
+//
+//	backtest [-from YYYY-MM-DD] [-to YYYY-MM-DD] [-capital C] [-commission F] [-commission-rate R]
+//		[-slippage-bps B] [-add F] [-rules "sentiment=action;..."] SYMBOL...
+//
+// The prices before -from are loaded too, the heikin ashi candles chain from
+// the first stored price as they do for info.
+func HandleBacktest(p *Command) error {
+	defaults := backtest.DefaultOptions()
+
+	fs := flag.NewFlagSet("backtest", flag.ContinueOnError)
+	fromFlag := fs.String("from", "", "first bar, YYYY-MM-DD, the first stored price when empty")
+	toFlag := fs.String("to", "", "last bar, YYYY-MM-DD, the latest stored price when empty")
+	capital := fs.Float64("capital", defaults.Capital, "cash to start with")
+	commission := fs.Float64("commission", defaults.Commission, "commission paid on every fill")
+	commissionRate := fs.Float64("commission-rate", defaults.CommissionRate, "commission paid on every fill as a fraction of its value")
+	slippage := fs.Float64("slippage-bps", defaults.Slippage*10000, "slippage of every fill in basis points of the open")
+	add := fs.Float64("add", defaults.AddFraction, "fraction of the equity invested by an add")
+	rules := fs.String("rules", "", "sentiment=action pairs separated by ;, actions are buy, add, sell and hold")
+	filter := addSymbolFilterFlags(fs)
+
+	err := fs.Parse(p.Input)
+	if err != nil {
+		return err
+	}
+
+	var from, to time.Time
+	if *fromFlag != "" {
+		from, err = time.Parse("2006-01-02", *fromFlag)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid -from date: %s", *fromFlag))
+		}
+	}
+	if *toFlag != "" {
+		to, err = time.Parse("2006-01-02", *toFlag)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid -to date: %s", *toFlag))
+		}
+	}
+
+	opt := backtest.Options{
+		Capital:        *capital,
+		Commission:     *commission,
+		CommissionRate: *commissionRate,
+		Slippage:       *slippage / 10000,
+		AddFraction:    *add,
+		Start:          from,
+	}
+	err = opt.Validate()
+	if err != nil {
+		return err
+	}
+	opt.Rules, err = backtest.ParseRules(*rules)
+	if err != nil {
+		return err
+	}
+
+	symbols, err := backtestSymbols(p, fs.Args(), filter)
+	if err != nil {
+		return err
+	}
+
+	for _, symbol := range symbols {
+		prices, err := loadPrices(p, symbol, time.Time{}, to)
+		if err != nil {
+			fmt.Printf("Error getting stock prices for symbol: %s\n", symbol)
+			return err
+		}
+
+		result, err := backtest.Run(symbol, prices, opt)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		printBacktest(result)
+	}
+	return nil
+}
+
+// backtestSymbols resolves args and adds the symbols of filter, each symbol
+// once.
+func backtestSymbols(p *Command, args []string, filter *symbolFilter) ([]string, error) {
+	symbols := []string{}
+	seen := map[string]bool{}
+	for _, s := range args {
+		tracked, err := resolveSymbol(p, s)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tracked.Symbol] {
+			seen[tracked.Symbol] = true
+			symbols = append(symbols, tracked.Symbol)
+		}
+	}
+	if !filter.empty() {
+		all, err := p.Cfg.Query.GetAllSymbols(p.Ctx)
+		if err != nil {
+			return nil, err
+		}
+		all, err = filter.apply(p, all)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range all {
+			if !seen[s.Symbol] {
+				seen[s.Symbol] = true
+				symbols = append(symbols, s.Symbol)
+			}
+		}
+	}
+	if len(symbols) == 0 {
+		return nil, errors.New("Please provide a stonk symbol, -watchlist or -tag")
+	}
+	return symbols, nil
+}
+
+// loadPrices pages through every stored price of symbol between from and to,
+// oldest first.
+func loadPrices(p *Command, symbol string, from time.Time, to time.Time) ([]models.Price, error) {
+	opt := &db.GetStockPriceOpt{
+		Symbol: symbol,
+		From:   from,
+		To:     to,
+		Order:  db.ASCENDING,
+		Limit:  exportPageSize,
+	}
+
+	all := []models.Price{}
+	for {
+		prices, err := p.Cfg.Query.GetStockPrices(p.Ctx, opt)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, prices...)
+
+		if int64(len(prices)) < opt.Limit {
+			return all, nil
+		}
+		opt.After = db.CursorAfter(prices[len(prices)-1])
+	}
+}
+
+func printBacktest(r *backtest.Result) {
+	s, b := r.Strategy, r.BuyAndHold
+	percent := func(v float64) string {
+		return fmt.Sprintf("%.2f%%", v*100)
+	}
+
+	fmt.Printf("------------------------------------\n%s: %s to %s\n", r.Symbol, s.Start.Format("2006-01-02"), s.End.Format("2006-01-02"))
+	fmt.Printf("%-14s %12s %12s\n", "", "STRATEGY", "BUY & HOLD")
+	fmt.Printf("%-14s %12.2f %12.2f\n", "Final", s.Final, b.Final)
+	fmt.Printf("%-14s %12s %12s\n", "Total return", percent(s.TotalReturn), percent(b.TotalReturn))
+	fmt.Printf("%-14s %12s %12s\n", "CAGR", percent(s.CAGR), percent(b.CAGR))
+	fmt.Printf("%-14s %12s %12s\n", "Max drawdown", percent(s.MaxDrawdown), percent(b.MaxDrawdown))
+	fmt.Printf("%-14s %12s %12s\n", "Win rate", percent(s.WinRate), "-")
+	fmt.Printf("%-14s %12s %12s\n", "Exposure", percent(s.Exposure), percent(b.Exposure))
+	fmt.Printf("%-14s %12d %12s\n", "Trades", len(r.Trades), "-")
+
+	if len(r.Trades) == 0 {
+		fmt.Println("No trade, the sentiments never mapped to a buy")
+		return
+	}
+
+	fmt.Printf("%-10s %-10s %10s %12s %12s %12s %9s\n", "ENTRY", "EXIT", "SHARES", "COST", "PROCEEDS", "PROFIT", "RETURN")
+	for _, t := range r.Trades {
+		exit := t.Exit.Format("2006-01-02")
+		if t.Open {
+			exit = "open"
+		}
+		fmt.Printf("%-10s %-10s %10g %12.2f %12.2f %12.2f %9s\n", t.Entry.Format("2006-01-02"), exit, t.Shares, t.Cost, t.Proceeds, t.Profit(), percent(t.Return()))
+	}
+}
@@ -3,106 +3,107 @@ package command
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/jingen11/stonk-tracker/internal/calculation"
+	"github.com/jingen11/stonk-tracker/internal/calendar"
 	"github.com/jingen11/stonk-tracker/internal/db"
 	"github.com/jingen11/stonk-tracker/internal/models"
+	"github.com/jingen11/stonk-tracker/internal/scheduler"
+	stonkapi "github.com/jingen11/stonk-tracker/internal/stonkApi"
 	"github.com/jingen11/stonk-tracker/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Command struct {
+	Ctx   context.Context
 	Cfg   utils.ProjectConfig
 	Input []string
 }
 
+// time given to store what was fetched once the run is interrupted
+const flushTimeout = 30 * time.Second
+
 func HandleRefresh(p *Command) error {
-	symbols, err := p.Cfg.Query.GetAllSymbols(context.TODO())
+	fs := flag.NewFlagSet("refresh", flag.ContinueOnError)
+	filter := addSymbolFilterFlags(fs)
+	err := fs.Parse(p.Input)
 	if err != nil {
 		return err
 	}
-	type stonkPackage struct {
-		symbol string
-		dates  []time.Time
+
+	symbols, err := p.Cfg.Query.GetAllSymbols(p.Ctx)
+	if err != nil {
+		return err
+	}
+	symbols, err = filter.apply(p, symbols)
+	if err != nil {
+		return err
 	}
 
-	packages := []stonkPackage{}
-	for _, symbol := range symbols {
-		dates := []time.Time{}
-		prevDate := truncateToDay(time.Now().Add(-time.Hour * 24))
+	to := calendar.PrevTradingDay(time.Now())
 
-		shouldContinue := prevDate.After(symbol.LastFetchedDate.Time())
-		for shouldContinue {
-			if prevDate.Weekday() != time.Sunday && prevDate.Weekday() != time.Saturday {
-				dates = append(dates, prevDate)
-			}
-			prevDate = prevDate.Add(-time.Hour * 24)
-			shouldContinue = prevDate.After(symbol.LastFetchedDate.Time())
+	tasks := []scheduler.Task{}
+	tradingDays := map[string][]time.Time{}
+	for _, symbol := range symbols {
+		if symbol.Archived {
+			continue
 		}
+		// the watermark is a UTC midnight, its local day may be the one before
+		days := calendar.TradingDaysBetween(symbol.LastFetchedDate.Time().UTC().Add(time.Hour*24), to)
 
-		if len(dates) > 0 {
-			packages = append(packages, stonkPackage{
-				symbol: symbol.Symbol,
-				dates:  dates,
-			})
+		if len(days) == 0 {
+			continue
 		}
 
-		// stockData, err := p.Cfg.ApiClient.GetPrices(symbol.Symbol, time.Now().Format("2006-01-02"))
-		// if err != nil {
-		// 	fmt.Printf("Error getting stockData for symbol: %s", symbol.Symbol)
-		// 	continue
-		// }
-		// _, err = p.Cfg.Query.InsertStockPrice(stockData, context.TODO())
-		// if err != nil {
-		// 	fmt.Printf("Error insertting stock price for symbol: %s", symbol.Symbol)
-		// 	continue
-		// }
-	}
-
-	stockChan := make(chan models.StockData)
-	errChan := make(chan error)
-	total := 0
-
-	for i := 0; i < len(packages); i++ {
-		for j := 0; j < len(packages[i].dates); j++ {
-			total++
-			go getPriceConcurrently(errChan, stockChan, packages[i].symbol, packages[i].dates[j], p)
-		}
+		tradingDays[symbol.Symbol] = days
+		tasks = append(tasks, scheduler.Task{
+			Symbol: symbol.Symbol,
+			From:   days[0],
+			To:     to,
+		})
 	}
 
-	stockRes := getPriceChanSubscriber(errChan, stockChan, total)
+	results := scheduler.InitScheduler(p.Cfg.ApiClient, p.Cfg.FetchConcurrency).Run(p.Ctx, tasks)
 
-	type stonkStonksResponse struct {
-		symbol    string
-		stockData []models.StockData
-	}
+	ctx, cancel := flushContext(p)
+	defer cancel()
 
-	mapper := map[string]stonkStonksResponse{}
+	var authErr error
+	for _, task := range tasks {
+		stockData, err := scheduler.Stocks(results[task.Symbol])
+		if errors.Is(err, stonkapi.ErrUnauthorized) {
+			authErr = err
+		} else if err != nil && p.Ctx.Err() == nil {
+			fmt.Printf("Error fetching stock price for symbol: %s, %v\n", task.Symbol, err)
+		}
 
-	for _, stock := range *stockRes {
-		if entry, ok := mapper[stock.Symbol]; !ok {
-			mapper[stock.Symbol] = stonkStonksResponse{
-				symbol: stock.Symbol,
-				stockData: []models.StockData{
-					stock,
-				},
+		if len(stockData) == 0 {
+			if err == nil {
+				fmt.Printf("No stock price for symbol: %s since %s\n", task.Symbol, task.From.Format("2006-01-02"))
 			}
-		} else {
-			latest := append(entry.stockData, stock)
-			entry.stockData = latest
+			continue
 		}
-	}
 
-	for k, v := range mapper {
-		_, err = p.Cfg.Query.InsertSymbolStockPrices(v.stockData, k, context.TODO())
+		reportMissingDays(task.Symbol, tradingDays[task.Symbol], stockData)
+
+		res, err := p.Cfg.Query.InsertSymbolStockPrices(stockData, task.Symbol, ctx)
 		if err != nil {
-			fmt.Printf("Error inserting stock price for symbol: %s\n", k)
+			fmt.Printf("Error inserting stock price for symbol: %s, %v\n", task.Symbol, err)
 		}
+		printUpsertResult(task.Symbol, res)
 	}
 
-	return nil
+	printKeyStatus(p)
+
+	if p.Ctx.Err() != nil {
+		return p.Ctx.Err()
+	}
+	return authErr
 }
 
 func HandlerAddNewSymbol(p *Command) error {
@@ -110,188 +111,257 @@ func HandlerAddNewSymbol(p *Command) error {
 		return errors.New("Please provide a stonk symbol")
 	}
 	symbol := p.Input[0]
-	dates := []time.Time{}
-	prevDate := time.Now().Add(-time.Hour * 24)
 
-	for len(dates) != p.Cfg.HistoricalTimeFrame {
-		if prevDate.Weekday() != time.Sunday && prevDate.Weekday() != time.Saturday {
-			dates = append(dates, prevDate)
+	tracked, err := p.Cfg.Query.ResolveSymbol(p.Ctx, strings.ToUpper(symbol))
+	if err != nil && !errors.Is(err, db.ErrUnknownSymbol) {
+		return err
+	}
+	if err == nil {
+		if tracked.Archived {
+			return errors.New(fmt.Sprintf("Symbol %s is archived, run archive -restore %s", tracked.Symbol, tracked.Symbol))
+		}
+		if tracked.Symbol != strings.ToUpper(symbol) {
+			fmt.Printf("%s was renamed to %s\n", strings.ToUpper(symbol), tracked.Symbol)
+			symbol = tracked.Symbol
 		}
-		prevDate = prevDate.Add(-time.Hour * 24)
 	}
 
-	stockChan := make(chan models.StockData)
-	errChan := make(chan error)
+	to := calendar.PrevTradingDay(time.Now())
+	from := historyStart(p, to)
+
+	results := scheduler.InitScheduler(p.Cfg.ApiClient, p.Cfg.FetchConcurrency).Run(p.Ctx, []scheduler.Task{{
+		Symbol: symbol,
+		From:   from,
+		To:     to,
+	}})
 
-	for i := 0; i < len(dates); i++ {
-		go getPriceConcurrently(errChan, stockChan, symbol, dates[i], p)
+	stocks, err := scheduler.Stocks(results[symbol])
+	if err != nil && (p.Ctx.Err() == nil || len(stocks) == 0) {
+		fmt.Printf("Error fetching stock price for symbol: %s\n", symbol)
+		return err
 	}
 
-	stocks := getPriceChanSubscriber(errChan, stockChan, p.Cfg.HistoricalTimeFrame)
+	reportMissingDays(symbol, calendar.TradingDaysBetween(from, to), stocks)
+
+	ctx, cancel := flushContext(p)
+	defer cancel()
 
-	_, err := p.Cfg.Query.InsertSymbolStockPrices(*stocks, symbol, context.TODO())
+	res, err := p.Cfg.Query.InsertSymbolStockPrices(stocks, symbol, ctx)
 	if err != nil {
 		fmt.Printf("Error inserting stock price for symbol: %s\n", symbol)
 		return err
 	}
+	printUpsertResult(symbol, res)
 	return nil
 }
 
-func HandleGetInfo(p *Command) error {
-	symbols, err := p.Cfg.Query.GetAllSymbols(context.TODO())
-	if err != nil {
-		return err
-	}
-	limit := 80
-	endChan := make(chan bool)
-	for _, s := range symbols {
-		go getSymbolInfo(p, s, limit, endChan)
+// historyStart returns the first of the HistoricalTimeFrame trading days
+// fetched for a new symbol, up to to.
+func historyStart(p *Command, to time.Time) time.Time {
+	from := to
+	for i := 1; i < p.Cfg.HistoricalTimeFrame; i++ {
+		from = calendar.PrevTradingDay(from)
 	}
+	return from
+}
 
-	for range symbols {
-		<-endChan
+func printUpsertResult(symbol string, res *db.UpsertResult) {
+	if res == nil {
+		return
 	}
-	return nil
+	fmt.Printf("%s: inserted %d, updated %d, unchanged %d", symbol, res.Inserted, res.Updated, res.Unchanged)
+	if res.Failed > 0 {
+		fmt.Printf(", failed %d", res.Failed)
+	}
+	fmt.Println()
 }
 
-func getSymbolInfo(p *Command, s models.Symbol, limit int, endChan chan bool) {
-	prices, err := p.Cfg.Query.GetStockPrices(context.TODO(), &db.GetStockPriceOpt{
-		Symbol: s.Symbol,
-		Limit:  int64(limit),
-	})
-
-	if err != nil {
-		fmt.Printf("Cannot get info for symbol: %s\n", s.Symbol)
-		endChan <- false
-		return
+// flushContext returns the context to store fetched prices with. Once p.Ctx is
+// done the prices already fetched are still written, within flushTimeout.
+func flushContext(p *Command) (context.Context, context.CancelFunc) {
+	if p.Ctx.Err() == nil {
+		return p.Ctx, func() {}
 	}
 
-	if len(prices) != limit {
-		fmt.Printf("Insufficient data point for symbol: %s\n", s.Symbol)
-		endChan <- false
+	fmt.Println("Interrupted, storing the stock prices fetched so far")
+	return context.WithTimeout(context.WithoutCancel(p.Ctx), flushTimeout)
+}
+
+// printKeyStatus lists the api keys that ended the run rate limited or revoked.
+func printKeyStatus(p *Command) {
+	reporter, ok := p.Cfg.ApiClient.(stonkapi.KeyReporter)
+	if !ok {
 		return
 	}
 
-	slices.Reverse(prices)
+	for _, status := range reporter.KeyStatus() {
+		switch status.State {
+		case stonkapi.KEY_RATE_LIMITED:
+			fmt.Printf("Api key %s: %s until %s, %d requests\n", status.Key, status.State, status.Until.Format(time.TimeOnly), status.Requests)
+		case stonkapi.KEY_REVOKED:
+			fmt.Printf("Api key %s: %s, %d requests\n", status.Key, status.State, status.Requests)
+		}
+	}
+}
 
-	pr := models.Price{}
+// reportMissingDays prints the trading days the provider returned no bar for.
+// Holidays are never requested, so a gap here is worth looking into.
+func reportMissingDays(symbol string, days []time.Time, stocks []models.StockData) {
+	fetched := map[string]bool{}
+	for _, s := range stocks {
+		fetched[s.From] = true
+	}
 
-	for i, p := range prices {
-		if i == 0 {
-			pr = p
+	for _, d := range days {
+		if !fetched[d.Format("2006-01-02")] {
+			fmt.Printf("Missing stock price for symbol: %s on %s\n", symbol, d.Format("2006-01-02"))
 		}
-		price := calculation.PriceCal{
-			Open:  p.Open,
-			Close: p.Close,
-			High:  p.High,
-			Low:   p.Low,
+	}
+}
+
+func HandleGetInfo(p *Command) error {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	filter := addSymbolFilterFlags(fs)
+	err := fs.Parse(p.Input)
+	if err != nil {
+		return err
+	}
+
+	symbols, err := p.Cfg.Query.GetAllSymbols(p.Ctx)
+	if err != nil {
+		return err
+	}
+	symbols, err = filter.apply(p, symbols)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(symbols))
+	for _, s := range symbols {
+		if s.Archived {
+			continue
 		}
+		names = append(names, s.Symbol)
+	}
+	sort.Strings(names)
+
+	latest, err := p.Cfg.Query.GetLatestStockPrices(p.Ctx, names, 2)
+	if err != nil {
+		fmt.Println("Cannot get info for symbols")
+		return err
+	}
 
-		prev := calculation.PriceCal{
-			Open:  pr.Open,  // HA
-			Close: pr.Close, // HA
+	for _, name := range names {
+		signal, ok := symbolSignal(name, latest[name])
+		if !ok {
+			continue
 		}
-		po := calculation.GetHeikinDailyOpen(&prev)
-		pc := calculation.GetHeikinDailyClose(&price)
+		printSignal(signal)
 
-		pr = models.Price{
-			Open:  po,
-			Close: pc,
+		err := recordSignal(p, signal)
+		if err != nil {
+			fmt.Printf("Error recording signal for symbol: %s\n", name)
+			return err
 		}
 	}
+	return nil
+}
+
+// symbolSignal evaluates the stored heikin ashi candle of the latest two
+// prices, the signals of a day only depend on its price and the candle before
+// it. It prints why when there is nothing to evaluate.
+func symbolSignal(symbol string, prices []models.Price) (models.Signal, bool) {
+	if len(prices) != 2 {
+		fmt.Printf("Insufficient data point for symbol: %s\n", symbol)
+		return models.Signal{}, false
+	}
+
+	latest, before := prices[0], prices[1]
+	if latest.HAOpen == 0 && latest.HAClose == 0 {
+		fmt.Printf("No heikin ashi values for symbol: %s, run rebuild-ha %s\n", symbol, symbol)
+		return models.Signal{}, false
+	}
 
 	price := calculation.PriceCal{
-		Open:  prices[limit-1].Open,
-		Close: prices[limit-1].Close,
-		High:  prices[limit-1].High,
-		Low:   prices[limit-1].Low,
+		Open:  latest.Open,
+		Close: latest.Close,
+		High:  latest.High,
+		Low:   latest.Low,
 	}
 
 	prev := calculation.PriceCal{
-		Open:  pr.Open,  // HA
-		Close: pr.Close, // HA
-	}
-
-	o := calculation.GetHeikinDailyOpen(&prev)
-	c := calculation.GetHeikinDailyClose(&price)
-	h := calculation.GetHeikinDailyHigh(&price, &prev)
-	l := calculation.GetHeikinDailyLow(&price, &prev)
-	u := calculation.GetIsUptrend(&price, &prev)
-	bu := calculation.GetIsBull(&price, &prev)
-	be := calculation.GetIsBear(&price, &prev)
-	st := calculation.GetIsSpinningTop(&price, &prev)
-	ds := calculation.GetIsDojiStar(&price, &prev)
-	g := calculation.GetIsGravestoneDoji(&price, &prev)
-	sen := getSentiment(u, bu, be, st, ds, g)
+		Open:  before.HAOpen,  // HA
+		Close: before.HAClose, // HA
+	}
+
+	pt := calculation.GetPatterns(&price, &prev)
+
+	return models.Signal{
+		Symbol:      symbol,
+		Date:        latest.Date,
+		HAOpen:      latest.HAOpen,
+		HAHigh:      latest.HAHigh,
+		HALow:       latest.HALow,
+		HAClose:     latest.HAClose,
+		Uptrend:     pt.Uptrend,
+		Bull:        pt.Bull,
+		Bear:        pt.Bear,
+		SpinningTop: pt.SpinningTop,
+		Doji:        pt.Doji,
+		Gravestone:  pt.Gravestone,
+		Sentiment:   pt.Sentiment(),
+		RuleVersion: calculation.RULE_VERSION,
+		EvaluatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}, true
+}
 
+func printSignal(s models.Signal) {
 	fmt.Printf("------------------------------------\nDate: %s\nSymbol: %s\nOHLC: %.2f, %.2f, %.2f, %.2f\nUptrend: %v\nBull: %v\nBear: %v\nSpinningTop: %v\nDoji: %v\nGrave: %v \nSentiment: %s\n",
-		prices[limit-1].Date.Time().Format("2006-01-02"), s.Symbol, o, h, l, c, u, bu, be, st, ds, g, sen)
-	endChan <- true
+		s.Date.Time().Format("2006-01-02"), s.Symbol, s.HAOpen, s.HAHigh, s.HALow, s.HAClose, s.Uptrend, s.Bull, s.Bear, s.SpinningTop, s.Doji, s.Gravestone, s.Sentiment)
 }
 
-func getPriceConcurrently(errChan chan error, stockChan chan models.StockData, symbol string, date time.Time, p *Command) {
-	stockData, err := p.Cfg.ApiClient.GetPrices(symbol, date.Format("2006-01-02"))
-	if err != nil { // holiday will cause error, so it is ok to swallow
-		errChan <- err
-		return
+// HandleRebuildHeikinAshi recomputes every stored heikin ashi candle of the
+// given symbols, e.g. after prices were corrected or imported out of order.
+func HandleRebuildHeikinAshi(p *Command) error {
+	fs := flag.NewFlagSet("rebuild-ha", flag.ContinueOnError)
+	filter := addSymbolFilterFlags(fs)
+	err := fs.Parse(p.Input)
+	if err != nil {
+		return err
 	}
-	stockChan <- stockData
-}
 
-func getPriceChanSubscriber(errChan chan error, stockChan chan models.StockData, length int) *[]models.StockData {
-	stocks := []models.StockData{}
-	count := 0
-	ended := false
-	for !ended {
-		select {
-		case err := <-errChan:
-			count++
-			fmt.Printf("Error fetching stock price for symbol for %d days: %v\n", length, err)
-			if count == length {
-				ended = true
-			}
-		case s := <-stockChan:
-			count++
-			stocks = append(stocks, s)
-			if count == length {
-				ended = true
-			}
+	inputs := fs.Args()
+	if !filter.empty() {
+		symbols, err := p.Cfg.Query.GetAllSymbols(p.Ctx)
+		if err != nil {
+			return err
+		}
+		symbols, err = filter.apply(p, symbols)
+		if err != nil {
+			return err
+		}
+		for _, s := range symbols {
+			inputs = append(inputs, s.Symbol)
 		}
 	}
-	return &stocks
-}
+	if len(inputs) == 0 && filter.empty() {
+		return errors.New("Please provide a stonk symbol, -watchlist or -tag")
+	}
 
-func truncateToDay(t time.Time) time.Time {
-	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
-}
+	for _, input := range inputs {
+		tracked, err := resolveSymbol(p, input)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		symbol := tracked.Symbol
 
-func getSentiment(u, bu, be, st, ds, g bool) string {
-	if !u && ds {
-		return "buy"
-	}
-	if u && ds {
-		return "sell"
-	}
-	if u && st {
-		return "hold, sell"
-	}
-	if be {
-		return "SELL"
-	}
-	if bu {
-		return "hold, add"
-	}
-	if g {
-		return "sell"
-	}
-	if u && st {
-		return "hold"
-	}
-	if !u {
-		return "sell"
-	}
-	if u {
-		return "hold"
+		updated, err := p.Cfg.Query.UpdateHeikinAshi(p.Ctx, symbol, time.Unix(0, 0).UTC())
+		if err != nil {
+			fmt.Printf("Error rebuilding heikin ashi for symbol: %s\n", symbol)
+			return err
+		}
+		fmt.Printf("%s: rebuilt %d heikin ashi candles\n", symbol, updated)
 	}
-	return "no action"
+	return nil
 }
@@ -0,0 +1,767 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/calculation"
+	"github.com/jingen11/stonk-tracker/internal/calendar"
+	"github.com/jingen11/stonk-tracker/internal/db"
+	"github.com/jingen11/stonk-tracker/internal/models"
+	stonkapi "github.com/jingen11/stonk-tracker/internal/stonkApi"
+	"github.com/jingen11/stonk-tracker/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeProvider returns one bar per trading day in the requested range.
+type fakeProvider struct{}
+
+func (f fakeProvider) Name() string {
+	return "fake"
+}
+
+func (f fakeProvider) Capabilities() stonkapi.Capabilities {
+	return stonkapi.Capabilities{RangeQueries: true}
+}
+
+func (f fakeProvider) GetPrices(ctx context.Context, symbol, date string) (models.StockData, error) {
+	return models.StockData{Status: "OK", Symbol: symbol, From: date, Open: 10, High: 12, Low: 9, Close: 11, Volume: 1000}, nil
+}
+
+func (f fakeProvider) GetPricesRange(ctx context.Context, symbol, from, to string) ([]models.StockData, error) {
+	fromDate, _ := time.Parse("2006-01-02", from)
+	toDate, _ := time.Parse("2006-01-02", to)
+
+	stocks := []models.StockData{}
+	for _, d := range calendar.TradingDaysBetween(fromDate, toDate) {
+		s, _ := f.GetPrices(ctx, symbol, d.Format("2006-01-02"))
+		stocks = append(stocks, s)
+	}
+	return stocks, nil
+}
+
+func testCommand(input ...string) *Command {
+	return &Command{
+		Ctx: context.Background(),
+		Cfg: utils.ProjectConfig{
+			ApiClient:           fakeProvider{},
+			Query:               db.InitMemoryStore(),
+			HistoricalTimeFrame: 10,
+			FetchConcurrency:    2,
+		},
+		Input: input,
+	}
+}
+
+func TestHandlerAddNewSymbol(t *testing.T) {
+	p := testCommand("AAPL")
+
+	err := HandlerAddNewSymbol(p)
+	if err != nil {
+		t.Fatalf("error adding symbol: %v", err)
+	}
+
+	prices, err := p.Cfg.Query.GetStockPrices(p.Ctx, &db.GetStockPriceOpt{Symbol: "AAPL", Limit: 100})
+	if err != nil {
+		t.Fatalf("error getting prices: %v", err)
+	}
+	if len(prices) != 10 {
+		t.Fatalf("expected 10 prices, got %d", len(prices))
+	}
+
+	p.Input = []string{}
+	if HandlerAddNewSymbol(p) == nil {
+		t.Fatalf("expected an error without a symbol")
+	}
+}
+
+func TestHandleRefresh(t *testing.T) {
+	p := testCommand()
+	to := calendar.PrevTradingDay(time.Now())
+	from := calendar.PrevTradingDay(calendar.PrevTradingDay(calendar.PrevTradingDay(to)))
+
+	_, err := p.Cfg.Query.ImportStockPrices(p.Ctx, "IBM", []models.Price{{
+		Date:  primitive.NewDateTimeFromTime(from),
+		Open:  10,
+		High:  12,
+		Low:   9,
+		Close: 11,
+	}})
+	if err != nil {
+		t.Fatalf("error importing price: %v", err)
+	}
+
+	err = HandleRefresh(p)
+	if err != nil {
+		t.Fatalf("error refreshing: %v", err)
+	}
+
+	prices, _ := p.Cfg.Query.GetStockPrices(p.Ctx, &db.GetStockPriceOpt{Symbol: "IBM", Limit: 100})
+	if len(prices) != 4 {
+		t.Fatalf("expected 4 prices, got %d", len(prices))
+	}
+
+	symbols, _ := p.Cfg.Query.GetAllSymbols(p.Ctx)
+	if len(symbols) != 1 || !symbols[0].LastFetchedDate.Time().Equal(to) {
+		t.Fatalf("expected IBM to be fetched up to %s, got %+v", to.Format("2006-01-02"), symbols)
+	}
+}
+
+func TestHandleImport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aapl.csv")
+	input := `Trade Date,Open,High,Low,Close,Volume
+02/07/2025,232.60,234.00,227.26,227.63,39707200
+02/10/2025,229.57,230.59,227.20,227.65,33115600
+not a date,232.60,234.00,227.26,227.63,39707200
+`
+	os.WriteFile(path, []byte(input), 0644)
+
+	p := testCommand("-symbol", "aapl", "-map", "date=Trade Date", "-date-format", "01/02/2006", path)
+
+	err := HandleImport(p)
+	if err != nil {
+		t.Fatalf("error importing: %v", err)
+	}
+
+	prices, _ := p.Cfg.Query.GetStockPrices(p.Ctx, &db.GetStockPriceOpt{Symbol: "AAPL", Limit: 100})
+	if len(prices) != 2 {
+		t.Fatalf("expected 2 prices, got %d", len(prices))
+	}
+
+	p.Input = []string{}
+	if HandleImport(p) == nil {
+		t.Fatalf("expected an error without a file")
+	}
+}
+
+func TestHandleRebuildHeikinAshi(t *testing.T) {
+	p := testCommand("AAPL")
+
+	err := HandlerAddNewSymbol(p)
+	if err != nil {
+		t.Fatalf("error adding symbol: %v", err)
+	}
+
+	p.Input = []string{"AAPL", "MISSING"}
+	err = HandleRebuildHeikinAshi(p)
+	if err != nil {
+		t.Fatalf("error rebuilding heikin ashi: %v", err)
+	}
+
+	prices, _ := p.Cfg.Query.GetStockPrices(p.Ctx, &db.GetStockPriceOpt{Symbol: "AAPL", Limit: 100})
+	for i, price := range prices {
+		if price.HAOpen == 0 || price.HAClose == 0 || price.HAHigh == 0 || price.HALow == 0 {
+			t.Fatalf("Test case %d: expected heikin ashi values, got %+v", i, price)
+		}
+	}
+
+	p.Input = []string{}
+	if HandleRebuildHeikinAshi(p) == nil {
+		t.Fatalf("expected an error without a symbol")
+	}
+}
+
+func TestHandleGetInfo(t *testing.T) {
+	p := testCommand()
+
+	for _, symbol := range []string{"MSFT", "AAPL"} {
+		p.Input = []string{symbol}
+		err := HandlerAddNewSymbol(p)
+		if err != nil {
+			t.Fatalf("error adding symbol: %v", err)
+		}
+	}
+	p.Cfg.Query.ImportStockPrices(p.Ctx, "NEW", []models.Price{{Date: primitive.NewDateTimeFromTime(time.Now().AddDate(0, 0, -1)), Open: 1, High: 1, Low: 1, Close: 1}})
+
+	p.Input = []string{}
+	err := HandleGetInfo(p)
+	if err != nil {
+		t.Fatalf("error getting info: %v", err)
+	}
+}
+
+func TestHandleSignals(t *testing.T) {
+	p := testCommand("AAPL")
+
+	err := HandlerAddNewSymbol(p)
+	if err != nil {
+		t.Fatalf("error adding symbol: %v", err)
+	}
+
+	// evaluating the same bar again stores another evaluation
+	for range 2 {
+		p.Input = []string{}
+		err := HandleGetInfo(p)
+		if err != nil {
+			t.Fatalf("error getting info: %v", err)
+		}
+	}
+	signals, _ := p.Cfg.Query.GetSignals(p.Ctx, &db.GetSignalOpt{Symbol: "AAPL"})
+	if len(signals) != 2 || signals[0].Date != signals[1].Date || signals[1].EvaluatedAt < signals[0].EvaluatedAt ||
+		signals[1].RuleVersion != calculation.RULE_VERSION || signals[1].Sentiment == "" {
+		t.Fatalf("expected two evaluations of the same AAPL bar, got %+v", signals)
+	}
+	if bars := latestPerBar(signals); len(bars) != 1 || bars[0].Id != signals[1].Id {
+		t.Fatalf("expected the last evaluation of the bar, got %+v", bars)
+	}
+
+	cases := []struct {
+		input []string
+		fails bool
+	}{
+		{input: []string{}},
+		{input: []string{"AAPL"}},
+		{input: []string{"-from", "2025-01-02", "-to", "2025-01-03", "aapl"}},
+		{input: []string{"-from", "yesterday", "AAPL"}, fails: true},
+		{input: []string{"MISSING"}, fails: true},
+		{input: []string{"-watchlist", "missing"}, fails: true},
+	}
+	for i, c := range cases {
+		p.Input = c.input
+		err := HandleSignals(p)
+		if (err != nil) != c.fails {
+			t.Fatalf("Test case %d: expected failure %v, got %v", i, c.fails, err)
+		}
+	}
+}
+
+func TestHandleBacktest(t *testing.T) {
+	p := testCommand("AAPL")
+
+	err := HandlerAddNewSymbol(p)
+	if err != nil {
+		t.Fatalf("error adding symbol: %v", err)
+	}
+
+	cases := []struct {
+		input []string
+		fails bool
+	}{
+		{input: []string{"AAPL"}},
+		{input: []string{"-capital", "500", "-commission", "0", "-slippage-bps", "10", "-rules", "sell=buy;hold=sell", "aapl"}},
+		{input: []string{"-from", "2999-01-01", "AAPL"}},
+		{input: []string{"-from", "2025-01-08", "AAPL", "aapl"}},
+		{input: []string{"-add", "0", "AAPL"}, fails: true},
+		{input: []string{"-add", "1.5", "AAPL"}, fails: true},
+		{input: []string{"-slippage-bps", "-5", "AAPL"}, fails: true},
+		{input: []string{"-commission", "-1", "AAPL"}, fails: true},
+		{input: []string{"-capital", "-100", "AAPL"}, fails: true},
+		{input: []string{"-rules", "hold=short", "AAPL"}, fails: true},
+		{input: []string{"-from", "yesterday", "AAPL"}, fails: true},
+		{input: []string{"MISSING"}, fails: true},
+		{input: []string{}, fails: true},
+	}
+	for i, c := range cases {
+		p.Input = c.input
+		err := HandleBacktest(p)
+		if (err != nil) != c.fails {
+			t.Fatalf("Test case %d: expected failure %v, got %v", i, c.fails, err)
+		}
+	}
+
+	for _, input := range [][]string{{"create", "core"}, {"add", "core", "AAPL"}} {
+		p.Input = input
+		err := HandleWatchlist(p)
+		if err != nil {
+			t.Fatalf("error setting up watchlist: %v", err)
+		}
+	}
+	fs := flag.NewFlagSet("backtest", flag.ContinueOnError)
+	filter := addSymbolFilterFlags(fs)
+	fs.Parse([]string{"-watchlist", "core"})
+	symbols, err := backtestSymbols(p, []string{"AAPL", "aapl"}, filter)
+	if err != nil || !slices.Equal(symbols, []string{"AAPL"}) {
+		t.Fatalf("expected AAPL backtested once, got %v, %v", symbols, err)
+	}
+
+	prices, err := loadPrices(p, "AAPL", time.Time{}, time.Time{})
+	if err != nil || len(prices) != 10 || prices[0].Date > prices[9].Date {
+		t.Fatalf("expected the 10 prices of AAPL oldest first, got %+v, %v", prices, err)
+	}
+}
+
+func TestLastSentimentChange(t *testing.T) {
+	signal := func(date string, sentiment string) models.Signal {
+		d, _ := time.Parse("2006-01-02", date)
+		return models.Signal{Date: primitive.NewDateTimeFromTime(d), Sentiment: sentiment}
+	}
+
+	cases := []struct {
+		signals  []models.Signal
+		since    string
+		previous string
+	}{
+		{signals: []models.Signal{signal("2025-01-02", "buy")}, since: "2025-01-02"},
+		{
+			signals: []models.Signal{signal("2025-01-02", "buy"), signal("2025-01-03", "hold"), signal("2025-01-06", "hold")},
+			since:   "2025-01-03", previous: "buy",
+		},
+		{
+			// the last evaluation of a bar counts
+			signals: []models.Signal{signal("2025-01-02", "buy"), signal("2025-01-03", "hold"), signal("2025-01-03", "buy")},
+			since:   "2025-01-02",
+		},
+	}
+	for i, c := range cases {
+		since, previous := lastSentimentChange(latestPerBar(c.signals))
+		if since.Date.Time().UTC().Format("2006-01-02") != c.since || previous != c.previous {
+			t.Fatalf("Test case %d: expected %s since %s, got %s since %+v", i, c.previous, c.since, previous, since)
+		}
+	}
+}
+
+func TestHandleMigrateTimeSeries(t *testing.T) {
+	p := testCommand()
+
+	if HandleMigrateTimeSeries(p) == nil {
+		t.Fatalf("expected an error outside of the mongo store")
+	}
+}
+
+func TestHandleMigrate(t *testing.T) {
+	p := testCommand("status")
+
+	err := HandleMigrate(p)
+	if err != nil {
+		t.Fatalf("error getting migration status: %v", err)
+	}
+
+	p.Input = []string{"down"}
+	if HandleMigrate(p) == nil {
+		t.Fatalf("expected an error for an unknown subcommand")
+	}
+}
+
+func TestHandleRepair(t *testing.T) {
+	p := testCommand("-dry-run")
+
+	err := HandleRepair(p)
+	if err != nil {
+		t.Fatalf("error repairing: %v", err)
+	}
+
+	p.Input = []string{"-unknown"}
+	if HandleRepair(p) == nil {
+		t.Fatalf("expected an error for an unknown flag")
+	}
+}
+
+func TestHandleExport(t *testing.T) {
+	p := testCommand("AAPL")
+
+	err := HandlerAddNewSymbol(p)
+	if err != nil {
+		t.Fatalf("error adding symbol: %v", err)
+	}
+	prices, _ := p.Cfg.Query.GetStockPrices(p.Ctx, &db.GetStockPriceOpt{Symbol: "AAPL", Order: db.ASCENDING})
+	from := prices[3].Date.Time().Format("2006-01-02")
+
+	cases := []struct {
+		input    []string
+		expected int
+	}{
+		{input: []string{"aapl"}, expected: 11},
+		{input: []string{"-from", from}, expected: 8},
+		{input: []string{"-format", "jsonl", "-from", from, "-to", from}, expected: 1},
+	}
+
+	for i, c := range cases {
+		path := filepath.Join(t.TempDir(), "prices.csv")
+		p.Input = append([]string{"-o", path}, c.input...)
+
+		err := HandleExport(p)
+		if err != nil {
+			t.Fatalf("Test case %d: error exporting: %v", i, err)
+		}
+
+		data, _ := os.ReadFile(path)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != c.expected {
+			t.Fatalf("Test case %d: expected %d lines, got %d", i, c.expected, len(lines))
+		}
+	}
+
+	p.Input = []string{"-from", "yesterday"}
+	if HandleExport(p) == nil {
+		t.Fatalf("expected an error for an invalid date")
+	}
+}
+
+func TestSymbolLifecycle(t *testing.T) {
+	p := testCommand("FB")
+
+	err := HandlerAddNewSymbol(p)
+	if err != nil {
+		t.Fatalf("error adding symbol: %v", err)
+	}
+
+	p.Input = []string{"fb", "meta"}
+	err = HandleRename(p)
+	if err != nil {
+		t.Fatalf("error renaming symbol: %v", err)
+	}
+	p.Input = []string{"FB"}
+	if HandleRename(p) == nil {
+		t.Fatalf("expected an error without a new symbol")
+	}
+	p.Input = []string{"META", "meta"}
+	if HandleRename(p) == nil {
+		t.Fatalf("expected an error renaming a symbol to itself")
+	}
+	prices, _ := p.Cfg.Query.GetStockPrices(p.Ctx, &db.GetStockPriceOpt{Symbol: "META", Limit: 100})
+	if len(prices) != 10 {
+		t.Fatalf("expected META to keep its 10 prices, got %d", len(prices))
+	}
+
+	// prices imported under the old ticker land on the renamed symbol
+	path := filepath.Join(t.TempDir(), "fb.csv")
+	os.WriteFile(path, []byte("symbol,date,open,high,low,close,volume\nFB,2012-05-18,42.05,45.00,38.00,38.23,573576400\n"), 0644)
+	p.Input = []string{path}
+	err = HandleImport(p)
+	if err != nil {
+		t.Fatalf("error importing: %v", err)
+	}
+	prices, _ = p.Cfg.Query.GetStockPrices(p.Ctx, &db.GetStockPriceOpt{Symbol: "META", Limit: 100})
+	if len(prices) != 11 {
+		t.Fatalf("expected the imported FB price on META, got %d prices", len(prices))
+	}
+
+	// the old ticker resolves to the renamed symbol
+	p.Input = []string{"FB"}
+	err = HandleArchive(p)
+	if err != nil {
+		t.Fatalf("error archiving symbol: %v", err)
+	}
+	if HandlerAddNewSymbol(p) == nil {
+		t.Fatalf("expected an error adding an archived symbol")
+	}
+	symbols, _ := p.Cfg.Query.GetAllSymbols(p.Ctx)
+	if len(symbols) != 1 || symbols[0].Symbol != "META" || !symbols[0].Archived {
+		t.Fatalf("expected META archived, got %+v", symbols)
+	}
+
+	p.Input = []string{}
+	err = HandleRefresh(p)
+	if err != nil {
+		t.Fatalf("error refreshing: %v", err)
+	}
+
+	cases := []struct {
+		input   []string
+		answer  string
+		removed bool
+	}{
+		{input: []string{"META"}, answer: "n\n", removed: false},
+		{input: []string{"META"}, answer: "", removed: false},
+		{input: []string{"META"}, answer: "y\n", removed: true},
+	}
+	for i, c := range cases {
+		stdin = strings.NewReader(c.answer)
+		p.Input = c.input
+		err := HandleRemove(p)
+		if err != nil {
+			t.Fatalf("Test case %d: error removing symbol: %v", i, err)
+		}
+
+		prices, _ := p.Cfg.Query.GetStockPrices(p.Ctx, &db.GetStockPriceOpt{Symbol: "META"})
+		if (len(prices) == 0) != c.removed {
+			t.Fatalf("Test case %d: expected removed %v, got %d prices", i, c.removed, len(prices))
+		}
+	}
+	stdin = os.Stdin
+
+	p.Input = []string{"-yes", "META"}
+	if HandleRemove(p) == nil {
+		t.Fatalf("expected an error removing an unknown symbol")
+	}
+}
+
+func TestHandleWatchlist(t *testing.T) {
+	p := testCommand()
+
+	for _, symbol := range []string{"AAPL", "MSFT", "NVDA"} {
+		p.Input = []string{symbol}
+		err := HandlerAddNewSymbol(p)
+		if err != nil {
+			t.Fatalf("error adding symbol: %v", err)
+		}
+	}
+
+	commands := []struct {
+		handler func(*Command) error
+		input   []string
+		fails   bool
+	}{
+		{handler: HandleWatchlist, input: []string{"create", "core"}},
+		{handler: HandleWatchlist, input: []string{"create", "core"}, fails: true},
+		{handler: HandleWatchlist, input: []string{"add", "core", "aapl", "MSFT"}},
+		{handler: HandleWatchlist, input: []string{"add", "missing", "AAPL"}, fails: true},
+		{handler: HandleWatchlist, input: []string{"add", "core", "MISSING"}, fails: true},
+		{handler: HandleWatchlist, input: []string{"remove", "core", "MSFT"}},
+		{handler: HandleWatchlist, input: []string{"list"}},
+		{handler: HandleWatchlist, input: []string{"list", "missing"}, fails: true},
+		{handler: HandleWatchlist, input: []string{"rename"}, fails: true},
+		{handler: HandleTag, input: []string{"add", "NVDA", "Semis", "ai"}},
+		{handler: HandleTag, input: []string{"add", "MSFT", "ai"}},
+		{handler: HandleTag, input: []string{"remove", "NVDA", "ai"}},
+		{handler: HandleTag, input: []string{"list"}},
+		{handler: HandleTag, input: []string{"add", "NVDA"}, fails: true},
+	}
+	for i, c := range commands {
+		p.Input = c.input
+		err := c.handler(p)
+		if (err != nil) != c.fails {
+			t.Fatalf("Test case %d: expected failure %v, got %v", i, c.fails, err)
+		}
+	}
+
+	cases := []struct {
+		input    []string
+		expected []string
+		fails    bool
+	}{
+		{input: []string{}, expected: []string{"AAPL", "MSFT", "NVDA"}},
+		{input: []string{"-watchlist", "core"}, expected: []string{"AAPL"}},
+		{input: []string{"--tag", "SEMIS"}, expected: []string{"NVDA"}},
+		{input: []string{"-tag", "ai", "-watchlist", "core"}, expected: []string{}},
+		{input: []string{"-watchlist", "missing"}, fails: true},
+	}
+	for i, c := range cases {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		filter := addSymbolFilterFlags(fs)
+		fs.Parse(c.input)
+
+		symbols, _ := p.Cfg.Query.GetAllSymbols(p.Ctx)
+		selected, err := filter.apply(p, symbols)
+		if (err != nil) != c.fails {
+			t.Fatalf("Test case %d: expected failure %v, got %v", i, c.fails, err)
+		}
+		names := []string{}
+		for _, s := range selected {
+			names = append(names, s.Symbol)
+		}
+		if !c.fails && !slices.Equal(names, c.expected) {
+			t.Fatalf("Test case %d: expected %v, got %v", i, c.expected, names)
+		}
+
+		p.Input = c.input
+		if err := HandleGetInfo(p); (err != nil) != c.fails {
+			t.Fatalf("Test case %d: expected info failure %v, got %v", i, c.fails, err)
+		}
+	}
+
+	p.Input = []string{"-watchlist", "core"}
+	err := HandleRefresh(p)
+	if err != nil {
+		t.Fatalf("error refreshing watchlist: %v", err)
+	}
+}
+
+func TestHandleTrade(t *testing.T) {
+	p := testCommand("AAPL")
+
+	err := HandlerAddNewSymbol(p)
+	if err != nil {
+		t.Fatalf("error adding symbol: %v", err)
+	}
+
+	cases := []struct {
+		input []string
+		fails bool
+	}{
+		{input: []string{"buy", "-date", "2025-01-02", "-fees", "1", "aapl", "10", "9"}},
+		{input: []string{"buy", "-date", "2025-01-03", "MSFT", "2", "400"}},
+		{input: []string{"sell", "-date", "2025-01-06", "AAPL", "4", "12"}},
+		{input: []string{"dividend", "-date", "2025-01-07", "AAPL", "1.5"}},
+		{input: []string{"split", "-date", "2025-01-08", "AAPL", "2:1"}},
+		{input: []string{"sell", "-date", "2025-01-09", "AAPL", "13", "12"}, fails: true},
+		{input: []string{"sell", "-date", "2025-01-01", "AAPL", "1", "12"}, fails: true},
+		{input: []string{"buy", "AAPL", "-1", "12"}, fails: true},
+		{input: []string{"buy", "AAPL", "10"}, fails: true},
+		{input: []string{"split", "AAPL", "2:0"}, fails: true},
+		{input: []string{"transfer", "AAPL", "1"}, fails: true},
+		{input: []string{"list"}},
+		{input: []string{"list", "AAPL"}},
+		{input: []string{"delete", "nope"}, fails: true},
+		{input: []string{"delete", primitive.NewObjectID().Hex()}, fails: true},
+	}
+	for i, c := range cases {
+		p.Input = c.input
+		err := HandleTrade(p)
+		if (err != nil) != c.fails {
+			t.Fatalf("Test case %d: expected failure %v, got %v", i, c.fails, err)
+		}
+	}
+
+	trades, _ := p.Cfg.Query.GetTrades(p.Ctx, nil)
+	if len(trades) != 5 {
+		t.Fatalf("expected 5 trades, got %+v", trades)
+	}
+
+	p.Input = []string{"-all"}
+	err = HandlePortfolio(p)
+	if err != nil {
+		t.Fatalf("error showing portfolio: %v", err)
+	}
+
+	// the AAPL sell needs the shares of the buy
+	for _, trade := range trades {
+		if trade.Symbol == "AAPL" && trade.Type == "buy" {
+			p.Input = []string{"delete", trade.Id.Hex()}
+			if HandleTrade(p) == nil {
+				t.Fatalf("expected an error deleting the buy a sell needs")
+			}
+		}
+	}
+
+	p.Input = []string{"delete", trades[1].Id.Hex()}
+	err = HandleTrade(p)
+	if err != nil {
+		t.Fatalf("error deleting trade: %v", err)
+	}
+
+	// a ledger broken outside of trade only fails its own symbol
+	p.Cfg.Query.InsertTrade(p.Ctx, models.Trade{Symbol: "IBM", Type: "sell", Date: primitive.NewDateTimeFromTime(time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)), Quantity: 1, Price: 200})
+	p.Input = []string{}
+	err = HandlePortfolio(p)
+	if err != nil {
+		t.Fatalf("error showing portfolio with a broken ledger: %v", err)
+	}
+}
+
+func TestHandleGains(t *testing.T) {
+	p := testCommand("AAPL")
+
+	err := HandlerAddNewSymbol(p)
+	if err != nil {
+		t.Fatalf("error adding symbol: %v", err)
+	}
+
+	for _, input := range [][]string{
+		{"buy", "-date", "2023-01-03", "AAPL", "10", "100"},
+		{"buy", "-date", "2024-09-03", "AAPL", "10", "120"},
+	} {
+		p.Input = input
+		err := HandleTrade(p)
+		if err != nil {
+			t.Fatalf("error recording trade %v: %v", input, err)
+		}
+	}
+	trades, _ := p.Cfg.Query.GetTrades(p.Ctx, nil)
+
+	cases := []struct {
+		input []string
+		fails bool
+	}{
+		{input: []string{"sell", "-date", "2025-01-10", "-lots", "nope", "AAPL", "5", "130"}, fails: true},
+		{input: []string{"sell", "-date", "2025-01-10", "-lots", primitive.NewObjectID().Hex(), "AAPL", "5", "130"}, fails: true},
+		{input: []string{"sell", "-date", "2025-01-10", "-lots", trades[1].Id.Hex(), "AAPL", "11", "130"}, fails: true},
+		{input: []string{"sell", "-date", "2025-01-10", "-lots", trades[1].Id.Hex(), "AAPL", "5", "130"}},
+		{input: []string{"lots", "-method", "specific"}},
+		{input: []string{"lots", "-method", "average"}, fails: true},
+	}
+	for i, c := range cases {
+		p.Input = c.input
+		err := HandleTrade(p)
+		if (err != nil) != c.fails {
+			t.Fatalf("Test case %d: expected failure %v, got %v", i, c.fails, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "gains.csv")
+	p.Input = []string{"-year", "2025", "-method", "specific", "-o", path}
+	err = HandleGains(p)
+	if err != nil {
+		t.Fatalf("error reporting gains: %v", err)
+	}
+	b, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "AAPL,5,2024-09-03,2025-01-10,650.00,600.00,0.00,50.00,short,false,") {
+		t.Fatalf("expected the sell matched against the named lot, got %q", lines)
+	}
+
+	p.Input = []string{"-year", "2024"}
+	err = HandleGains(p)
+	if err != nil {
+		t.Fatalf("error reporting gains: %v", err)
+	}
+	p.Input = []string{"-method", "average"}
+	if err := HandleGains(p); err == nil {
+		t.Fatalf("expected an error on an unknown lot method")
+	}
+}
+
+func TestHandleTradeImport(t *testing.T) {
+	p := testCommand("FB")
+
+	err := HandlerAddNewSymbol(p)
+	if err != nil {
+		t.Fatalf("error adding symbol: %v", err)
+	}
+	p.Input = []string{"FB", "META"}
+	err = HandleRename(p)
+	if err != nil {
+		t.Fatalf("error renaming symbol: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "trades.csv")
+	os.WriteFile(path, []byte(`Symbol,TradeDate,Buy/Sell,Quantity,TradePrice,IBCommission,NetCash,TransactionID
+FB,20250203,BUY,10,700,-1,-7001,1001
+NVDA,20250204,BUY,5,120,-1,-601,1002
+NVDA,20250205,SELL,-9,125,-1,1124,1003
+NVDA,20250205,DEPOSIT,0,0,0,0,1004
+AMD,20250206,BUY,8,110,-1,-881,1005
+`), 0o644)
+
+	// NVDA sells more than the statement bought, nothing is recorded
+	p.Input = []string{"import", "-preset", "ibkr", path}
+	if HandleTrade(p) == nil {
+		t.Fatalf("expected an error importing an oversold ledger")
+	}
+	trades, _ := p.Cfg.Query.GetTrades(p.Ctx, nil)
+	if len(trades) != 0 {
+		t.Fatalf("expected no trades recorded, got %+v", trades)
+	}
+	if _, err := p.Cfg.Query.ResolveSymbol(p.Ctx, "AMD"); !errors.Is(err, db.ErrUnknownSymbol) {
+		t.Fatalf("expected AMD left untracked, got %v", err)
+	}
+
+	p.Input = []string{"import", "-preset", "ibkr", "-allow-partial", path}
+	err = HandleTrade(p)
+	if err != nil {
+		t.Fatalf("error importing trades: %v", err)
+	}
+	err = HandleTrade(p)
+	if err != nil {
+		t.Fatalf("error importing trades again: %v", err)
+	}
+
+	trades, _ = p.Cfg.Query.GetTrades(p.Ctx, nil)
+	if len(trades) != 2 || trades[0].Symbol != "META" || trades[1].Symbol != "AMD" {
+		t.Fatalf("expected the META and AMD trades with FB recorded as META, got %+v", trades)
+	}
+	if _, err := p.Cfg.Query.ResolveSymbol(p.Ctx, "NVDA"); !errors.Is(err, db.ErrUnknownSymbol) {
+		t.Fatalf("expected the skipped NVDA left untracked, got %v", err)
+	}
+
+	s, err := p.Cfg.Query.ResolveSymbol(p.Ctx, "AMD")
+	if err != nil {
+		t.Fatalf("expected AMD tracked, got %v", err)
+	}
+	expected := historyStart(p, calendar.PrevTradingDay(time.Now())).AddDate(0, 0, -1)
+	if !s.LastFetchedDate.Time().Equal(expected) {
+		t.Fatalf("expected AMD fetched from %s, got %s", expected, s.LastFetchedDate.Time())
+	}
+
+	p.Input = []string{"import", "-preset", "unknown", path}
+	if HandleTrade(p) == nil {
+		t.Fatalf("expected an error for an unknown preset")
+	}
+}
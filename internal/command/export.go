@@ -0,0 +1,172 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/db"
+	"github.com/jingen11/stonk-tracker/internal/exporter"
+	"github.com/jingen11/stonk-tracker/internal/models"
+)
+
+// number of prices read from the store at a time
+const exportPageSize = 1000
+
+func HandleExport(p *Command) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "", "csv, jsonl or parquet, guessed from the output extension when empty")
+	output := fs.String("o", "", "file to write, stdout when empty")
+	fromFlag := fs.String("from", "", "first date to export, YYYY-MM-DD")
+	toFlag := fs.String("to", "", "last date to export, YYYY-MM-DD")
+	filter := addSymbolFilterFlags(fs)
+
+	err := fs.Parse(p.Input)
+	if err != nil {
+		return err
+	}
+
+	var from, to time.Time
+	if *fromFlag != "" {
+		from, err = time.Parse("2006-01-02", *fromFlag)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid -from date: %s", *fromFlag))
+		}
+	}
+	if *toFlag != "" {
+		to, err = time.Parse("2006-01-02", *toFlag)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid -to date: %s", *toFlag))
+		}
+	}
+
+	symbols := []string{}
+	for _, s := range fs.Args() {
+		tracked, err := resolveSymbol(p, s)
+		if err != nil {
+			return err
+		}
+		symbols = append(symbols, tracked.Symbol)
+	}
+	if len(symbols) == 0 {
+		all, err := p.Cfg.Query.GetAllSymbols(p.Ctx)
+		if err != nil {
+			return err
+		}
+		all, err = filter.apply(p, all)
+		if err != nil {
+			return err
+		}
+		for _, s := range all {
+			symbols = append(symbols, s.Symbol)
+		}
+	}
+	if len(symbols) == 0 {
+		return errors.New("No symbol to export")
+	}
+
+	if *format == "" {
+		*format = guessExportFormat(*output)
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w, err := exporter.InitWriter(out, *format)
+	if err != nil {
+		return err
+	}
+
+	exported, err := exportPrices(p, w, &db.GetStockPriceOpt{
+		Symbols: symbols,
+		From:    from,
+		To:      to,
+		Order:   db.ASCENDING,
+		Limit:   exportPageSize,
+	})
+	if err != nil {
+		w.Close()
+		fmt.Println("Error exporting stock prices")
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	if *output != "" {
+		fmt.Printf("Exported %d prices of %d symbols to %s\n", exported, len(symbols), *output)
+	}
+	return nil
+}
+
+// exportPrices pages through the prices selected by opt, so only a page is
+// held in memory at a time, and returns how many were written.
+func exportPrices(p *Command, w exporter.Writer, opt *db.GetStockPriceOpt) (int, error) {
+	exported := 0
+	var prev *models.Price
+
+	for {
+		prices, err := p.Cfg.Query.GetStockPrices(p.Ctx, opt)
+		if err != nil {
+			return exported, err
+		}
+
+		for _, price := range prices {
+			if prev == nil || prev.Symbol != price.Symbol {
+				// the patterns of the first price need the candle before the range
+				prev, err = priceBefore(p, price)
+				if err != nil {
+					return exported, err
+				}
+			}
+
+			err := w.Write(exporter.NewRow(price, prev))
+			if err != nil {
+				return exported, err
+			}
+			exported++
+			prev = &price
+		}
+
+		if int64(len(prices)) < opt.Limit {
+			return exported, nil
+		}
+		opt.After = db.CursorAfter(prices[len(prices)-1])
+	}
+}
+
+func priceBefore(p *Command, price models.Price) (*models.Price, error) {
+	before, err := p.Cfg.Query.GetStockPrices(p.Ctx, &db.GetStockPriceOpt{
+		Symbol: price.Symbol,
+		To:     price.Date.Time().Add(-time.Millisecond),
+		Limit:  1,
+	})
+	if err != nil || len(before) == 0 {
+		return nil, err
+	}
+	return &before[0], nil
+}
+
+func guessExportFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".parquet":
+		return exporter.PARQUET
+	case ".jsonl", ".ndjson", ".json":
+		return exporter.JSONL
+	}
+	return exporter.CSV
+}
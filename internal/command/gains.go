@@ -0,0 +1,88 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/portfolio"
+)
+
+// HandleGains reports the gains realised by the sells of a year, each sell
+// matched against the lots it disposes of:
+//
+//	gains [-year YYYY] [-method fifo|lifo|hifo|specific] [-o FILE.csv] [SYMBOL...]
+//
+// Wash sales are marked W, the disallowed loss is added back to their gain.
+func HandleGains(p *Command) error {
+	fs := flag.NewFlagSet("gains", flag.ContinueOnError)
+	year := fs.Int("year", time.Now().UTC().Year(), "tax year of the sells")
+	method := fs.String("method", portfolio.FIFO, "lot method: "+strings.Join(portfolio.LotMethods, ", "))
+	output := fs.String("o", "", "csv file to write the realised gains to")
+
+	err := fs.Parse(p.Input)
+	if err != nil {
+		return err
+	}
+
+	symbols := []string{}
+	for _, arg := range fs.Args() {
+		symbols = append(symbols, tradeSymbol(p, arg))
+	}
+
+	trades, err := p.Cfg.Query.GetTrades(p.Ctx, symbols)
+	if err != nil {
+		fmt.Println("Error getting trades")
+		return err
+	}
+	result, err := portfolio.MatchLots(trades, *method)
+	if err != nil {
+		return err
+	}
+
+	disposals := []portfolio.Disposal{}
+	for _, d := range result.Disposals {
+		if d.Sold.Year() == *year {
+			disposals = append(disposals, d)
+		}
+	}
+
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		err = portfolio.WriteDisposalsCsv(f, disposals)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Exported %d disposals of %d to %s\n", len(disposals), *year, *output)
+	} else {
+		if len(disposals) == 0 {
+			fmt.Printf("No sell in %d\n", *year)
+			return nil
+		}
+
+		fmt.Printf("%-8s %10s %10s %10s %12s %12s %10s %12s %-7s\n", "SYMBOL", "QUANTITY", "ACQUIRED", "SOLD", "PROCEEDS", "COST", "ADJ", "GAIN", "TERM")
+		for _, d := range disposals {
+			term := d.Term()
+			if d.WashSale {
+				term += " W"
+			}
+			fmt.Printf("%-8s %10g %10s %10s %12.2f %12.2f %10.2f %12.2f %-7s\n", d.Symbol, d.Quantity,
+				d.Acquired.Format("2006-01-02"), d.Sold.Format("2006-01-02"), d.Proceeds, d.Cost, d.Adjustment, d.ReportedGain(), term)
+		}
+	}
+
+	summary := portfolio.Summarise(disposals)
+	fmt.Printf("Short term: %.2f\n", summary.ShortTerm)
+	fmt.Printf("Long term: %.2f\n", summary.LongTerm)
+	if summary.Disallowed != 0 {
+		fmt.Printf("Wash sales disallowed %.2f of losses\n", summary.Disallowed)
+	}
+	return nil
+}
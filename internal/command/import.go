@@ -0,0 +1,133 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jingen11/stonk-tracker/internal/db"
+	"github.com/jingen11/stonk-tracker/internal/importer"
+	"github.com/jingen11/stonk-tracker/internal/models"
+)
+
+// maximum number of rejected rows printed, the total is always reported
+const maxPrintedRejections = 20
+
+func HandleImport(p *Command) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "csv or jsonl, guessed from the file extension when empty")
+	symbol := fs.String("symbol", "", "symbol for rows without a symbol column")
+	mapping := fs.String("map", "", "column mapping, e.g. date=Trade Date,close=Adj Close")
+	dateLayout := fs.String("date-format", "", "go time layout of the date column")
+
+	err := fs.Parse(p.Input)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("Please provide at least one file to import")
+	}
+
+	columns, err := importer.ParseMapping(*mapping)
+	if err != nil {
+		return err
+	}
+
+	prices := map[string][]models.Price{}
+	rejected := 0
+
+	for _, path := range fs.Args() {
+		opt := importer.Options{
+			Format:     *format,
+			Symbol:     strings.ToUpper(*symbol),
+			Mapping:    columns,
+			DateLayout: *dateLayout,
+		}
+		if opt.Format == "" {
+			opt.Format = guessFormat(path)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		res, err := importer.Read(f, opt)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		for i, r := range res.Rejected {
+			if i == maxPrintedRejections {
+				fmt.Printf("%s: %d more rejected rows\n", path, len(res.Rejected)-i)
+				break
+			}
+			fmt.Printf("%s:%d rejected: %s\n", path, r.Line, r.Reason)
+		}
+		rejected += len(res.Rejected)
+
+		for _, price := range res.Prices {
+			prices[price.Symbol] = append(prices[price.Symbol], price)
+		}
+	}
+
+	prices, err = resolveImportSymbols(p, prices)
+	if err != nil {
+		return err
+	}
+
+	symbols := make([]string, 0, len(prices))
+	for s := range prices {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+
+	inserted := 0
+	duplicates := 0
+	for _, s := range symbols {
+		res, err := p.Cfg.Query.ImportStockPrices(p.Ctx, s, prices[s])
+		if err != nil {
+			fmt.Printf("Error importing stock price for symbol: %s\n", s)
+			return err
+		}
+		fmt.Printf("%s: inserted %d, skipped duplicate %d\n", s, res.Inserted, res.Duplicates)
+		inserted += res.Inserted
+		duplicates += res.Duplicates
+	}
+
+	fmt.Printf("Imported %d symbols: inserted %d, skipped duplicate %d, rejected %d\n", len(symbols), inserted, duplicates, rejected)
+	return nil
+}
+
+// resolveImportSymbols moves the prices of former tickers to the symbols they
+// were renamed to, instead of tracking the old ticker again.
+func resolveImportSymbols(p *Command, prices map[string][]models.Price) (map[string][]models.Price, error) {
+	resolved := map[string][]models.Price{}
+	for symbol, symbolPrices := range prices {
+		tracked, err := p.Cfg.Query.ResolveSymbol(p.Ctx, symbol)
+		if err != nil && !errors.Is(err, db.ErrUnknownSymbol) {
+			return nil, err
+		}
+		if err == nil && tracked.Symbol != symbol {
+			fmt.Printf("%s was renamed to %s\n", symbol, tracked.Symbol)
+			for i := range symbolPrices {
+				symbolPrices[i].Symbol = tracked.Symbol
+			}
+			symbol = tracked.Symbol
+		}
+		resolved[symbol] = append(resolved[symbol], symbolPrices...)
+	}
+	return resolved, nil
+}
+
+func guessFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson", ".json":
+		return importer.JSONL
+	}
+	return importer.CSV
+}
@@ -0,0 +1,75 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/db"
+)
+
+// HandleMigrate shows or applies the schema migrations of the store, with the
+// status and up subcommands.
+func HandleMigrate(p *Command) error {
+	if len(p.Input) != 1 || (p.Input[0] != "status" && p.Input[0] != "up") {
+		return errors.New("Please provide a migrate subcommand: status or up")
+	}
+
+	migrator, ok := p.Cfg.Query.(db.Migrator)
+	if !ok {
+		fmt.Println("The store has no schema to migrate")
+		return nil
+	}
+
+	if p.Input[0] == "up" {
+		applied, err := migrator.MigrateUp(p.Ctx)
+		for _, m := range applied {
+			fmt.Printf("Applied migration %d: %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+		return nil
+	}
+
+	statuses, err := migrator.MigrationStatus(p.Ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range statuses {
+		state := "pending"
+		if !m.AppliedAt.IsZero() {
+			state = "applied " + m.AppliedAt.Format(time.DateTime)
+		}
+		name := m.Name
+		if name == "" {
+			name = "unknown to this binary"
+		}
+		fmt.Printf("%3d  %-27s  %s\n", m.Version, state, name)
+	}
+	return nil
+}
+
+// HandleMigrateTimeSeries moves mongo prices into a time-series collection.
+// The plain collection is kept as price_plain until it is dropped by hand.
+func HandleMigrateTimeSeries(p *Command) error {
+	q, ok := p.Cfg.Query.(*db.Query)
+	if !ok {
+		return errors.New("The time-series layout is only available on the mongo store")
+	}
+
+	copied, err := q.MigrateToTimeSeries(p.Ctx)
+	if errors.Is(err, db.ErrTimeSeriesUnsupported) {
+		return err
+	}
+	if err != nil {
+		fmt.Printf("Migrated %d prices before failing, run migrate-timeseries again to resume\n", copied)
+		return err
+	}
+
+	fmt.Printf("Migrated %d prices to the time-series layout\n", copied)
+	return nil
+}
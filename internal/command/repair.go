@@ -0,0 +1,42 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+)
+
+// HandleRepair moves the lastFetchedDate of every symbol to its latest stored
+// price, after writes that were interrupted outside of a transaction.
+func HandleRepair(p *Command) error {
+	fs := flag.NewFlagSet("repair", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only list the symbols to repair")
+
+	err := fs.Parse(p.Input)
+	if err != nil {
+		return err
+	}
+
+	repairs, err := p.Cfg.Query.RepairLastFetchedDates(p.Ctx, *dryRun)
+	if err != nil {
+		fmt.Println("Error repairing symbols")
+		return err
+	}
+
+	for _, r := range repairs {
+		if r.Created {
+			fmt.Printf("%s: created with last fetched date %s\n", r.Symbol, r.To.Format("2006-01-02"))
+			continue
+		}
+		fmt.Printf("%s: last fetched date %s -> %s\n", r.Symbol, r.From.Format("2006-01-02"), r.To.Format("2006-01-02"))
+	}
+
+	switch {
+	case len(repairs) == 0:
+		fmt.Println("Every symbol is up to date")
+	case *dryRun:
+		fmt.Printf("%d symbols to repair, run repair without -dry-run to apply\n", len(repairs))
+	default:
+		fmt.Printf("Repaired %d symbols\n", len(repairs))
+	}
+	return nil
+}
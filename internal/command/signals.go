@@ -0,0 +1,188 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/db"
+	"github.com/jingen11/stonk-tracker/internal/models"
+)
+
+// recordSignal stores the evaluation of a bar. Evaluating the same bar again
+// stores another signal, the history tells when every evaluation ran and
+// latestPerBar picks the last one.
+func recordSignal(p *Command, signal models.Signal) error {
+	_, err := p.Cfg.Query.InsertSignal(p.Ctx, signal)
+	return err
+}
+
+// latestPerBar keeps the last evaluation of every bar of signals.
+func latestPerBar(signals []models.Signal) []models.Signal {
+	bars := []models.Signal{}
+	for _, s := range signals {
+		if len(bars) > 0 && bars[len(bars)-1].Date == s.Date {
+			bars[len(bars)-1] = s
+			continue
+		}
+		bars = append(bars, s)
+	}
+	return bars
+}
+
+// lastSentimentChange returns the first bar of the latest run of the same
+// sentiment and the sentiment before it, empty when it never changed.
+func lastSentimentChange(bars []models.Signal) (models.Signal, string) {
+	since := len(bars) - 1
+	for since > 0 && bars[since-1].Sentiment == bars[since].Sentiment {
+		since--
+	}
+	if since == 0 {
+		return bars[0], ""
+	}
+	return bars[since], bars[since-1].Sentiment
+}
+
+// HandleSignals shows the signals stored by info. Given symbols, it lists
+// their history and when the sentiment last changed:
+//
+//	signals [-from YYYY-MM-DD] [-to YYYY-MM-DD] SYMBOL...
+//
+// Without symbols, it sums up the latest sentiment of every tracked symbol,
+// or of those of -watchlist and -tag.
+func HandleSignals(p *Command) error {
+	fs := flag.NewFlagSet("signals", flag.ContinueOnError)
+	fromFlag := fs.String("from", "", "first bar date listed, YYYY-MM-DD")
+	toFlag := fs.String("to", "", "last bar date listed, YYYY-MM-DD")
+	filter := addSymbolFilterFlags(fs)
+
+	err := fs.Parse(p.Input)
+	if err != nil {
+		return err
+	}
+
+	var from, to time.Time
+	if *fromFlag != "" {
+		from, err = time.Parse("2006-01-02", *fromFlag)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid -from: %s", *fromFlag))
+		}
+	}
+	if *toFlag != "" {
+		to, err = time.Parse("2006-01-02", *toFlag)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid -to: %s", *toFlag))
+		}
+	}
+
+	if fs.NArg() == 0 {
+		return summariseSignals(p, filter)
+	}
+
+	for _, arg := range fs.Args() {
+		symbol, err := resolveSymbol(p, arg)
+		if err != nil {
+			return err
+		}
+
+		// the whole history is needed to tell when the sentiment changed
+		signals, err := p.Cfg.Query.GetSignals(p.Ctx, &db.GetSignalOpt{Symbol: symbol.Symbol})
+		if err != nil {
+			fmt.Printf("Error getting signals for symbol: %s\n", symbol.Symbol)
+			return err
+		}
+		printSignalHistory(symbol.Symbol, signals, from, to)
+	}
+	return nil
+}
+
+// printSignalHistory lists the signals of bars between from and to, zero
+// values leave them open.
+func printSignalHistory(symbol string, signals []models.Signal, from time.Time, to time.Time) {
+	if len(signals) == 0 {
+		fmt.Printf("No signal recorded for symbol: %s, run info\n", symbol)
+		return
+	}
+
+	fmt.Printf("%s\n%-10s %9s %9s %9s %9s  %-28s %-10s %5s  %s\n", symbol, "DATE", "HA OPEN", "HA HIGH", "HA LOW", "HA CLOSE", "PATTERNS", "SENTIMENT", "RULES", "EVALUATED")
+	for _, s := range signals {
+		date := s.Date.Time().UTC()
+		if (!from.IsZero() && date.Before(from)) || (!to.IsZero() && date.After(to)) {
+			continue
+		}
+		fmt.Printf("%-10s %9.2f %9.2f %9.2f %9.2f  %-28s %-10s %5d  %s\n", date.Format("2006-01-02"),
+			s.HAOpen, s.HAHigh, s.HALow, s.HAClose, signalPatterns(s), s.Sentiment, s.RuleVersion, s.EvaluatedAt.Time().Format("2006-01-02 15:04"))
+	}
+
+	since, previous := lastSentimentChange(latestPerBar(signals))
+	if previous == "" {
+		fmt.Printf("Sentiment %s since %s, it never changed\n", since.Sentiment, since.Date.Time().UTC().Format("2006-01-02"))
+		return
+	}
+	fmt.Printf("Sentiment last changed on %s from %s to %s\n", since.Date.Time().UTC().Format("2006-01-02"), previous, since.Sentiment)
+}
+
+// signalPatterns lists the flags set on s.
+func signalPatterns(s models.Signal) string {
+	patterns := []string{}
+	for _, p := range []struct {
+		name string
+		set  bool
+	}{
+		{"uptrend", s.Uptrend},
+		{"bull", s.Bull},
+		{"bear", s.Bear},
+		{"spinning-top", s.SpinningTop},
+		{"doji", s.Doji},
+		{"gravestone", s.Gravestone},
+	} {
+		if p.set {
+			patterns = append(patterns, p.name)
+		}
+	}
+	if len(patterns) == 0 {
+		return "-"
+	}
+	return strings.Join(patterns, ",")
+}
+
+// summariseSignals prints the latest sentiment of every symbol and the bar it
+// was first reported on.
+func summariseSignals(p *Command, filter *symbolFilter) error {
+	symbols, err := p.Cfg.Query.GetAllSymbols(p.Ctx)
+	if err != nil {
+		return err
+	}
+	symbols, err = filter.apply(p, symbols)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-8s %-10s %-10s %-10s %s\n", "SYMBOL", "LAST BAR", "SENTIMENT", "SINCE", "PREVIOUS")
+	for _, symbol := range symbols {
+		if symbol.Archived {
+			continue
+		}
+
+		signals, err := p.Cfg.Query.GetSignals(p.Ctx, &db.GetSignalOpt{Symbol: symbol.Symbol})
+		if err != nil {
+			fmt.Printf("Error getting signals for symbol: %s\n", symbol.Symbol)
+			return err
+		}
+		if len(signals) == 0 {
+			fmt.Printf("%-8s %-10s\n", symbol.Symbol, "-")
+			continue
+		}
+
+		bars := latestPerBar(signals)
+		since, previous := lastSentimentChange(bars)
+		if previous == "" {
+			previous = "-"
+		}
+		fmt.Printf("%-8s %-10s %-10s %-10s %s\n", symbol.Symbol, bars[len(bars)-1].Date.Time().UTC().Format("2006-01-02"),
+			since.Sentiment, since.Date.Time().UTC().Format("2006-01-02"), previous)
+	}
+	return nil
+}
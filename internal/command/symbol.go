@@ -0,0 +1,136 @@
+package command
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jingen11/stonk-tracker/internal/db"
+	"github.com/jingen11/stonk-tracker/internal/models"
+)
+
+// stdin is read for confirmations, tests replace it
+var stdin io.Reader = os.Stdin
+
+// HandleRemove deletes a symbol and every stored price of it.
+func HandleRemove(p *Command) error {
+	fs := flag.NewFlagSet("remove", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "remove without asking for confirmation")
+
+	err := fs.Parse(p.Input)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("Please provide a stonk symbol")
+	}
+
+	symbol, err := resolveSymbol(p, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if !*yes && !confirm(fmt.Sprintf("Remove %s and all of its prices? [y/N] ", symbol.Symbol)) {
+		fmt.Println("Nothing removed")
+		return nil
+	}
+
+	removed, err := p.Cfg.Query.RemoveSymbol(p.Ctx, symbol.Symbol)
+	if err != nil {
+		fmt.Printf("Error removing symbol: %s\n", symbol.Symbol)
+		return err
+	}
+	fmt.Printf("%s: removed with %d prices\n", symbol.Symbol, removed)
+	return nil
+}
+
+// HandleArchive keeps the prices of a symbol but leaves it out of refresh and
+// info, -restore brings it back.
+func HandleArchive(p *Command) error {
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	restore := fs.Bool("restore", false, "restore archived symbols")
+
+	err := fs.Parse(p.Input)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("Please provide a stonk symbol")
+	}
+
+	for _, arg := range fs.Args() {
+		symbol, err := resolveSymbol(p, arg)
+		if err != nil {
+			return err
+		}
+
+		err = p.Cfg.Query.ArchiveSymbol(p.Ctx, symbol.Symbol, !*restore)
+		if err != nil {
+			fmt.Printf("Error archiving symbol: %s\n", symbol.Symbol)
+			return err
+		}
+
+		if *restore {
+			fmt.Printf("%s: restored\n", symbol.Symbol)
+		} else {
+			fmt.Printf("%s: archived\n", symbol.Symbol)
+		}
+	}
+	return nil
+}
+
+// HandleRename moves a symbol and its prices to a new ticker, e.g. FB to META.
+// The old ticker is kept as an alias and still resolves to the symbol.
+func HandleRename(p *Command) error {
+	if len(p.Input) != 2 {
+		return errors.New("Please provide the current and the new stonk symbol")
+	}
+	from := strings.ToUpper(p.Input[0])
+	to := strings.ToUpper(p.Input[1])
+	if from == to {
+		return errors.New(fmt.Sprintf("Symbol %s is already called %s", from, to))
+	}
+
+	moved, err := p.Cfg.Query.RenameSymbol(p.Ctx, from, to)
+	if errors.Is(err, db.ErrUnknownSymbol) {
+		return errors.New(fmt.Sprintf("Unknown symbol: %s", from))
+	}
+	if errors.Is(err, db.ErrSymbolExists) {
+		return errors.New(fmt.Sprintf("Symbol %s is already tracked", to))
+	}
+	if err != nil {
+		fmt.Printf("Error renaming symbol: %s\n", from)
+		return err
+	}
+
+	fmt.Printf("%s: renamed to %s with %d prices\n", from, to, moved)
+	return nil
+}
+
+// resolveSymbol finds the tracked symbol of a ticker or of one of its former
+// tickers.
+func resolveSymbol(p *Command, symbol string) (models.Symbol, error) {
+	s, err := p.Cfg.Query.ResolveSymbol(p.Ctx, strings.ToUpper(symbol))
+	if errors.Is(err, db.ErrUnknownSymbol) {
+		return s, errors.New(fmt.Sprintf("Unknown symbol: %s", symbol))
+	}
+	if err != nil {
+		return s, err
+	}
+
+	if s.Symbol != strings.ToUpper(symbol) {
+		fmt.Printf("%s was renamed to %s\n", strings.ToUpper(symbol), s.Symbol)
+	}
+	return s, nil
+}
+
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+	answer, _ := bufio.NewReader(stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,402 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/db"
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"github.com/jingen11/stonk-tracker/internal/portfolio"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HandleTrade records and lists the trade ledger with the buy, sell,
+// dividend, split, list, delete and import subcommands. Flags go before the
+// arguments:
+//
+//	trade buy [-date YYYY-MM-DD] [-fees F] [-note N] SYMBOL QUANTITY PRICE
+//	trade sell [-date YYYY-MM-DD] [-fees F] [-note N] [-lots ID,...] SYMBOL QUANTITY PRICE
+//	trade dividend [-date YYYY-MM-DD] [-note N] SYMBOL AMOUNT
+//	trade split [-date YYYY-MM-DD] [-note N] SYMBOL RATIO
+//	trade list [SYMBOL...]
+//	trade lots [-method M] [SYMBOL...]
+//	trade delete ID
+//	trade import [-preset P] [-broker B] [-map M] [-actions A] [-date-format F] [-allow-partial] FILE...
+func HandleTrade(p *Command) error {
+	if len(p.Input) == 0 {
+		return errors.New("Please provide a trade subcommand: buy, sell, dividend, split, list, lots, delete or import")
+	}
+	sub, args := p.Input[0], p.Input[1:]
+
+	switch sub {
+	case portfolio.BUY, portfolio.SELL, portfolio.DIVIDEND, portfolio.SPLIT:
+		return recordTrade(p, sub, args)
+	case "list":
+		return listTrades(p, args)
+	case "lots":
+		return listLots(p, args)
+	case "import":
+		return importTrades(p, args)
+	case "delete":
+		return deleteTrade(p, args)
+	}
+
+	return errors.New(fmt.Sprintf("Unknown trade subcommand: %s", sub))
+}
+
+// deleteTrade removes a trade from the ledger, unless the sells after it need
+// the shares it bought.
+func deleteTrade(p *Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Please provide a trade id")
+	}
+	id, err := primitive.ObjectIDFromHex(args[0])
+	if err != nil {
+		return errors.New(fmt.Sprintf("Invalid trade id: %s", args[0]))
+	}
+
+	trades, err := p.Cfg.Query.GetTrades(p.Ctx, nil)
+	if err != nil {
+		fmt.Println("Error getting trades")
+		return err
+	}
+	remaining := []models.Trade{}
+	symbol := ""
+	for _, t := range trades {
+		if t.Id == id {
+			symbol = t.Symbol
+			continue
+		}
+		remaining = append(remaining, t)
+	}
+	if symbol == "" {
+		return errors.New(fmt.Sprintf("Unknown trade: %s", args[0]))
+	}
+	err = checkLedger(remaining, symbol)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Cannot delete trade %s: %v", args[0], err))
+	}
+
+	err = p.Cfg.Query.DeleteTrade(p.Ctx, id)
+	if errors.Is(err, db.ErrUnknownTrade) {
+		return errors.New(fmt.Sprintf("Unknown trade: %s", args[0]))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Trade %s: deleted\n", args[0])
+	return nil
+}
+
+// checkLedger replays the trades of symbol, oldest first, and returns why
+// they do not add up: a sell of shares not held or of lots not held.
+func checkLedger(trades []models.Trade, symbol string) error {
+	ledger := []models.Trade{}
+	for _, t := range trades {
+		if t.Symbol == symbol {
+			ledger = append(ledger, t)
+		}
+	}
+	sort.SliceStable(ledger, func(i, j int) bool {
+		return ledger[i].Date < ledger[j].Date
+	})
+
+	_, err := portfolio.Positions(ledger)
+	if err != nil {
+		return err
+	}
+	_, err = portfolio.MatchLots(ledger, portfolio.SPECIFIC)
+	return err
+}
+
+func recordTrade(p *Command, tradeType string, args []string) error {
+	fs := flag.NewFlagSet("trade "+tradeType, flag.ContinueOnError)
+	dateFlag := fs.String("date", "", "trade date, YYYY-MM-DD, today when empty")
+	note := fs.String("note", "", "free text kept with the trade")
+	fees := 0.0
+	if tradeType == portfolio.BUY || tradeType == portfolio.SELL {
+		fs.Float64Var(&fees, "fees", 0, "commission and fees paid")
+	}
+	lots := ""
+	if tradeType == portfolio.SELL {
+		fs.StringVar(&lots, "lots", "", "comma separated ids of the lots sold, see trade lots")
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	expected := 2
+	usage := "SYMBOL AMOUNT"
+	switch tradeType {
+	case portfolio.BUY, portfolio.SELL:
+		expected = 3
+		usage = "SYMBOL QUANTITY PRICE"
+	case portfolio.SPLIT:
+		usage = "SYMBOL RATIO"
+	}
+	if fs.NArg() != expected {
+		return errors.New(fmt.Sprintf("Please provide %s", usage))
+	}
+
+	date := time.Now().UTC().Truncate(24 * time.Hour)
+	if *dateFlag != "" {
+		date, err = time.Parse("2006-01-02", *dateFlag)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid -date: %s", *dateFlag))
+		}
+	}
+
+	trade := models.Trade{
+		Symbol: tradeSymbol(p, fs.Arg(0)),
+		Type:   tradeType,
+		Date:   primitive.NewDateTimeFromTime(date),
+		Fees:   fees,
+		Note:   *note,
+	}
+	if lots != "" {
+		for _, lot := range strings.Split(lots, ",") {
+			id, err := primitive.ObjectIDFromHex(strings.TrimSpace(lot))
+			if err != nil {
+				return errors.New(fmt.Sprintf("Invalid lot id: %s", lot))
+			}
+			trade.Lots = append(trade.Lots, id)
+		}
+	}
+
+	switch tradeType {
+	case portfolio.BUY, portfolio.SELL:
+		trade.Quantity, err = strconv.ParseFloat(fs.Arg(1), 64)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid quantity: %s", fs.Arg(1)))
+		}
+		trade.Price, err = strconv.ParseFloat(fs.Arg(2), 64)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid price: %s", fs.Arg(2)))
+		}
+	case portfolio.DIVIDEND:
+		trade.Amount, err = strconv.ParseFloat(fs.Arg(1), 64)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid amount: %s", fs.Arg(1)))
+		}
+	case portfolio.SPLIT:
+		trade.Ratio, err = parseSplitRatio(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = portfolio.Validate(trade)
+	if err != nil {
+		return err
+	}
+
+	// refuse a trade that leaves the ledger selling shares it does not hold
+	trades, err := p.Cfg.Query.GetTrades(p.Ctx, []string{trade.Symbol})
+	if err != nil {
+		return err
+	}
+	err = checkLedger(append(trades, trade), trade.Symbol)
+	if err != nil {
+		return err
+	}
+
+	trade, err = p.Cfg.Query.InsertTrade(p.Ctx, trade)
+	if err != nil {
+		fmt.Printf("Error recording trade for symbol: %s\n", trade.Symbol)
+		return err
+	}
+	fmt.Printf("Recorded %s\n", formatTrade(trade))
+	return nil
+}
+
+// tradeSymbol returns the current ticker of symbol when it is tracked. Trades
+// of untracked symbols are recorded too, they have no price to be valued at.
+func tradeSymbol(p *Command, symbol string) string {
+	s, err := p.Cfg.Query.ResolveSymbol(p.Ctx, strings.ToUpper(symbol))
+	if err != nil {
+		fmt.Printf("%s is not tracked, run add %s to value it\n", strings.ToUpper(symbol), strings.ToUpper(symbol))
+		return strings.ToUpper(symbol)
+	}
+	if s.Symbol != strings.ToUpper(symbol) {
+		fmt.Printf("%s was renamed to %s\n", strings.ToUpper(symbol), s.Symbol)
+	}
+	return s.Symbol
+}
+
+// parseSplitRatio reads a ratio as new shares per old share, 4:1 or 4 for a
+// four for one split and 1:10 for a reverse split.
+func parseSplitRatio(ratio string) (float64, error) {
+	newShares, oldShares, found := strings.Cut(ratio, ":")
+	n, err := strconv.ParseFloat(newShares, 64)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid split ratio: %s", ratio))
+	}
+	if !found {
+		return n, nil
+	}
+
+	o, err := strconv.ParseFloat(oldShares, 64)
+	if err != nil || o == 0 {
+		return 0, errors.New(fmt.Sprintf("invalid split ratio: %s", ratio))
+	}
+	return n / o, nil
+}
+
+func listTrades(p *Command, args []string) error {
+	symbols := []string{}
+	for _, arg := range args {
+		symbols = append(symbols, tradeSymbol(p, arg))
+	}
+
+	trades, err := p.Cfg.Query.GetTrades(p.Ctx, symbols)
+	if err != nil {
+		fmt.Println("Error getting trades")
+		return err
+	}
+
+	for _, t := range trades {
+		fmt.Printf("%s  %s\n", t.Id.Hex(), formatTrade(t))
+	}
+	if len(trades) == 0 {
+		fmt.Println("No trade recorded")
+	}
+	return nil
+}
+
+// listLots prints the lots still held, their id is the id of the buy to name
+// with trade sell -lots.
+func listLots(p *Command, args []string) error {
+	fs := flag.NewFlagSet("trade lots", flag.ContinueOnError)
+	method := fs.String("method", portfolio.FIFO, "lot method of the past sells: "+strings.Join(portfolio.LotMethods, ", "))
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	symbols := []string{}
+	for _, arg := range fs.Args() {
+		symbols = append(symbols, tradeSymbol(p, arg))
+	}
+
+	trades, err := p.Cfg.Query.GetTrades(p.Ctx, symbols)
+	if err != nil {
+		fmt.Println("Error getting trades")
+		return err
+	}
+	result, err := portfolio.MatchLots(trades, *method)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range result.Lots {
+		line := fmt.Sprintf("%s  %s %-6s %g @ %.2f", l.Id.Hex(), l.Acquired.Format("2006-01-02"), l.Symbol, l.Quantity, l.CostPerShare())
+		if l.WashSaleAdjustment != 0 {
+			line += fmt.Sprintf(", wash sale adjustment %.2f", l.WashSaleAdjustment)
+		}
+		fmt.Println(line)
+	}
+	if len(result.Lots) == 0 {
+		fmt.Println("No lot held")
+	}
+	return nil
+}
+
+func formatTrade(t models.Trade) string {
+	date := t.Date.Time().UTC().Format("2006-01-02")
+	line := ""
+	switch t.Type {
+	case portfolio.BUY, portfolio.SELL:
+		line = fmt.Sprintf("%s %-8s %-6s %g @ %.2f", date, t.Type, t.Symbol, t.Quantity, t.Price)
+		if t.Fees != 0 {
+			line += fmt.Sprintf(", fees %.2f", t.Fees)
+		}
+		if len(t.Lots) > 0 {
+			lots := make([]string, 0, len(t.Lots))
+			for _, id := range t.Lots {
+				lots = append(lots, id.Hex())
+			}
+			line += ", lots " + strings.Join(lots, ",")
+		}
+	case portfolio.DIVIDEND:
+		line = fmt.Sprintf("%s %-8s %-6s %.2f", date, t.Type, t.Symbol, t.Amount)
+	case portfolio.SPLIT:
+		line = fmt.Sprintf("%s %-8s %-6s x%g", date, t.Type, t.Symbol, t.Ratio)
+	}
+	if t.Note != "" {
+		line += " (" + t.Note + ")"
+	}
+	return line
+}
+
+// HandlePortfolio values the positions of the trade ledger at the latest
+// stored close of every symbol.
+func HandlePortfolio(p *Command) error {
+	fs := flag.NewFlagSet("portfolio", flag.ContinueOnError)
+	all := fs.Bool("all", false, "also list closed positions")
+
+	err := fs.Parse(p.Input)
+	if err != nil {
+		return err
+	}
+
+	trades, err := p.Cfg.Query.GetTrades(p.Ctx, nil)
+	if err != nil {
+		fmt.Println("Error getting trades")
+		return err
+	}
+	// a broken ledger only takes its own symbol out of the view
+	positions, _ := portfolio.Positions(trades)
+	if len(positions) == 0 {
+		fmt.Println("No trade recorded, run trade buy SYMBOL QUANTITY PRICE")
+		return nil
+	}
+
+	symbols := make([]string, 0, len(positions))
+	for _, position := range positions {
+		symbols = append(symbols, position.Symbol)
+	}
+	latest, err := p.Cfg.Query.GetLatestStockPrices(p.Ctx, symbols, 1)
+	if err != nil {
+		fmt.Println("Error getting latest prices")
+		return err
+	}
+
+	fmt.Printf("%-8s %12s %10s %10s %12s %12s %12s\n", "SYMBOL", "QUANTITY", "AVG COST", "LAST", "MKT VALUE", "UNREALISED", "REALISED")
+	for i := range positions {
+		position := &positions[i]
+		if prices := latest[position.Symbol]; len(prices) == 1 {
+			position.Value(prices[0])
+		}
+		if position.Err != nil {
+			fmt.Printf("%-8s error: %v\n", position.Symbol, position.Err)
+			continue
+		}
+		if !position.Open() && !*all {
+			continue
+		}
+
+		last, value, unrealised := "-", "-", "-"
+		if position.LastClose != 0 {
+			last = fmt.Sprintf("%.2f", position.LastClose)
+			value = fmt.Sprintf("%.2f", position.MarketValue())
+			unrealised = fmt.Sprintf("%.2f", position.Unrealised())
+		}
+		fmt.Printf("%-8s %12g %10.2f %10s %12s %12s %12.2f\n",
+			position.Symbol, position.Quantity, position.AverageCost(), last, value, unrealised, position.Realised)
+	}
+
+	total := portfolio.Total(positions)
+	fmt.Printf("%-8s %12s %10s %10s %12.2f %12.2f %12.2f\n", "TOTAL", "", "", "", total.MarketValue, total.Unrealised, total.Realised)
+	if total.Dividends != 0 {
+		fmt.Printf("Realised includes %.2f of dividends\n", total.Dividends)
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/calendar"
+	"github.com/jingen11/stonk-tracker/internal/db"
+	"github.com/jingen11/stonk-tracker/internal/importer"
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"github.com/jingen11/stonk-tracker/internal/portfolio"
+)
+
+// importTrades records the trades of broker CSV exports. Symbols that are not
+// tracked yet are added, the next refresh fetches their recent prices.
+func importTrades(p *Command, args []string) error {
+	presets := make([]string, 0, len(importer.TradePresets))
+	for name := range importer.TradePresets {
+		presets = append(presets, name)
+	}
+	sort.Strings(presets)
+
+	fs := flag.NewFlagSet("trade import", flag.ContinueOnError)
+	preset := fs.String("preset", importer.GENERIC_PRESET, "broker export format: "+strings.Join(presets, ", "))
+	broker := fs.String("broker", "", "broker the transaction ids belong to, the preset name when empty")
+	mapping := fs.String("map", "", "column mapping, e.g. date=Settle Date,fees=Commission+Fees")
+	actions := fs.String("actions", "", "broker actions to trade types, e.g. Bought=buy,Sold=sell")
+	dateLayout := fs.String("date-format", "", "go time layout of the date column")
+	allowPartial := fs.Bool("allow-partial", false, "import the symbols whose ledger adds up and skip the others")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("Please provide at least one file to import")
+	}
+
+	columns, err := importer.ParseTradeMapping(*mapping)
+	if err != nil {
+		return err
+	}
+	rules, err := importer.ParseActions(*actions)
+	if err != nil {
+		return err
+	}
+
+	trades := []models.Trade{}
+	rejected := 0
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		res, err := importer.ReadTrades(f, importer.TradeOptions{
+			Preset:     *preset,
+			Broker:     *broker,
+			Mapping:    columns,
+			Actions:    rules,
+			DateLayout: *dateLayout,
+		})
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		for i, r := range res.Rejected {
+			if i == maxPrintedRejections {
+				fmt.Printf("%s: %d more rejected rows\n", path, len(res.Rejected)-i)
+				break
+			}
+			fmt.Printf("%s:%d rejected: %s\n", path, r.Line, r.Reason)
+		}
+		rejected += len(res.Rejected)
+		printSkippedRows(path, res.Skipped)
+
+		trades = append(trades, res.Trades...)
+	}
+
+	untracked, err := resolveTradeSymbols(p, trades)
+	if err != nil {
+		return err
+	}
+
+	// a sell of shares the ledger does not hold would break portfolio, gains
+	// and trade lots for its symbol, e.g. a statement not starting at the
+	// first trade
+	broken, err := brokenLedgers(p, trades)
+	if err != nil {
+		return err
+	}
+	if len(broken) > 0 {
+		symbols := make([]string, 0, len(broken))
+		for symbol := range broken {
+			symbols = append(symbols, symbol)
+		}
+		sort.Strings(symbols)
+		for _, symbol := range symbols {
+			fmt.Printf("%s: %v\n", symbol, broken[symbol])
+		}
+		if !*allowPartial {
+			return errors.New(fmt.Sprintf("Nothing imported, the trades of %s sell shares not held. Record the missing buys with trade buy first, or import the other symbols with -allow-partial",
+				strings.Join(symbols, ", ")))
+		}
+
+		kept := []models.Trade{}
+		for _, t := range trades {
+			if _, ok := broken[t.Symbol]; !ok {
+				kept = append(kept, t)
+			}
+		}
+		trades = kept
+		fmt.Printf("Skipping the trades of %s\n", strings.Join(symbols, ", "))
+	}
+
+	err = trackTradeSymbols(p, trades, untracked)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.Cfg.Query.ImportTrades(p.Ctx, trades)
+	if err != nil {
+		fmt.Println("Error importing trades")
+		return err
+	}
+	fmt.Printf("Imported trades: inserted %d, skipped duplicate %d, rejected %d\n", res.Inserted, res.Duplicates, rejected)
+	return nil
+}
+
+// brokenLedgers replays the recorded trades with the imported ones that are
+// not recorded yet and returns why the ledger of each imported symbol does not
+// add up.
+func brokenLedgers(p *Command, trades []models.Trade) (map[string]error, error) {
+	recorded, err := p.Cfg.Query.GetTrades(p.Ctx, nil)
+	if err != nil {
+		fmt.Println("Error getting trades")
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, t := range recorded {
+		if t.TransactionId != "" {
+			seen[t.Broker+"/"+t.TransactionId] = true
+		}
+	}
+	imported := map[string]bool{}
+	ledger := recorded
+	for _, t := range trades {
+		imported[t.Symbol] = true
+		if t.TransactionId != "" {
+			if seen[t.Broker+"/"+t.TransactionId] {
+				continue
+			}
+			seen[t.Broker+"/"+t.TransactionId] = true
+		}
+		ledger = append(ledger, t)
+	}
+	sort.SliceStable(ledger, func(i, j int) bool {
+		return ledger[i].Date < ledger[j].Date
+	})
+
+	positions, _ := portfolio.Positions(ledger)
+	broken := map[string]error{}
+	for _, position := range positions {
+		if position.Err != nil && imported[position.Symbol] {
+			broken[position.Symbol] = position.Err
+		}
+	}
+	return broken, nil
+}
+
+// printSkippedRows summarises the rows skipped for their action, statements
+// mix trades with deposits, interest and the like.
+func printSkippedRows(path string, skipped []importer.Rejection) {
+	counts := map[string]int{}
+	for _, s := range skipped {
+		counts[s.Reason]++
+	}
+	reasons := make([]string, 0, len(counts))
+	for r := range counts {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+
+	for _, r := range reasons {
+		fmt.Printf("%s: skipped %d rows, %s\n", path, counts[r], r)
+	}
+}
+
+// resolveTradeSymbols moves the trades of renamed tickers to the current one
+// and returns the symbols that are not tracked yet.
+func resolveTradeSymbols(p *Command, trades []models.Trade) (map[string]bool, error) {
+	tracked := map[string]string{}
+	untracked := map[string]bool{}
+
+	for i := range trades {
+		symbol := trades[i].Symbol
+		if current, ok := tracked[symbol]; ok {
+			trades[i].Symbol = current
+			continue
+		}
+
+		s, err := p.Cfg.Query.ResolveSymbol(p.Ctx, symbol)
+		if errors.Is(err, db.ErrUnknownSymbol) {
+			untracked[symbol] = true
+			s.Symbol = symbol
+		} else if err != nil {
+			return nil, err
+		} else if s.Symbol != symbol {
+			fmt.Printf("%s was renamed to %s\n", symbol, s.Symbol)
+		}
+
+		tracked[symbol] = s.Symbol
+		trades[i].Symbol = s.Symbol
+	}
+	return untracked, nil
+}
+
+// trackTradeSymbols adds the untracked symbols trades are imported for, with
+// a lastFetchedDate that makes refresh fetch the same history as add.
+func trackTradeSymbols(p *Command, trades []models.Trade, untracked map[string]bool) error {
+	watermark := historyStart(p, calendar.PrevTradingDay(time.Now())).AddDate(0, 0, -1)
+
+	for _, t := range trades {
+		if !untracked[t.Symbol] {
+			continue
+		}
+		_, err := p.Cfg.Query.CreateSymbol(p.Ctx, t.Symbol, watermark)
+		if err != nil {
+			fmt.Printf("Error adding symbol: %s\n", t.Symbol)
+			return err
+		}
+		fmt.Printf("%s: now tracked, run refresh to fetch its prices\n", t.Symbol)
+		untracked[t.Symbol] = false
+	}
+	return nil
+}
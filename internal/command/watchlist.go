@@ -0,0 +1,231 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jingen11/stonk-tracker/internal/db"
+	"github.com/jingen11/stonk-tracker/internal/models"
+)
+
+// HandleWatchlist manages named lists of symbols with the create, delete, add,
+// remove and list subcommands.
+func HandleWatchlist(p *Command) error {
+	if len(p.Input) == 0 {
+		return errors.New("Please provide a watchlist subcommand: create, delete, add, remove or list")
+	}
+	sub, args := p.Input[0], p.Input[1:]
+
+	switch sub {
+	case "create", "delete":
+		if len(args) != 1 {
+			return errors.New("Please provide a watchlist name")
+		}
+		name := args[0]
+
+		var err error
+		if sub == "create" {
+			err = p.Cfg.Query.CreateWatchlist(p.Ctx, name)
+		} else {
+			err = p.Cfg.Query.DeleteWatchlist(p.Ctx, name)
+		}
+		if errors.Is(err, db.ErrWatchlistExists) {
+			return errors.New(fmt.Sprintf("Watchlist %s already exists", name))
+		}
+		if errors.Is(err, db.ErrUnknownWatchlist) {
+			return errors.New(fmt.Sprintf("Unknown watchlist: %s", name))
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Watchlist %s: %sd\n", name, sub)
+		return nil
+
+	case "add", "remove":
+		if len(args) < 2 {
+			return errors.New("Please provide a watchlist name and stonk symbols")
+		}
+		name := args[0]
+
+		for _, arg := range args[1:] {
+			symbol, err := resolveSymbol(p, arg)
+			if err != nil {
+				return err
+			}
+
+			err = p.Cfg.Query.SetWatchlistMember(p.Ctx, name, symbol.Symbol, sub == "add")
+			if errors.Is(err, db.ErrUnknownWatchlist) {
+				return errors.New(fmt.Sprintf("Unknown watchlist: %s, run watchlist create %s", name, name))
+			}
+			if err != nil {
+				return err
+			}
+
+			if sub == "add" {
+				fmt.Printf("%s: added to %s\n", symbol.Symbol, name)
+			} else {
+				fmt.Printf("%s: removed from %s\n", symbol.Symbol, name)
+			}
+		}
+		return nil
+
+	case "list":
+		if len(args) > 1 {
+			return errors.New("Please provide at most one watchlist name")
+		}
+		return listWatchlists(p, args)
+	}
+
+	return errors.New(fmt.Sprintf("Unknown watchlist subcommand: %s", sub))
+}
+
+// listWatchlists prints every watchlist, or only the named ones, with their
+// symbols.
+func listWatchlists(p *Command, names []string) error {
+	watchlists, err := p.Cfg.Query.GetWatchlists(p.Ctx)
+	if err != nil {
+		return err
+	}
+	symbols, err := p.Cfg.Query.GetAllSymbols(p.Ctx)
+	if err != nil {
+		return err
+	}
+
+	listed := 0
+	for _, w := range watchlists {
+		if len(names) > 0 && !slices.Contains(names, w.Name) {
+			continue
+		}
+		listed++
+
+		members := []string{}
+		for _, s := range symbols {
+			if slices.Contains(s.Watchlists, w.Name) {
+				members = append(members, s.Symbol)
+			}
+		}
+		fmt.Printf("%s (%d): %s\n", w.Name, len(members), strings.Join(members, ", "))
+	}
+
+	if len(names) > 0 && listed == 0 {
+		return errors.New(fmt.Sprintf("Unknown watchlist: %s", names[0]))
+	}
+	if len(watchlists) == 0 {
+		fmt.Println("No watchlist, run watchlist create NAME")
+	}
+	return nil
+}
+
+// HandleTag tags symbols with the add, remove and list subcommands. Tags are
+// lowercase.
+func HandleTag(p *Command) error {
+	if len(p.Input) == 0 {
+		return errors.New("Please provide a tag subcommand: add, remove or list")
+	}
+	sub, args := p.Input[0], p.Input[1:]
+
+	switch sub {
+	case "add", "remove":
+		if len(args) < 2 {
+			return errors.New("Please provide a stonk symbol and tags")
+		}
+
+		symbol, err := resolveSymbol(p, args[0])
+		if err != nil {
+			return err
+		}
+
+		for _, tag := range args[1:] {
+			err := p.Cfg.Query.SetSymbolTag(p.Ctx, symbol.Symbol, strings.ToLower(tag), sub == "add")
+			if err != nil {
+				return err
+			}
+		}
+
+		symbol, err = p.Cfg.Query.ResolveSymbol(p.Ctx, symbol.Symbol)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", symbol.Symbol, strings.Join(symbol.Tags, ", "))
+		return nil
+
+	case "list":
+		symbols, err := p.Cfg.Query.GetAllSymbols(p.Ctx)
+		if err != nil {
+			return err
+		}
+
+		tagged := map[string][]string{}
+		for _, s := range symbols {
+			for _, tag := range s.Tags {
+				tagged[tag] = append(tagged[tag], s.Symbol)
+			}
+		}
+		tags := make([]string, 0, len(tagged))
+		for tag := range tagged {
+			tags = append(tags, tag)
+		}
+		slices.Sort(tags)
+
+		for _, tag := range tags {
+			fmt.Printf("%s (%d): %s\n", tag, len(tagged[tag]), strings.Join(tagged[tag], ", "))
+		}
+		if len(tags) == 0 {
+			fmt.Println("No tagged symbol, run tag add SYMBOL TAG")
+		}
+		return nil
+	}
+
+	return errors.New(fmt.Sprintf("Unknown tag subcommand: %s", sub))
+}
+
+// symbolFilter selects symbols by watchlist and tag, both when given.
+type symbolFilter struct {
+	watchlist *string
+	tag       *string
+}
+
+func addSymbolFilterFlags(fs *flag.FlagSet) *symbolFilter {
+	return &symbolFilter{
+		watchlist: fs.String("watchlist", "", "only the symbols of this watchlist"),
+		tag:       fs.String("tag", "", "only the symbols with this tag"),
+	}
+}
+
+func (f *symbolFilter) empty() bool {
+	return *f.watchlist == "" && *f.tag == ""
+}
+
+// apply returns the symbols matching the filter, a watchlist that does not
+// exist is an error rather than an empty selection.
+func (f *symbolFilter) apply(p *Command, symbols []models.Symbol) ([]models.Symbol, error) {
+	if f.empty() {
+		return symbols, nil
+	}
+
+	if *f.watchlist != "" {
+		watchlists, err := p.Cfg.Query.GetWatchlists(p.Ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.ContainsFunc(watchlists, func(w models.Watchlist) bool { return w.Name == *f.watchlist }) {
+			return nil, errors.New(fmt.Sprintf("Unknown watchlist: %s", *f.watchlist))
+		}
+	}
+	tag := strings.ToLower(*f.tag)
+
+	selected := []models.Symbol{}
+	for _, s := range symbols {
+		if *f.watchlist != "" && !slices.Contains(s.Watchlists, *f.watchlist) {
+			continue
+		}
+		if tag != "" && !slices.Contains(s.Tags, tag) {
+			continue
+		}
+		selected = append(selected, s)
+	}
+	return selected, nil
+}
@@ -36,11 +36,18 @@ func Disconnect(client *mongo.Client) {
 	client.Disconnect(context.Background())
 }
 
-func InitPriceCollection(db *mongo.Database) (*mongo.Collection, error) {
+// InitPriceCollection indexes prices on (symbol, date). The index is unique on
+// the plain layout, time-series collections do not support unique indexes.
+func InitPriceCollection(ctx context.Context, db *mongo.Database) (*mongo.Collection, error) {
 	priceColl := db.Collection("price")
-	_, err := priceColl.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+	layout, err := GetPriceLayout(ctx, priceColl)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = priceColl.Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys:    bson.D{{Key: "symbol", Value: 1}, {Key: "date", Value: 1}},
-		Options: options.Index().SetUnique(true),
+		Options: options.Index().SetUnique(layout == PLAIN_LAYOUT),
 	})
 
 	if err != nil {
@@ -50,10 +57,10 @@ func InitPriceCollection(db *mongo.Database) (*mongo.Collection, error) {
 	return priceColl, nil
 }
 
-func InitSymbolCollection(db *mongo.Database) (*mongo.Collection, error) {
+func InitSymbolCollection(ctx context.Context, db *mongo.Database) (*mongo.Collection, error) {
 	symbolColl := db.Collection("symbol")
 
-	_, err := symbolColl.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+	_, err := symbolColl.Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys:    bson.D{{Key: "symbol", Value: 1}},
 		Options: options.Index().SetUnique(true),
 	})
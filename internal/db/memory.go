@@ -0,0 +1,572 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps symbols and prices in maps guarded by a mutex. It follows
+// the same rules as the mongo store, including the unique (symbol, date) key.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	symbols map[string]*models.Symbol
+	// prices by symbol, then by date
+	prices     map[string]map[primitive.DateTime]models.Price
+	watchlists map[string]models.Watchlist
+	// trades in recording order
+	trades []models.Trade
+	// signals in evaluation order
+	signals []models.Signal
+}
+
+func InitMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		symbols:    map[string]*models.Symbol{},
+		prices:     map[string]map[primitive.DateTime]models.Price{},
+		watchlists: map[string]models.Watchlist{},
+	}
+}
+
+func (m *MemoryStore) GetAllSymbols(ctx context.Context) ([]models.Symbol, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	symbols := make([]models.Symbol, 0, len(m.symbols))
+	for _, s := range m.symbols {
+		symbols = append(symbols, *s)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Symbol < symbols[j].Symbol
+	})
+
+	return symbols, nil
+}
+
+func (m *MemoryStore) GetStockPrices(ctx context.Context, opt *GetStockPriceOpt) ([]models.Price, error) {
+	err := opt.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	from := primitive.NewDateTimeFromTime(opt.From)
+	to := primitive.NewDateTimeFromTime(opt.To)
+	prices := []models.Price{}
+	for _, symbol := range opt.symbols() {
+		for date, p := range m.prices[symbol] {
+			if date < from || date > to {
+				continue
+			}
+			if opt.After != nil && !opt.before(opt.After, CursorAfter(p)) {
+				continue
+			}
+			prices = append(prices, p)
+		}
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		return opt.before(CursorAfter(prices[i]), CursorAfter(prices[j]))
+	})
+
+	if int64(len(prices)) > opt.Limit {
+		prices = prices[:opt.Limit]
+	}
+	for i := range prices {
+		prices[i] = opt.project(prices[i])
+	}
+
+	return prices, nil
+}
+
+func (m *MemoryStore) GetLatestStockPrices(ctx context.Context, symbols []string, n int64) (map[string][]models.Price, error) {
+	latest := map[string][]models.Price{}
+	for _, symbol := range symbols {
+		prices, err := m.GetStockPrices(ctx, &GetStockPriceOpt{Symbol: symbol, Limit: n})
+		if err != nil {
+			return nil, err
+		}
+		if len(prices) > 0 {
+			latest[symbol] = prices
+		}
+	}
+
+	return latest, nil
+}
+
+func (m *MemoryStore) InsertStockPrice(stock models.StockData, ctx context.Context) (*models.Price, error) {
+	p, err := stockDataToPrice(stock.Symbol, stock)
+	if err != nil {
+		fmt.Println("Failed to parse stonkDate")
+		return &p, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	symbol, ok := m.symbols[stock.Symbol]
+	if !ok {
+		symbol = m.createSymbol(stock.Symbol, p.Date.Time())
+	}
+
+	if _, exists := m.prices[p.Symbol][p.Date]; exists {
+		return &p, ErrDuplicatePrice
+	}
+	p.Id = primitive.NewObjectID()
+	m.putPrice(p)
+	m.updateHeikinAshi(p.Symbol, p.Date.Time())
+
+	if p.Date.Time().After(symbol.LastFetchedDate.Time()) {
+		symbol.LastFetchedDate = p.Date
+	}
+
+	return &p, nil
+}
+
+func (m *MemoryStore) InsertSymbolStockPrices(stocks []models.StockData, symbol string, ctx context.Context) (*UpsertResult, error) {
+	result := &UpsertResult{}
+	if len(stocks) == 0 {
+		return result, errors.New("no stocks found")
+	}
+
+	prices := make([]models.Price, 0, len(stocks))
+	for _, stonk := range stocks {
+		p, err := stockDataToPrice(symbol, stonk)
+		if err != nil {
+			fmt.Println("Failed to parse stonkDate")
+			return result, err
+		}
+		prices = append(prices, p)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.symbols[symbol]
+	if !ok {
+		s = m.createSymbol(symbol, epoch)
+	}
+
+	var changedFrom time.Time
+	for _, p := range prices {
+		stored, exists := m.prices[symbol][p.Date]
+		switch {
+		case !exists:
+			p.Id = primitive.NewObjectID()
+			m.putPrice(p)
+			result.Inserted++
+		case stored.Open != p.Open || stored.High != p.High || stored.Low != p.Low || stored.Close != p.Close || stored.Volume != p.Volume:
+			stored.Open, stored.High, stored.Low, stored.Close, stored.Volume = p.Open, p.High, p.Low, p.Close, p.Volume
+			m.putPrice(stored)
+			result.Updated++
+		default:
+			result.Unchanged++
+			continue
+		}
+
+		if changedFrom.IsZero() || p.Date.Time().Before(changedFrom) {
+			changedFrom = p.Date.Time()
+		}
+		if p.Date.Time().After(s.LastFetchedDate.Time()) {
+			s.LastFetchedDate = p.Date
+		}
+	}
+
+	if !changedFrom.IsZero() {
+		m.updateHeikinAshi(symbol, changedFrom)
+	}
+
+	return result, nil
+}
+
+func (m *MemoryStore) ImportStockPrices(ctx context.Context, symbol string, prices []models.Price) (*ImportResult, error) {
+	result := &ImportResult{}
+	if len(prices) == 0 {
+		return result, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.symbols[symbol]
+	if !ok {
+		s = m.createSymbol(symbol, epoch)
+	}
+
+	var changedFrom time.Time
+	for _, p := range prices {
+		p.Symbol = symbol
+		if p.Date.Time().After(s.LastFetchedDate.Time()) {
+			s.LastFetchedDate = p.Date
+		}
+		if _, exists := m.prices[symbol][p.Date]; exists {
+			result.Duplicates++
+			continue
+		}
+		p.Id = primitive.NewObjectID()
+		m.putPrice(p)
+		result.Inserted++
+		if changedFrom.IsZero() || p.Date.Time().Before(changedFrom) {
+			changedFrom = p.Date.Time()
+		}
+	}
+
+	if !changedFrom.IsZero() {
+		m.updateHeikinAshi(symbol, changedFrom)
+	}
+
+	return result, nil
+}
+
+func (m *MemoryStore) UpdateHeikinAshi(ctx context.Context, symbol string, from time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.updateHeikinAshi(symbol, from), nil
+}
+
+// updateHeikinAshi must be called with the write lock held.
+func (m *MemoryStore) updateHeikinAshi(symbol string, from time.Time) int {
+	prices := make([]models.Price, 0, len(m.prices[symbol]))
+	for _, p := range m.prices[symbol] {
+		prices = append(prices, p)
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		return prices[i].Date < prices[j].Date
+	})
+
+	start := sort.Search(len(prices), func(i int) bool {
+		return !prices[i].Date.Time().Before(from)
+	})
+	var prev *models.Price
+	if start > 0 {
+		prev = &prices[start-1]
+		if !hasHeikinAshi(prev) {
+			start, prev = 0, nil
+		}
+	}
+
+	chainHeikinAshi(prev, prices[start:])
+	for _, p := range prices[start:] {
+		m.putPrice(p)
+	}
+
+	return len(prices) - start
+}
+
+func (m *MemoryStore) RepairLastFetchedDates(ctx context.Context, dryRun bool) ([]SymbolRepair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	symbols := make([]string, 0, len(m.prices))
+	for symbol := range m.prices {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	repairs := []SymbolRepair{}
+	for _, symbol := range symbols {
+		var latestDate primitive.DateTime
+		for date := range m.prices[symbol] {
+			latestDate = max(latestDate, date)
+		}
+
+		s, ok := m.symbols[symbol]
+		switch {
+		case !ok:
+			repairs = append(repairs, SymbolRepair{Symbol: symbol, To: latestDate.Time(), Created: true})
+			if !dryRun {
+				m.createSymbol(symbol, latestDate.Time())
+			}
+		case s.LastFetchedDate != latestDate:
+			repairs = append(repairs, SymbolRepair{Symbol: symbol, From: s.LastFetchedDate.Time(), To: latestDate.Time()})
+			if !dryRun {
+				s.LastFetchedDate = latestDate
+			}
+		}
+	}
+
+	return repairs, nil
+}
+
+func (m *MemoryStore) ResolveSymbol(ctx context.Context, symbol string) (models.Symbol, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := m.resolveSymbol(symbol)
+	if s == nil {
+		return models.Symbol{}, ErrUnknownSymbol
+	}
+	return *s, nil
+}
+
+func (m *MemoryStore) RemoveSymbol(ctx context.Context, symbol string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.symbols[symbol]; !ok {
+		return 0, ErrUnknownSymbol
+	}
+
+	removed := len(m.prices[symbol])
+	delete(m.prices, symbol)
+	delete(m.symbols, symbol)
+	m.signals = slices.DeleteFunc(m.signals, func(s models.Signal) bool { return s.Symbol == symbol })
+	return removed, nil
+}
+
+func (m *MemoryStore) ArchiveSymbol(ctx context.Context, symbol string, archived bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.symbols[symbol]
+	if !ok {
+		return ErrUnknownSymbol
+	}
+	s.Archived = archived
+	return nil
+}
+
+func (m *MemoryStore) RenameSymbol(ctx context.Context, symbol string, to string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.symbols[symbol]
+	if !ok {
+		return 0, ErrUnknownSymbol
+	}
+	if other := m.resolveSymbol(to); other != nil && other != s {
+		return 0, ErrSymbolExists
+	}
+
+	moved := 0
+	for _, p := range m.prices[symbol] {
+		p.Symbol = to
+		m.putPrice(p)
+		moved++
+	}
+	delete(m.prices, symbol)
+
+	for i := range m.trades {
+		if m.trades[i].Symbol == symbol {
+			m.trades[i].Symbol = to
+		}
+	}
+	for i := range m.signals {
+		if m.signals[i].Symbol == symbol {
+			m.signals[i].Symbol = to
+		}
+	}
+
+	s.Aliases = renamedAliases(*s, to)
+	s.Symbol = to
+	delete(m.symbols, symbol)
+	m.symbols[to] = s
+
+	return moved, nil
+}
+
+func (m *MemoryStore) SetSymbolTag(ctx context.Context, symbol string, tag string, tagged bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.symbols[symbol]
+	if !ok {
+		return ErrUnknownSymbol
+	}
+	s.Tags = setMember(s.Tags, tag, tagged)
+	return nil
+}
+
+func (m *MemoryStore) GetWatchlists(ctx context.Context) ([]models.Watchlist, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	watchlists := make([]models.Watchlist, 0, len(m.watchlists))
+	for _, w := range m.watchlists {
+		watchlists = append(watchlists, w)
+	}
+	sort.Slice(watchlists, func(i, j int) bool {
+		return watchlists[i].Name < watchlists[j].Name
+	})
+
+	return watchlists, nil
+}
+
+func (m *MemoryStore) CreateWatchlist(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.watchlists[name]; ok {
+		return ErrWatchlistExists
+	}
+	m.watchlists[name] = models.Watchlist{
+		Id:        primitive.NewObjectID(),
+		Name:      name,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteWatchlist(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.watchlists[name]; !ok {
+		return ErrUnknownWatchlist
+	}
+	delete(m.watchlists, name)
+	for _, s := range m.symbols {
+		s.Watchlists = setMember(s.Watchlists, name, false)
+	}
+	return nil
+}
+
+func (m *MemoryStore) SetWatchlistMember(ctx context.Context, watchlist string, symbol string, member bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.watchlists[watchlist]; !ok {
+		return ErrUnknownWatchlist
+	}
+	s, ok := m.symbols[symbol]
+	if !ok {
+		return ErrUnknownSymbol
+	}
+	s.Watchlists = setMember(s.Watchlists, watchlist, member)
+	return nil
+}
+
+func (m *MemoryStore) InsertTrade(ctx context.Context, trade models.Trade) (models.Trade, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	trade.Id = primitive.NewObjectID()
+	m.trades = append(m.trades, trade)
+	return trade, nil
+}
+
+func (m *MemoryStore) GetTrades(ctx context.Context, symbols []string) ([]models.Trade, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	trades := []models.Trade{}
+	for _, t := range m.trades {
+		if len(symbols) == 0 || slices.Contains(symbols, t.Symbol) {
+			trades = append(trades, t)
+		}
+	}
+	sort.SliceStable(trades, func(i, j int) bool {
+		return trades[i].Date < trades[j].Date
+	})
+
+	return trades, nil
+}
+
+func (m *MemoryStore) DeleteTrade(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.trades, func(t models.Trade) bool { return t.Id == id })
+	if i < 0 {
+		return ErrUnknownTrade
+	}
+	m.trades = slices.Delete(m.trades, i, i+1)
+	return nil
+}
+
+func (m *MemoryStore) ImportTrades(ctx context.Context, trades []models.Trade) (*ImportResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := &ImportResult{}
+	for _, trade := range trades {
+		if trade.TransactionId != "" && slices.ContainsFunc(m.trades, func(t models.Trade) bool {
+			return t.Broker == trade.Broker && t.TransactionId == trade.TransactionId
+		}) {
+			result.Duplicates++
+			continue
+		}
+
+		trade.Id = primitive.NewObjectID()
+		m.trades = append(m.trades, trade)
+		result.Inserted++
+	}
+	return result, nil
+}
+
+func (m *MemoryStore) CreateSymbol(ctx context.Context, symbol string, lastFetchedDate time.Time) (models.Symbol, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.symbols[symbol]; ok {
+		return models.Symbol{}, ErrSymbolExists
+	}
+	return *m.createSymbol(symbol, lastFetchedDate), nil
+}
+
+func (m *MemoryStore) InsertSignal(ctx context.Context, signal models.Signal) (models.Signal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	signal.Id = primitive.NewObjectID()
+	m.signals = append(m.signals, signal)
+	return signal, nil
+}
+
+func (m *MemoryStore) GetSignals(ctx context.Context, opt *GetSignalOpt) ([]models.Signal, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	signals := []models.Signal{}
+	for _, s := range m.signals {
+		if s.Symbol == opt.Symbol && opt.matches(s.Date) {
+			signals = append(signals, s)
+		}
+	}
+	sort.SliceStable(signals, func(i, j int) bool {
+		return signals[i].Date < signals[j].Date
+	})
+
+	return signals, nil
+}
+
+// resolveSymbol must be called with the lock held.
+func (m *MemoryStore) resolveSymbol(symbol string) *models.Symbol {
+	if s, ok := m.symbols[symbol]; ok {
+		return s
+	}
+	for _, s := range m.symbols {
+		if slices.Contains(s.Aliases, symbol) {
+			return s
+		}
+	}
+	return nil
+}
+
+// createSymbol must be called with the write lock held.
+func (m *MemoryStore) createSymbol(symbol string, lastFetchedDate time.Time) *models.Symbol {
+	s := &models.Symbol{
+		Id:              primitive.NewObjectID(),
+		Symbol:          symbol,
+		LastFetchedDate: primitive.NewDateTimeFromTime(lastFetchedDate),
+	}
+	m.symbols[symbol] = s
+	return s
+}
+
+// putPrice must be called with the write lock held.
+func (m *MemoryStore) putPrice(p models.Price) {
+	if m.prices[p.Symbol] == nil {
+		m.prices[p.Symbol] = map[primitive.DateTime]models.Price{}
+	}
+	m.prices[p.Symbol][p.Date] = p
+}
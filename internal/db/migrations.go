@@ -0,0 +1,251 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary, upgrade stonk")
+
+// Migrator is implemented by stores with a persistent schema. Migrations are
+// numbered from 1, run in order and recorded once applied.
+type Migrator interface {
+	// MigrationStatus lists every migration known to the binary, followed by
+	// the applied ones it does not know about.
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+	// MigrateUp applies the pending migrations and returns them.
+	MigrateUp(ctx context.Context) ([]MigrationStatus, error)
+}
+
+type MigrationStatus struct {
+	Version int
+	// Name is empty for a migration applied by a newer binary
+	Name string
+	// AppliedAt is zero while the migration is pending
+	AppliedAt time.Time
+}
+
+type migrationRecord struct {
+	Version   int                `bson:"version"`
+	Name      string             `bson:"name"`
+	AppliedAt primitive.DateTime `bson:"appliedAt"`
+}
+
+// UpgradeSchema applies the migrations pending on stores with a persistent
+// schema and returns them, commands then run on the schema they expect. A
+// schema written by a newer binary is refused with ErrSchemaTooNew.
+func UpgradeSchema(ctx context.Context, store Store) ([]MigrationStatus, error) {
+	migrator, ok := store.(Migrator)
+	if !ok {
+		return nil, nil
+	}
+	return migrator.MigrateUp(ctx)
+}
+
+// migrationStatus merges the names of the known migrations, version i+1 for
+// names[i], with the applied records.
+func migrationStatus(names []string, applied []migrationRecord) []MigrationStatus {
+	statuses := make([]MigrationStatus, 0, len(names))
+	for i, name := range names {
+		statuses = append(statuses, MigrationStatus{Version: i + 1, Name: name})
+	}
+
+	for _, r := range applied {
+		if r.Version >= 1 && r.Version <= len(names) {
+			statuses[r.Version-1].AppliedAt = r.AppliedAt.Time()
+			continue
+		}
+		statuses = append(statuses, MigrationStatus{Version: r.Version, AppliedAt: r.AppliedAt.Time()})
+	}
+
+	return statuses
+}
+
+// mongoMigration changes the stonk database. Up must be safe to run again, a
+// failure after it returns leaves the migration pending.
+type mongoMigration struct {
+	Name string
+	Up   func(ctx context.Context, q *Query) error
+}
+
+// mongoMigrations are never reordered or removed, new ones go at the end.
+var mongoMigrations = []mongoMigration{
+	{
+		Name: "index symbols and prices",
+		Up: func(ctx context.Context, q *Query) error {
+			_, err := InitSymbolCollection(ctx, q.SymbolColl.Database())
+			if err != nil {
+				return err
+			}
+			_, err = InitPriceCollection(ctx, q.PriceColl.Database())
+			return err
+		},
+	},
+	{
+		Name: "backfill heikin ashi candles",
+		Up: func(ctx context.Context, q *Query) error {
+			symbols, err := q.PriceColl.Distinct(ctx, "symbol", bson.D{{Key: "haOpen", Value: bson.D{{Key: "$exists", Value: false}}}})
+			if err != nil {
+				return err
+			}
+
+			for _, s := range symbols {
+				symbol, ok := s.(string)
+				if !ok {
+					continue
+				}
+				_, err := q.UpdateHeikinAshi(ctx, symbol, epoch)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Name: "index symbol aliases",
+		Up: func(ctx context.Context, q *Query) error {
+			_, err := q.SymbolColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "aliases", Value: 1}},
+			})
+			return err
+		},
+	},
+	{
+		Name: "index symbol tags and watchlists",
+		Up: func(ctx context.Context, q *Query) error {
+			_, err := q.SymbolColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{Keys: bson.D{{Key: "tags", Value: 1}}},
+				{Keys: bson.D{{Key: "watchlists", Value: 1}}},
+			})
+			if err != nil {
+				return err
+			}
+			_, err = q.watchlistColl().Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "name", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			})
+			return err
+		},
+	},
+	{
+		Name: "index trades",
+		Up: func(ctx context.Context, q *Query) error {
+			_, err := q.tradeColl().Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "symbol", Value: 1}, {Key: "date", Value: 1}},
+			})
+			return err
+		},
+	},
+	{
+		Name: "dedupe imported trades",
+		Up: func(ctx context.Context, q *Query) error {
+			// trades recorded by hand have no transaction id
+			_, err := q.tradeColl().Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "broker", Value: 1}, {Key: "transactionId", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.D{
+					{Key: "transactionId", Value: bson.D{{Key: "$exists", Value: true}}},
+				}),
+			})
+			return err
+		},
+	},
+	{
+		Name: "index signals",
+		Up: func(ctx context.Context, q *Query) error {
+			_, err := q.signalColl().Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "symbol", Value: 1}, {Key: "date", Value: 1}},
+			})
+			return err
+		},
+	},
+}
+
+func (q *Query) migrationColl() *mongo.Collection {
+	return q.PriceColl.Database().Collection("migrations")
+}
+
+func (q *Query) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	cursor, err := q.migrationColl().Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "version", Value: 1}}))
+	if err != nil {
+		fmt.Println("failed to get migrations")
+		return nil, err
+	}
+
+	var applied []migrationRecord
+	err = cursor.All(ctx, &applied)
+	if err != nil {
+		fmt.Println("faile to decode migrations")
+		return nil, err
+	}
+
+	names := make([]string, 0, len(mongoMigrations))
+	for _, m := range mongoMigrations {
+		names = append(names, m.Name)
+	}
+
+	return migrationStatus(names, applied), nil
+}
+
+func (q *Query) MigrateUp(ctx context.Context) ([]MigrationStatus, error) {
+	statuses, err := q.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotTooNew(statuses)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = q.migrationColl().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []MigrationStatus{}
+	for i, m := range mongoMigrations {
+		if !statuses[i].AppliedAt.IsZero() {
+			continue
+		}
+
+		err := m.Up(ctx, q)
+		if err != nil {
+			fmt.Printf("failed to apply migration %d\n", i+1)
+			return applied, err
+		}
+
+		record := migrationRecord{
+			Version:   i + 1,
+			Name:      m.Name,
+			AppliedAt: primitive.NewDateTimeFromTime(time.Now()),
+		}
+		_, err = q.migrationColl().InsertOne(ctx, record)
+		if err != nil {
+			fmt.Println("failed to record migration")
+			return applied, err
+		}
+		applied = append(applied, MigrationStatus{Version: record.Version, Name: record.Name, AppliedAt: record.AppliedAt.Time()})
+	}
+
+	return applied, nil
+}
+
+// checkNotTooNew stops migrations on a schema written by a newer binary.
+func checkNotTooNew(statuses []MigrationStatus) error {
+	for _, s := range statuses {
+		if s.Name == "" {
+			return fmt.Errorf("%w: applied migration %d is unknown", ErrSchemaTooNew, s.Version)
+		}
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/jingen11/stonk-tracker/internal/models"
@@ -16,167 +17,956 @@ import (
 type Query struct {
 	SymbolColl *mongo.Collection
 	PriceColl  *mongo.Collection
-}
+	// TimeSeries is set when PriceColl is a time-series collection, see GetPriceLayout
+	TimeSeries bool
 
-type GetStockPriceOpt struct {
-	Symbol string
-	Limit  int64
+	txOnce      sync.Once
+	txSupported bool
 }
 
+const importBatchSize = 1000
+
 func (q *Query) InsertStockPrice(stock models.StockData, ctx context.Context) (*models.Price, error) {
-	p := models.Price{}
-	symbol := stock.Symbol
+	p, err := stockDataToPrice(stock.Symbol, stock)
+	if err != nil {
+		fmt.Println("Failed to parse stonkDate")
+		return &p, err
+	}
 
-	symbolDoc := q.SymbolColl.FindOne(ctx, bson.M{"symbol": symbol})
+	err = q.withTransaction(ctx, q.supportsTransactions(ctx), func(ctx context.Context) error {
+		if q.TimeSeries {
+			existing, err := q.existingPrices(ctx, p.Symbol, []primitive.DateTime{p.Date})
+			if err != nil {
+				return err
+			}
+			if len(existing) > 0 {
+				return ErrDuplicatePrice
+			}
+		}
 
-	if symbolDoc.Err() != nil && symbolDoc.Err() != mongo.ErrNoDocuments {
-		fmt.Println("Failed to find symbol")
-		return &p, symbolDoc.Err()
+		p.Id = primitive.NilObjectID
+		inserted, err := q.PriceColl.InsertOne(ctx, p)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicatePrice
+		}
+		if err != nil {
+			fmt.Println("Failed to insert price")
+			return err
+		}
+		p.Id = inserted.InsertedID.(primitive.ObjectID)
+
+		// the symbol only exists once its first price landed
+		symbolStruct, err := q.findOrCreateSymbol(ctx, p.Symbol)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.UpdateHeikinAshi(ctx, p.Symbol, p.Date.Time())
+		if err != nil {
+			return err
+		}
+
+		if p.Date.Time().After(symbolStruct.LastFetchedDate.Time()) {
+			return q.updateLastFetchedDate(ctx, symbolStruct.Id, p.Date.Time())
+		}
+		return nil
+	})
+
+	return &p, err
+}
+
+func (q *Query) GetAllSymbols(ctx context.Context) ([]models.Symbol, error) {
+	symbolCursor, err := q.SymbolColl.Find(ctx, bson.M{})
+
+	if err != nil {
+		fmt.Println("failed to get all symbols")
+		return nil, err
 	}
 
-	symbolStruct := models.Symbol{}
+	var symbols []models.Symbol
 
-	if symbolDoc.Err() == mongo.ErrNoDocuments {
-		lastFetchedDate, err := time.Parse("2006-01-02", stock.From)
+	err = symbolCursor.All(ctx, &symbols)
+
+	if err != nil {
+		fmt.Println("faile to decode all symbols")
+		return nil, err
+	}
+
+	return symbols, nil
+}
+
+func (q *Query) GetStockPrices(ctx context.Context, opt *GetStockPriceOpt) ([]models.Price, error) {
+	err := opt.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	order := -1
+	cmp := "$lt"
+	if opt.Order == ASCENDING {
+		order = 1
+		cmp = "$gt"
+	}
+
+	opts := options.Find()
+	opts.SetLimit(opt.Limit)
+	opts.SetSort(bson.D{
+		{Key: "symbol", Value: 1},
+		{Key: "date", Value: order},
+	})
+	if len(opt.Fields) > 0 {
+		projection := bson.D{
+			{Key: "symbol", Value: 1},
+			{Key: "date", Value: 1},
+		}
+		for _, f := range opt.Fields {
+			if f != "symbol" && f != "date" {
+				projection = append(projection, bson.E{Key: f, Value: 1})
+			}
+		}
+		opts.SetProjection(projection)
+	}
+
+	filters := bson.D{
+		{Key: "symbol", Value: bson.D{{Key: "$in", Value: opt.symbols()}}},
+		{Key: "date", Value: bson.D{
+			{Key: "$gte", Value: primitive.NewDateTimeFromTime(opt.From)},
+			{Key: "$lte", Value: primitive.NewDateTimeFromTime(opt.To)},
+		}},
+	}
+	if opt.After != nil {
+		filters = append(filters, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "symbol", Value: bson.D{{Key: "$gt", Value: opt.After.Symbol}}}},
+			bson.D{
+				{Key: "symbol", Value: opt.After.Symbol},
+				{Key: "date", Value: bson.D{{Key: cmp, Value: opt.After.Date}}},
+			},
+		}})
+	}
+
+	priceCursor, err := q.PriceColl.Find(ctx, filters, opts)
+	if err != nil {
+		fmt.Println("failed to get price for symbol")
+		return nil, err
+	}
+	var prices []models.Price
+
+	err = priceCursor.All(ctx, &prices)
+
+	if err != nil {
+		fmt.Println("faile to decode all prices")
+		return nil, err
+	}
+
+	return prices, nil
+}
+
+// GetLatestStockPrices runs one aggregation for every symbol rather than a
+// query per symbol. Prices are ranked within their symbol so only the latest n
+// of each reach the group stage.
+func (q *Query) GetLatestStockPrices(ctx context.Context, symbols []string, n int64) (map[string][]models.Price, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "symbol", Value: bson.D{{Key: "$in", Value: symbols}}},
+			{Key: "date", Value: bson.D{{Key: "$lte", Value: primitive.NewDateTimeFromTime(time.Now())}}},
+		}}},
+		{{Key: "$setWindowFields", Value: bson.D{
+			{Key: "partitionBy", Value: "$symbol"},
+			{Key: "sortBy", Value: bson.D{{Key: "date", Value: -1}}},
+			{Key: "output", Value: bson.D{{Key: "rank", Value: bson.D{{Key: "$documentNumber", Value: bson.D{}}}}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "rank", Value: bson.D{{Key: "$lte", Value: n}}}}}},
+		{{Key: "$sort", Value: bson.D{
+			{Key: "symbol", Value: 1},
+			{Key: "date", Value: -1},
+		}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$symbol"},
+			{Key: "prices", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}},
+		}}},
+	}
+
+	cursor, err := q.PriceColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		fmt.Println("failed to get latest prices")
+		return nil, err
+	}
+
+	var groups []struct {
+		Symbol string         `bson:"_id"`
+		Prices []models.Price `bson:"prices"`
+	}
+	err = cursor.All(ctx, &groups)
+	if err != nil {
+		fmt.Println("faile to decode latest prices")
+		return nil, err
+	}
+
+	latest := map[string][]models.Price{}
+	for _, g := range groups {
+		latest[g.Symbol] = g.Prices
+	}
+
+	return latest, nil
+}
+
+// InsertSymbolStockPrices upserts stocks keyed on (symbol, date) with an
+// unordered bulk write, so dates that are already stored are updated in place
+// instead of failing the batch. lastFetchedDate moves to the latest date that
+// landed, but never past a date whose write failed, so the next refresh
+// fetches it again.
+//
+// Within a transaction the prices, the symbol and its watermark land together
+// or not at all. Without one, see withTransaction, prices are written before
+// the symbol and its watermark, so an interruption leaves prices the next
+// refresh fetches again and repair can account for, never a watermark past
+// missing prices.
+func (q *Query) InsertSymbolStockPrices(stocks []models.StockData, symbol string, ctx context.Context) (*UpsertResult, error) {
+	result := &UpsertResult{}
+	if len(stocks) == 0 {
+		return result, errors.New("no stocks found")
+	}
+
+	dates := make([]time.Time, 0, len(stocks))
+	prices := make([]models.Price, 0, len(stocks))
+
+	for _, stonk := range stocks {
+		p, err := stockDataToPrice(symbol, stonk)
 		if err != nil {
 			fmt.Println("Failed to parse stonkDate")
-			return &p, err
+			return result, err
 		}
+		dates = append(dates, p.Date.Time())
+		prices = append(prices, p)
+	}
 
-		newSymbol := models.Symbol{
-			Symbol:          symbol,
-			LastFetchedDate: primitive.NewDateTimeFromTime(lastFetchedDate),
+	transactional := q.supportsTransactions(ctx)
+	err := q.withTransaction(ctx, transactional, func(ctx context.Context) error {
+		// transactions are retried from the start on transient errors
+		*result = UpsertResult{}
+
+		var failed map[int]bool
+		var writeErr error
+		if q.TimeSeries {
+			failed, writeErr = q.upsertTimeSeriesPrices(ctx, symbol, prices, result)
+		} else {
+			failed, writeErr = q.upsertPrices(ctx, symbol, prices, result)
+		}
+		if failed == nil || (transactional && writeErr != nil) {
+			fmt.Println("failed to upsert prices")
+			return writeErr
 		}
-		insertedSymbol, err := q.SymbolColl.InsertOne(ctx, newSymbol)
 
+		symbolStruct, err := q.findOrCreateSymbol(ctx, symbol)
 		if err != nil {
-			fmt.Println("Failed to insert symbol")
-			return &p, err
+			return err
 		}
 
-		newSymbol.Id = insertedSymbol.InsertedID.(primitive.ObjectID)
-		symbolStruct = newSymbol
-	} else {
-		err := symbolDoc.Decode(&symbolStruct)
+		lastFetchedDate := symbolStruct.LastFetchedDate.Time() // 1970-01-01 || lags behind date remains constant
+		latestDate := landedWatermark(lastFetchedDate, dates, failed)
 
+		if latestDate.After(lastFetchedDate) {
+			err := q.updateLastFetchedDate(ctx, symbolStruct.Id, latestDate)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		// the bulk result does not tell which dates changed, so chain from the earliest that landed
+		if result.Inserted+result.Updated > 0 {
+			var changedFrom time.Time
+			for i, d := range dates {
+				if !failed[i] && (changedFrom.IsZero() || d.Before(changedFrom)) {
+					changedFrom = d
+				}
+			}
+
+			_, err := q.UpdateHeikinAshi(ctx, symbol, changedFrom)
+			if err != nil {
+				return err
+			}
+		}
+
+		if writeErr != nil {
+			fmt.Println("failed to upsert prices")
+		}
+		return writeErr
+	})
+
+	if err != nil && transactional {
+		// the transaction was aborted, nothing landed
+		*result = UpsertResult{Failed: len(stocks)}
+	}
+
+	return result, err
+}
+
+// upsertPrices writes prices with one unordered bulk write of upserts and
+// returns the indexes of prices that failed to land, nil when the whole write
+// failed.
+func (q *Query) upsertPrices(ctx context.Context, symbol string, prices []models.Price, result *UpsertResult) (map[int]bool, error) {
+	writes := make([]mongo.WriteModel, 0, len(prices))
+	for _, p := range prices {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{
+				{Key: "symbol", Value: symbol},
+				{Key: "date", Value: p.Date},
+			}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{
+				{Key: "open", Value: p.Open},
+				{Key: "high", Value: p.High},
+				{Key: "low", Value: p.Low},
+				{Key: "close", Value: p.Close},
+				{Key: "volume", Value: p.Volume},
+			}}}).
+			SetUpsert(true))
+	}
+
+	res, err := q.PriceColl.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+
+	failed := map[int]bool{}
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		return nil, err
+	}
+	for _, we := range bulkErr.WriteErrors {
+		failed[we.Index] = true
+	}
+
+	if res != nil {
+		result.Inserted = int(res.UpsertedCount)
+		result.Updated = int(res.ModifiedCount)
+		result.Unchanged = int(res.MatchedCount - res.ModifiedCount)
+	}
+	result.Failed = len(failed)
+
+	return failed, err
+}
+
+// ImportStockPrices writes prices for a single symbol in unordered batches so
+// rows that already exist are skipped instead of aborting the import. The
+// symbol is created when missing and its lastFetchedDate only ever moves
+// forward. Deployments supporting transactions land the whole import or
+// nothing, like InsertSymbolStockPrices.
+func (q *Query) ImportStockPrices(ctx context.Context, symbol string, prices []models.Price) (*ImportResult, error) {
+	result := &ImportResult{}
+	if len(prices) == 0 {
+		return result, nil
+	}
+
+	transactional := q.supportsTransactions(ctx)
+	err := q.withTransaction(ctx, transactional, func(ctx context.Context) error {
+		// transactions are retried from the start on transient errors
+		*result = ImportResult{}
+
+		symbolStruct, err := q.findOrCreateSymbol(ctx, symbol)
 		if err != nil {
-			fmt.Println("Failed to decode symbol")
-			return &p, err
+			return err
+		}
+
+		lastFetchedDate := symbolStruct.LastFetchedDate.Time()
+		latestDate := lastFetchedDate
+		landedDate := lastFetchedDate
+		var changedFrom time.Time
+
+		for start := 0; start < len(prices); start += importBatchSize {
+			end := min(start+importBatchSize, len(prices))
+
+			batch := make([]models.Price, 0, end-start)
+			for _, p := range prices[start:end] {
+				p.Symbol = symbol
+				batch = append(batch, p)
+				// duplicates are already stored, so every row of the batch counts towards the watermark
+				if p.Date.Time().After(latestDate) {
+					latestDate = p.Date.Time()
+				}
+				if changedFrom.IsZero() || p.Date.Time().Before(changedFrom) {
+					changedFrom = p.Date.Time()
+				}
+			}
+
+			var duplicates int
+			if q.TimeSeries {
+				duplicates, err = q.insertTimeSeriesPrices(ctx, symbol, batch)
+			} else {
+				duplicates, err = q.insertMissingPrices(ctx, batch)
+			}
+			if err != nil {
+				fmt.Println("failed to import prices")
+				// without a transaction earlier batches have landed, keep the watermark in line with them
+				if !transactional && landedDate.After(lastFetchedDate) {
+					q.updateLastFetchedDate(context.WithoutCancel(ctx), symbolStruct.Id, landedDate)
+				}
+				return err
+			}
+
+			result.Duplicates += duplicates
+			result.Inserted += len(batch) - duplicates
+			landedDate = latestDate
+		}
+
+		if latestDate.After(lastFetchedDate) {
+			err := q.updateLastFetchedDate(ctx, symbolStruct.Id, latestDate)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if result.Inserted > 0 {
+			_, err := q.UpdateHeikinAshi(ctx, symbol, changedFrom)
+			if err != nil {
+				return err
+			}
 		}
+
+		return nil
+	})
+
+	if err != nil && transactional {
+		// the transaction was aborted, nothing landed
+		*result = ImportResult{}
 	}
 
-	lastFetchedDate := symbolStruct.LastFetchedDate.Time()
+	return result, err
+}
 
-	stonkDate, err := time.Parse("2006-01-02", stock.From)
+// insertMissingPrices inserts the prices whose date is not stored yet and
+// returns how many were skipped as duplicates. It upserts rather than inserts:
+// a duplicate key error would abort the transaction the import runs in.
+func (q *Query) insertMissingPrices(ctx context.Context, prices []models.Price) (int, error) {
+	writes := make([]mongo.WriteModel, 0, len(prices))
+	for _, p := range prices {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{
+				{Key: "symbol", Value: p.Symbol},
+				{Key: "date", Value: p.Date},
+			}).
+			SetUpdate(bson.D{{Key: "$setOnInsert", Value: p}}).
+			SetUpsert(true))
+	}
 
+	res, err := q.PriceColl.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
 	if err != nil {
-		fmt.Println("Failed to parse stonkDate")
-		return &p, err
+		return 0, err
 	}
+	return int(res.MatchedCount), nil
+}
 
-	p = models.Price{
-		Symbol: symbol,
-		Date:   primitive.NewDateTimeFromTime(stonkDate),
-		Open:   stock.Open,
-		Close:  stock.Close,
-		High:   stock.High,
-		Low:    stock.Low,
-		Volume: stock.Volume,
+// UpdateHeikinAshi chains the heikin ashi candles of symbol from the stored
+// price before from, and rewrites every price from that date onwards.
+func (q *Query) UpdateHeikinAshi(ctx context.Context, symbol string, from time.Time) (int, error) {
+	prev := &models.Price{}
+	err := q.PriceColl.FindOne(ctx, bson.D{
+		{Key: "symbol", Value: symbol},
+		{Key: "date", Value: bson.D{{Key: "$lt", Value: primitive.NewDateTimeFromTime(from)}}},
+	}, options.FindOne().SetSort(bson.D{{Key: "date", Value: -1}})).Decode(prev)
+
+	switch {
+	case err == mongo.ErrNoDocuments:
+		prev = nil
+	case err != nil:
+		fmt.Println("failed to get previous price")
+		return 0, err
+	case !hasHeikinAshi(prev):
+		prev = nil
+		from = epoch
 	}
 
-	inserted, err := q.PriceColl.InsertOne(ctx, p)
+	priceCursor, err := q.PriceColl.Find(ctx, bson.D{
+		{Key: "symbol", Value: symbol},
+		{Key: "date", Value: bson.D{{Key: "$gte", Value: primitive.NewDateTimeFromTime(from)}}},
+	}, options.Find().SetSort(bson.D{{Key: "date", Value: 1}}))
+	if err != nil {
+		fmt.Println("failed to get price for symbol")
+		return 0, err
+	}
 
+	var prices []models.Price
+	err = priceCursor.All(ctx, &prices)
 	if err != nil {
-		fmt.Println("Failed to parse stonkDate")
-		return &p, err
+		fmt.Println("faile to decode all prices")
+		return 0, err
+	}
+	if len(prices) == 0 {
+		return 0, nil
 	}
 
-	p.Id = inserted.InsertedID.(primitive.ObjectID)
+	chainHeikinAshi(prev, prices)
 
-	if stonkDate.After(lastFetchedDate) {
-		_, err := q.SymbolColl.UpdateByID(ctx, symbolStruct.Id, bson.D{
-			{"$set", bson.D{{"lastFetchedDate", primitive.NewDateTimeFromTime(stonkDate)}}},
-		})
+	writes := make([]mongo.WriteModel, 0, len(prices))
+	for _, p := range prices {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: p.Id}}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{
+				{Key: "haOpen", Value: p.HAOpen},
+				{Key: "haClose", Value: p.HAClose},
+				{Key: "haHigh", Value: p.HAHigh},
+				{Key: "haLow", Value: p.HALow},
+			}}}))
+	}
+
+	_, err = q.PriceColl.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		fmt.Println("failed to update heikin ashi")
+		return 0, err
+	}
+
+	return len(prices), nil
+}
 
+// RepairLastFetchedDates compares every symbol with the latest date stored for
+// it, which writes outside of a transaction can leave behind.
+func (q *Query) RepairLastFetchedDates(ctx context.Context, dryRun bool) ([]SymbolRepair, error) {
+	cursor, err := q.PriceColl.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$symbol"},
+			{Key: "latestDate", Value: bson.D{{Key: "$max", Value: "$date"}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	})
+	if err != nil {
+		fmt.Println("failed to get latest dates")
+		return nil, err
+	}
+
+	var latest []struct {
+		Symbol     string             `bson:"_id"`
+		LatestDate primitive.DateTime `bson:"latestDate"`
+	}
+	err = cursor.All(ctx, &latest)
+	if err != nil {
+		fmt.Println("faile to decode latest dates")
+		return nil, err
+	}
+
+	symbols, err := q.GetAllSymbols(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bySymbol := map[string]models.Symbol{}
+	for _, s := range symbols {
+		bySymbol[s.Symbol] = s
+	}
+
+	repairs := []SymbolRepair{}
+	for _, l := range latest {
+		s, ok := bySymbol[l.Symbol]
+		if ok && s.LastFetchedDate == l.LatestDate {
+			continue
+		}
+
+		r := SymbolRepair{Symbol: l.Symbol, To: l.LatestDate.Time(), Created: !ok}
+		if ok {
+			r.From = s.LastFetchedDate.Time()
+		}
+		repairs = append(repairs, r)
+		if dryRun {
+			continue
+		}
+
+		if !ok {
+			s, err = q.findOrCreateSymbol(ctx, l.Symbol)
+			if err != nil {
+				return repairs, err
+			}
+		}
+		err := q.updateLastFetchedDate(ctx, s.Id, r.To)
 		if err != nil {
-			fmt.Println("failed to update symbol lastFetch")
-			return &p, err
+			return repairs, err
 		}
 	}
 
-	return &p, nil
+	return repairs, nil
 }
 
-func (q *Query) GetAllSymbols(ctx context.Context) ([]models.Symbol, error) {
-	symbolCursor, err := q.SymbolColl.Find(ctx, bson.M{})
+func (q *Query) ResolveSymbol(ctx context.Context, symbol string) (models.Symbol, error) {
+	symbolStruct := models.Symbol{}
+	err := q.SymbolColl.FindOne(ctx, bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "symbol", Value: symbol}},
+		bson.D{{Key: "aliases", Value: symbol}},
+	}}}).Decode(&symbolStruct)
 
+	if err == mongo.ErrNoDocuments {
+		return symbolStruct, ErrUnknownSymbol
+	}
 	if err != nil {
-		fmt.Println("failed to get all symbols")
-		return nil, err
+		fmt.Println("Failed to find symbol")
+		return symbolStruct, err
 	}
 
-	var symbols []models.Symbol
+	return symbolStruct, nil
+}
 
-	err = symbolCursor.All(ctx, &symbols)
+// RemoveSymbol deletes the prices before the symbol, so that an interrupted
+// remove outside a transaction can be run again.
+func (q *Query) RemoveSymbol(ctx context.Context, symbol string) (int, error) {
+	removed := 0
+	err := q.withTransaction(ctx, q.supportsTransactions(ctx), func(ctx context.Context) error {
+		count, err := q.SymbolColl.CountDocuments(ctx, bson.D{{Key: "symbol", Value: symbol}})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrUnknownSymbol
+		}
+
+		deleted, err := q.PriceColl.DeleteMany(ctx, bson.D{{Key: "symbol", Value: symbol}})
+		if err != nil {
+			return err
+		}
+		removed = int(deleted.DeletedCount)
+
+		_, err = q.signalColl().DeleteMany(ctx, bson.D{{Key: "symbol", Value: symbol}})
+		if err != nil {
+			return err
+		}
+
+		_, err = q.SymbolColl.DeleteOne(ctx, bson.D{{Key: "symbol", Value: symbol}})
+		return err
+	})
 
 	if err != nil {
-		fmt.Println("faile to decode all symbols")
+		fmt.Println("failed to remove symbol")
+		return 0, err
+	}
+	return removed, nil
+}
+
+func (q *Query) ArchiveSymbol(ctx context.Context, symbol string, archived bool) error {
+	updated, err := q.SymbolColl.UpdateOne(ctx, bson.D{{Key: "symbol", Value: symbol}}, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "archived", Value: archived}}},
+	})
+
+	if err != nil {
+		fmt.Println("failed to archive symbol")
+		return err
+	}
+	if updated.MatchedCount == 0 {
+		return ErrUnknownSymbol
+	}
+	return nil
+}
+
+// RenameSymbol moves the prices before the symbol, so that an interrupted
+// rename outside a transaction can be run again.
+func (q *Query) RenameSymbol(ctx context.Context, symbol string, to string) (int, error) {
+	moved := 0
+	err := q.withTransaction(ctx, q.supportsTransactions(ctx), func(ctx context.Context) error {
+		symbolStruct := models.Symbol{}
+		err := q.SymbolColl.FindOne(ctx, bson.D{{Key: "symbol", Value: symbol}}).Decode(&symbolStruct)
+		if err == mongo.ErrNoDocuments {
+			return ErrUnknownSymbol
+		}
+		if err != nil {
+			return err
+		}
+
+		other, err := q.ResolveSymbol(ctx, to)
+		if err == nil && other.Id != symbolStruct.Id {
+			return ErrSymbolExists
+		}
+		if err != nil && err != ErrUnknownSymbol {
+			return err
+		}
+
+		updated, err := q.PriceColl.UpdateMany(ctx, bson.D{{Key: "symbol", Value: symbol}}, bson.D{
+			{Key: "$set", Value: bson.D{{Key: "symbol", Value: to}}},
+		})
+		if err != nil {
+			return err
+		}
+		moved = int(updated.ModifiedCount)
+
+		for _, coll := range []*mongo.Collection{q.tradeColl(), q.signalColl()} {
+			_, err = coll.UpdateMany(ctx, bson.D{{Key: "symbol", Value: symbol}}, bson.D{
+				{Key: "$set", Value: bson.D{{Key: "symbol", Value: to}}},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = q.SymbolColl.UpdateByID(ctx, symbolStruct.Id, bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "symbol", Value: to},
+				{Key: "aliases", Value: renamedAliases(symbolStruct, to)},
+			}},
+		})
+		return err
+	})
+
+	if err != nil {
+		fmt.Println("failed to rename symbol")
+		return 0, err
+	}
+	return moved, nil
+}
+
+func (q *Query) SetSymbolTag(ctx context.Context, symbol string, tag string, tagged bool) error {
+	err := q.setSymbolListMember(ctx, symbol, "tags", tag, tagged)
+	if err != nil {
+		fmt.Println("failed to tag symbol")
+	}
+	return err
+}
+
+func (q *Query) watchlistColl() *mongo.Collection {
+	return q.SymbolColl.Database().Collection("watchlist")
+}
+
+func (q *Query) GetWatchlists(ctx context.Context) ([]models.Watchlist, error) {
+	cursor, err := q.watchlistColl().Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		fmt.Println("failed to get watchlists")
 		return nil, err
 	}
 
-	return symbols, nil
+	watchlists := []models.Watchlist{}
+	err = cursor.All(ctx, &watchlists)
+	if err != nil {
+		fmt.Println("faile to decode watchlists")
+		return nil, err
+	}
+	return watchlists, nil
 }
 
-func (q *Query) GetStockPrices(ctx context.Context, opt *GetStockPriceOpt) ([]models.Price, error) {
-	if opt.Limit == 0 {
-		opt.Limit = 100
+func (q *Query) CreateWatchlist(ctx context.Context, name string) error {
+	_, err := q.watchlistColl().InsertOne(ctx, models.Watchlist{
+		Name:      name,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrWatchlistExists
 	}
-	opts := options.Find()
-	opts.SetLimit(opt.Limit)
-	opts.SetSort(bson.M{
-		"date": -1,
+	if err != nil {
+		fmt.Println("failed to create watchlist")
+		return err
+	}
+	return nil
+}
+
+// DeleteWatchlist deletes the watchlist before taking its symbols out, a
+// symbol left in a deleted watchlist is ignored by the commands.
+func (q *Query) DeleteWatchlist(ctx context.Context, name string) error {
+	deleted, err := q.watchlistColl().DeleteOne(ctx, bson.D{{Key: "name", Value: name}})
+	if err != nil {
+		fmt.Println("failed to delete watchlist")
+		return err
+	}
+	if deleted.DeletedCount == 0 {
+		return ErrUnknownWatchlist
+	}
+
+	_, err = q.SymbolColl.UpdateMany(ctx, bson.D{{Key: "watchlists", Value: name}}, bson.D{
+		{Key: "$pull", Value: bson.D{{Key: "watchlists", Value: name}}},
 	})
-	filters := bson.M{
-		"date": bson.M{
-			"$lte": primitive.NewDateTimeFromTime(time.Now()),
-		},
-		"symbol": opt.Symbol,
+	if err != nil {
+		fmt.Println("failed to take symbols out of watchlist")
+		return err
 	}
-	priceCursor, err := q.PriceColl.Find(ctx, filters, opts)
+	return nil
+}
+
+func (q *Query) SetWatchlistMember(ctx context.Context, watchlist string, symbol string, member bool) error {
+	count, err := q.watchlistColl().CountDocuments(ctx, bson.D{{Key: "name", Value: watchlist}})
 	if err != nil {
-		fmt.Println("failed to get price for symbol")
-		return nil, err
+		fmt.Println("failed to find watchlist")
+		return err
+	}
+	if count == 0 {
+		return ErrUnknownWatchlist
 	}
-	var prices []models.Price
 
-	err = priceCursor.All(ctx, &prices)
+	err = q.setSymbolListMember(ctx, symbol, "watchlists", watchlist, member)
+	if err != nil {
+		fmt.Println("failed to update watchlist")
+	}
+	return err
+}
+
+// setSymbolListMember adds value to the sorted list field of symbol, or pulls
+// it when member is false.
+func (q *Query) setSymbolListMember(ctx context.Context, symbol string, field string, value string, member bool) error {
+	filter := bson.D{{Key: "symbol", Value: symbol}}
+	update := bson.D{{Key: "$pull", Value: bson.D{{Key: field, Value: value}}}}
+	if member {
+		filter = append(filter, bson.E{Key: field, Value: bson.D{{Key: "$ne", Value: value}}})
+		update = bson.D{{Key: "$push", Value: bson.D{{Key: field, Value: bson.D{
+			{Key: "$each", Value: bson.A{value}},
+			{Key: "$sort", Value: 1},
+		}}}}}
+	}
 
+	updated, err := q.SymbolColl.UpdateOne(ctx, filter, update)
 	if err != nil {
-		fmt.Println("faile to decode all prices")
+		return err
+	}
+	if updated.MatchedCount > 0 {
+		return nil
+	}
+
+	// nothing matched, either the value is already there or the symbol is missing
+	count, err := q.SymbolColl.CountDocuments(ctx, bson.D{{Key: "symbol", Value: symbol}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrUnknownSymbol
+	}
+	return nil
+}
+
+func (q *Query) tradeColl() *mongo.Collection {
+	return q.SymbolColl.Database().Collection("trades")
+}
+
+func (q *Query) InsertTrade(ctx context.Context, trade models.Trade) (models.Trade, error) {
+	trade.Id = primitive.NewObjectID()
+	_, err := q.tradeColl().InsertOne(ctx, trade)
+	if err != nil {
+		fmt.Println("failed to insert trade")
+		return trade, err
+	}
+	return trade, nil
+}
+
+func (q *Query) GetTrades(ctx context.Context, symbols []string) ([]models.Trade, error) {
+	filter := bson.D{}
+	if len(symbols) > 0 {
+		filter = bson.D{{Key: "symbol", Value: bson.D{{Key: "$in", Value: symbols}}}}
+	}
+
+	// object ids grow with time, they keep the recording order of a date
+	cursor, err := q.tradeColl().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		fmt.Println("failed to get trades")
 		return nil, err
 	}
 
-	return prices, nil
+	trades := []models.Trade{}
+	err = cursor.All(ctx, &trades)
+	if err != nil {
+		fmt.Println("faile to decode trades")
+		return nil, err
+	}
+	return trades, nil
 }
 
-func (q *Query) InsertSymbolStockPrices(stocks []models.StockData, symbol string, ctx context.Context) (int, error) {
-	if len(stocks) == 0 {
-		return 0, errors.New("no stocks found")
+// ImportTrades relies on the unique (broker, transactionId) index to skip the
+// trades already recorded.
+func (q *Query) ImportTrades(ctx context.Context, trades []models.Trade) (*ImportResult, error) {
+	result := &ImportResult{}
+	if len(trades) == 0 {
+		return result, nil
+	}
+
+	docs := make([]interface{}, 0, len(trades))
+	for _, t := range trades {
+		t.Id = primitive.NewObjectID()
+		docs = append(docs, t)
+	}
+
+	_, err := q.tradeColl().InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	duplicates, err := countDuplicateKeyErrors(err)
+	if err != nil {
+		fmt.Println("failed to import trades")
+		return result, err
+	}
+
+	result.Inserted = len(trades) - duplicates
+	result.Duplicates = duplicates
+	return result, nil
+}
+
+func (q *Query) CreateSymbol(ctx context.Context, symbol string, lastFetchedDate time.Time) (models.Symbol, error) {
+	newSymbol := models.Symbol{
+		Symbol:          symbol,
+		LastFetchedDate: primitive.NewDateTimeFromTime(lastFetchedDate),
+	}
+	inserted, err := q.SymbolColl.InsertOne(ctx, newSymbol)
+	if mongo.IsDuplicateKeyError(err) {
+		return models.Symbol{}, ErrSymbolExists
+	}
+	if err != nil {
+		fmt.Println("Failed to insert symbol")
+		return newSymbol, err
+	}
+
+	newSymbol.Id = inserted.InsertedID.(primitive.ObjectID)
+	return newSymbol, nil
+}
+
+func (q *Query) DeleteTrade(ctx context.Context, id primitive.ObjectID) error {
+	deleted, err := q.tradeColl().DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		fmt.Println("failed to delete trade")
+		return err
+	}
+	if deleted.DeletedCount == 0 {
+		return ErrUnknownTrade
+	}
+	return nil
+}
+
+func (q *Query) signalColl() *mongo.Collection {
+	return q.SymbolColl.Database().Collection("signals")
+}
+
+func (q *Query) InsertSignal(ctx context.Context, signal models.Signal) (models.Signal, error) {
+	signal.Id = primitive.NewObjectID()
+	_, err := q.signalColl().InsertOne(ctx, signal)
+	if err != nil {
+		fmt.Println("failed to insert signal")
+		return signal, err
+	}
+	return signal, nil
+}
+
+func (q *Query) GetSignals(ctx context.Context, opt *GetSignalOpt) ([]models.Signal, error) {
+	filter := bson.D{{Key: "symbol", Value: opt.Symbol}}
+	date := bson.D{}
+	if !opt.From.IsZero() {
+		date = append(date, bson.E{Key: "$gte", Value: primitive.NewDateTimeFromTime(opt.From)})
+	}
+	if !opt.To.IsZero() {
+		date = append(date, bson.E{Key: "$lte", Value: primitive.NewDateTimeFromTime(opt.To)})
+	}
+	if len(date) > 0 {
+		filter = append(filter, bson.E{Key: "date", Value: date})
+	}
+
+	// object ids grow with time, they keep the evaluation order of a bar
+	cursor, err := q.signalColl().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		fmt.Println("failed to get signals")
+		return nil, err
+	}
+
+	signals := []models.Signal{}
+	err = cursor.All(ctx, &signals)
+	if err != nil {
+		fmt.Println("faile to decode signals")
+		return nil, err
 	}
+	return signals, nil
+}
+
+func (q *Query) findOrCreateSymbol(ctx context.Context, symbol string) (models.Symbol, error) {
+	symbolStruct := models.Symbol{}
 	symbolDoc := q.SymbolColl.FindOne(ctx, bson.M{"symbol": symbol})
 
 	if symbolDoc.Err() != nil && symbolDoc.Err() != mongo.ErrNoDocuments {
 		fmt.Println("Failed to find symbol")
-		return 0, symbolDoc.Err()
+		return symbolStruct, symbolDoc.Err()
 	}
 
-	symbolStruct := models.Symbol{}
-
 	if symbolDoc.Err() == mongo.ErrNoDocuments {
 		lastFetchedDate, err := time.Parse("2006-01-02", "1970-01-01")
 		if err != nil {
 			fmt.Println("Failed to parse lastFetchedDate")
-			return 0, err
+			return symbolStruct, err
 		}
 		newSymbol := models.Symbol{
 			Symbol:          symbol,
@@ -186,64 +976,53 @@ func (q *Query) InsertSymbolStockPrices(stocks []models.StockData, symbol string
 
 		if err != nil {
 			fmt.Println("Failed to insert symbol")
-			return 0, err
+			return symbolStruct, err
 		}
 
 		newSymbol.Id = insertedSymbol.InsertedID.(primitive.ObjectID)
-		symbolStruct = newSymbol
-	} else {
-		err := symbolDoc.Decode(&symbolStruct)
-
-		if err != nil {
-			fmt.Println("Failed to decode symbol")
-			return 0, err
-		}
+		return newSymbol, nil
 	}
 
-	docs := make([]interface{}, 0)
+	err := symbolDoc.Decode(&symbolStruct)
 
-	lastFetchedDate := symbolStruct.LastFetchedDate.Time() // 1970-01-01 || lags behind date remains constant
+	if err != nil {
+		fmt.Println("Failed to decode symbol")
+		return symbolStruct, err
+	}
 
-	latestDate := lastFetchedDate // to be updated for each iteration
+	return symbolStruct, nil
+}
 
-	for _, stonk := range stocks {
-		stonkDate, err := time.Parse("2006-01-02", stonk.From)
-		if err != nil {
-			fmt.Println("Failed to parse stonkDate")
-			return 0, err
-		}
-		if stonkDate.After(latestDate) {
-			latestDate = stonkDate
-		}
+func (q *Query) updateLastFetchedDate(ctx context.Context, id primitive.ObjectID, date time.Time) error {
+	_, err := q.SymbolColl.UpdateByID(ctx, id, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "lastFetchedDate", Value: primitive.NewDateTimeFromTime(date)}}},
+	})
 
-		docs = append(docs, models.Price{
-			Symbol: symbol,
-			Date:   primitive.NewDateTimeFromTime(stonkDate),
-			Open:   stonk.Open,
-			Close:  stonk.Close,
-			High:   stonk.High,
-			Low:    stonk.Low,
-			Volume: stonk.Volume,
-		})
+	if err != nil {
+		fmt.Println("failed to update symbol lastFetch")
+		return err
 	}
 
-	res, err := q.PriceColl.InsertMany(ctx, docs)
+	return nil
+}
 
-	if err != nil {
-		fmt.Println("failed to insert prices")
-		return 0, err
+// countDuplicateKeyErrors returns the number of writes rejected by the unique
+// (symbol, date) index, or err when anything else went wrong.
+func countDuplicateKeyErrors(err error) (int, error) {
+	if err == nil {
+		return 0, nil
 	}
 
-	if latestDate.After(lastFetchedDate) {
-		_, err := q.SymbolColl.UpdateByID(ctx, symbolStruct.Id, bson.D{
-			{"$set", bson.D{{"lastFetchedDate", primitive.NewDateTimeFromTime(latestDate)}}},
-		})
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return 0, err
+	}
 
-		if err != nil {
-			fmt.Println("failed to update symbol lastFetch")
+	for _, we := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(we) {
 			return 0, err
 		}
 	}
 
-	return len(res.InsertedIDs), nil
+	return len(bulkErr.WriteErrors), nil
 }
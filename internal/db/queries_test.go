@@ -17,8 +17,8 @@ func TestInsertStockPrice(t *testing.T) {
 	defer Disconnect(dbClient)
 
 	stonkDb := dbClient.Database("stonk-test")
-	priceColl, _ := InitPriceCollection(stonkDb)
-	symbolColl, _ := InitSymbolCollection(stonkDb)
+	priceColl, _ := InitPriceCollection(context.Background(), stonkDb)
+	symbolColl, _ := InitSymbolCollection(context.Background(), stonkDb)
 
 	defer priceColl.Drop(context.Background())
 	defer symbolColl.Drop(context.Background())
@@ -98,8 +98,8 @@ func TestInsertSymbolStockPrices(t *testing.T) {
 	defer Disconnect(dbClient)
 
 	stonkDb := dbClient.Database("stonk-test")
-	priceColl, _ := InitPriceCollection(stonkDb)
-	symbolColl, _ := InitSymbolCollection(stonkDb)
+	priceColl, _ := InitPriceCollection(context.Background(), stonkDb)
+	symbolColl, _ := InitSymbolCollection(context.Background(), stonkDb)
 
 	defer priceColl.Drop(context.Background())
 	defer symbolColl.Drop(context.Background())
@@ -287,13 +287,81 @@ func TestInsertSymbolStockPrices(t *testing.T) {
 	}
 }
 
+func TestInsertSymbolStockPricesUpsert(t *testing.T) {
+	url := os.Getenv("MONGODB_URL_TEST")
+	dbClient, _ := Init(url)
+	defer Disconnect(dbClient)
+
+	stonkDb := dbClient.Database("stonk-test")
+	priceColl, _ := InitPriceCollection(context.Background(), stonkDb)
+	symbolColl, _ := InitSymbolCollection(context.Background(), stonkDb)
+
+	defer priceColl.Drop(context.Background())
+	defer symbolColl.Drop(context.Background())
+
+	q := Query{
+		SymbolColl: symbolColl,
+		PriceColl:  priceColl,
+	}
+
+	newStock := func(date string, close float64) models.StockData {
+		return models.StockData{
+			Status: "OK",
+			From:   date,
+			Symbol: "AAPL",
+			Open:   229.57,
+			High:   230.585,
+			Low:    227.2,
+			Close:  close,
+			Volume: 30219759,
+		}
+	}
+
+	cases := []struct {
+		input      []models.StockData
+		inserted   int
+		updated    int
+		unchanged  int
+		latestDate string
+	}{
+		{
+			input:      []models.StockData{newStock("2025-02-03", 227.65), newStock("2025-02-04", 227.65)},
+			inserted:   2,
+			latestDate: "2025-02-04",
+		},
+		{
+			input:      []models.StockData{newStock("2025-02-03", 227.65), newStock("2025-02-04", 228.01), newStock("2025-02-05", 227.65)},
+			inserted:   1,
+			updated:    1,
+			unchanged:  1,
+			latestDate: "2025-02-05",
+		},
+	}
+
+	for _, c := range cases {
+		res, err := q.InsertSymbolStockPrices(c.input, "AAPL", context.Background())
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if res.Inserted != c.inserted || res.Updated != c.updated || res.Unchanged != c.unchanged {
+			t.Fatalf("expected inserted %d updated %d unchanged %d, got %+v", c.inserted, c.updated, c.unchanged, res)
+		}
+		symbol := models.Symbol{}
+		aapl := symbolColl.FindOne(context.Background(), bson.M{"symbol": "AAPL"})
+		aapl.Decode(&symbol)
+		if symbol.LastFetchedDate.Time().Format("2006-01-02") != c.latestDate {
+			t.Fatalf("expected latest date: %s, got date: %s", c.latestDate, symbol.LastFetchedDate.Time().Format("2006-01-02"))
+		}
+	}
+}
+
 func TestGetAllSymbols(t *testing.T) {
 	url := os.Getenv("MONGODB_URL_TEST")
 	dbClient, _ := Init(url)
 	defer Disconnect(dbClient)
 
 	stonkDb := dbClient.Database("stonk-test")
-	symbolColl, _ := InitSymbolCollection(stonkDb)
+	symbolColl, _ := InitSymbolCollection(context.Background(), stonkDb)
 
 	defer symbolColl.Drop(context.Background())
 
@@ -331,7 +399,7 @@ func TestGetStockPrices(t *testing.T) {
 	defer Disconnect(dbClient)
 
 	stonkDb := dbClient.Database("stonk-test")
-	priceColl, _ := InitPriceCollection(stonkDb)
+	priceColl, _ := InitPriceCollection(context.Background(), stonkDb)
 
 	defer priceColl.Drop(context.Background())
 
@@ -408,3 +476,75 @@ func TestGetStockPrices(t *testing.T) {
 		}
 	}
 }
+
+func TestImportStockPrices(t *testing.T) {
+	url := os.Getenv("MONGODB_URL_TEST")
+	dbClient, _ := Init(url)
+	defer Disconnect(dbClient)
+
+	stonkDb := dbClient.Database("stonk-test")
+	priceColl, _ := InitPriceCollection(context.Background(), stonkDb)
+	symbolColl, _ := InitSymbolCollection(context.Background(), stonkDb)
+
+	defer priceColl.Drop(context.Background())
+	defer symbolColl.Drop(context.Background())
+
+	q := Query{
+		SymbolColl: symbolColl,
+		PriceColl:  priceColl,
+	}
+
+	newPrice := func(date string) models.Price {
+		d, _ := time.Parse("2006-01-02", date)
+		return models.Price{
+			Date:   primitive.NewDateTimeFromTime(d),
+			Open:   229.57,
+			High:   230.585,
+			Low:    227.2,
+			Close:  227.65,
+			Volume: 30219759,
+		}
+	}
+
+	cases := []struct {
+		input      []models.Price
+		inserted   int
+		duplicates int
+		latestDate string
+	}{
+		{
+			input:      []models.Price{newPrice("2025-02-03"), newPrice("2025-02-04")},
+			inserted:   2,
+			duplicates: 0,
+			latestDate: "2025-02-04",
+		},
+		{
+			input:      []models.Price{newPrice("2025-02-04"), newPrice("2025-02-05"), newPrice("2025-02-05")},
+			inserted:   1,
+			duplicates: 2,
+			latestDate: "2025-02-05",
+		},
+		{
+			input:      []models.Price{newPrice("2024-02-05")},
+			inserted:   1,
+			duplicates: 0,
+			latestDate: "2025-02-05",
+		},
+	}
+
+	for _, c := range cases {
+		res, err := q.ImportStockPrices(context.Background(), "AAPL", c.input)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if res.Inserted != c.inserted || res.Duplicates != c.duplicates {
+			t.Fatalf("expected inserted %d duplicates %d, got inserted %d duplicates %d", c.inserted, c.duplicates, res.Inserted, res.Duplicates)
+		}
+		symbol := models.Symbol{}
+		aapl := symbolColl.FindOne(context.Background(), bson.M{"symbol": "AAPL"})
+		aapl.Decode(&symbol)
+		if symbol.LastFetchedDate.Time().Format("2006-01-02") != c.latestDate {
+			t.Fatalf("expected latest date: %s, got date: %s", c.latestDate, symbol.LastFetchedDate.Time().Format("2006-01-02"))
+		}
+	}
+}
@@ -0,0 +1,1349 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteMigrationsTable = `
+CREATE TABLE IF NOT EXISTS migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at INTEGER NOT NULL
+);
+`
+
+// dates are stored as unix milliseconds, the same as primitive.DateTime
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS symbol (
+	id TEXT PRIMARY KEY,
+	symbol TEXT NOT NULL UNIQUE,
+	last_fetched_date INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS price (
+	id TEXT PRIMARY KEY,
+	symbol TEXT NOT NULL,
+	date INTEGER NOT NULL,
+	open REAL NOT NULL,
+	high REAL NOT NULL,
+	low REAL NOT NULL,
+	close REAL NOT NULL,
+	volume REAL NOT NULL,
+	after_hours REAL NOT NULL DEFAULT 0,
+	pre_market REAL NOT NULL DEFAULT 0,
+	ha_open REAL NOT NULL DEFAULT 0,
+	ha_close REAL NOT NULL DEFAULT 0,
+	ha_high REAL NOT NULL DEFAULT 0,
+	ha_low REAL NOT NULL DEFAULT 0,
+	UNIQUE (symbol, date)
+);
+`
+
+const sqliteSymbolLifecycleSchema = `
+ALTER TABLE symbol ADD COLUMN archived INTEGER NOT NULL DEFAULT 0;
+CREATE TABLE symbol_alias (
+	alias TEXT PRIMARY KEY,
+	symbol TEXT NOT NULL
+);
+`
+
+// tags and watchlists follow the symbol id, so renames leave them alone
+const sqliteWatchlistSchema = `
+CREATE TABLE symbol_tag (
+	symbol_id TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	PRIMARY KEY (symbol_id, tag)
+);
+CREATE TABLE watchlist (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	created_at INTEGER NOT NULL
+);
+CREATE TABLE watchlist_symbol (
+	watchlist TEXT NOT NULL,
+	symbol_id TEXT NOT NULL,
+	PRIMARY KEY (watchlist, symbol_id)
+);
+`
+
+const sqliteTradeSchema = `
+CREATE TABLE trades (
+	id TEXT PRIMARY KEY,
+	symbol TEXT NOT NULL,
+	type TEXT NOT NULL,
+	date INTEGER NOT NULL,
+	quantity REAL NOT NULL DEFAULT 0,
+	price REAL NOT NULL DEFAULT 0,
+	fees REAL NOT NULL DEFAULT 0,
+	amount REAL NOT NULL DEFAULT 0,
+	ratio REAL NOT NULL DEFAULT 0,
+	note TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX trades_symbol_date ON trades (symbol, date);
+`
+
+const sqliteTradeImportSchema = `
+ALTER TABLE trades ADD COLUMN broker TEXT NOT NULL DEFAULT '';
+ALTER TABLE trades ADD COLUMN transaction_id TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX trades_transaction ON trades (broker, transaction_id) WHERE transaction_id != '';
+`
+
+// lots holds the comma separated ids of the buys a sell disposes of
+const sqliteTradeLotsSchema = `
+ALTER TABLE trades ADD COLUMN lots TEXT NOT NULL DEFAULT '';
+`
+
+const sqliteSignalSchema = `
+CREATE TABLE signals (
+	id TEXT PRIMARY KEY,
+	symbol TEXT NOT NULL,
+	date INTEGER NOT NULL,
+	ha_open REAL NOT NULL,
+	ha_high REAL NOT NULL,
+	ha_low REAL NOT NULL,
+	ha_close REAL NOT NULL,
+	uptrend INTEGER NOT NULL,
+	bull INTEGER NOT NULL,
+	bear INTEGER NOT NULL,
+	spinning_top INTEGER NOT NULL,
+	doji INTEGER NOT NULL,
+	gravestone INTEGER NOT NULL,
+	sentiment TEXT NOT NULL,
+	rule_version INTEGER NOT NULL,
+	evaluated_at INTEGER NOT NULL
+);
+CREATE INDEX signals_symbol_date ON signals (symbol, date);
+`
+
+const priceColumns = "id, symbol, date, open, high, low, close, volume, after_hours, pre_market, ha_open, ha_close, ha_high, ha_low"
+
+// SqliteStore keeps symbols and prices in a single sqlite file.
+type SqliteStore struct {
+	DB *sql.DB
+}
+
+func InitSqliteStore(ctx context.Context, path string) (*SqliteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// a single writer avoids SQLITE_BUSY between our own goroutines
+	db.SetMaxOpenConns(1)
+
+	_, err = db.ExecContext(ctx, sqliteMigrationsTable)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SqliteStore{DB: db}, nil
+}
+
+type sqliteExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// addSqliteHeikinAshiColumns adds ha_high and ha_low to price tables created
+// before them, the backfill migration rebuilds their candles.
+func addSqliteHeikinAshiColumns(ctx context.Context, db sqliteExecer) error {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_info('price')")
+	if err != nil {
+		return err
+	}
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		columns[name] = true
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	for _, column := range []string{"ha_high", "ha_low"} {
+		if columns[column] {
+			continue
+		}
+		_, err := db.ExecContext(ctx, "ALTER TABLE price ADD COLUMN "+column+" REAL NOT NULL DEFAULT 0")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SqliteStore) Close() error {
+	return s.DB.Close()
+}
+
+// sqliteMigration changes the schema inside the transaction recording it, so
+// it is applied exactly once.
+type sqliteMigration struct {
+	Name string
+	Up   func(ctx context.Context, tx *sql.Tx) error
+}
+
+// sqliteMigrations are never reordered or removed, new ones go at the end.
+var sqliteMigrations = []sqliteMigration{
+	{
+		Name: "create symbol and price tables",
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, sqliteSchema)
+			return err
+		},
+	},
+	{
+		// price tables created before ha_high and ha_low are left alone by
+		// CREATE TABLE IF NOT EXISTS
+		Name: "add heikin ashi high/low columns",
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			return addSqliteHeikinAshiColumns(ctx, tx)
+		},
+	},
+	{
+		Name: "backfill heikin ashi candles",
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			rows, err := tx.QueryContext(ctx, "SELECT DISTINCT symbol FROM price WHERE ha_open = 0 AND ha_close = 0")
+			if err != nil {
+				return err
+			}
+			symbols := []string{}
+			for rows.Next() {
+				var symbol string
+				err := rows.Scan(&symbol)
+				if err != nil {
+					rows.Close()
+					return err
+				}
+				symbols = append(symbols, symbol)
+			}
+			rows.Close()
+			if rows.Err() != nil {
+				return rows.Err()
+			}
+
+			for _, symbol := range symbols {
+				_, err := updateSqliteHeikinAshi(ctx, tx, symbol, epoch)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Name: "archive and alias symbols",
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, sqliteSymbolLifecycleSchema)
+			return err
+		},
+	},
+	{
+		Name: "tag symbols and add watchlists",
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, sqliteWatchlistSchema)
+			return err
+		},
+	},
+	{
+		Name: "create trades table",
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, sqliteTradeSchema)
+			return err
+		},
+	},
+	{
+		Name: "dedupe imported trades",
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, sqliteTradeImportSchema)
+			return err
+		},
+	},
+	{
+		Name: "record sold lots",
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, sqliteTradeLotsSchema)
+			return err
+		},
+	},
+	{
+		Name: "create signals table",
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, sqliteSignalSchema)
+			return err
+		},
+	},
+}
+
+func (s *SqliteStore) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT version, name, applied_at FROM migrations ORDER BY version")
+	if err != nil {
+		fmt.Println("failed to get migrations")
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := []migrationRecord{}
+	for rows.Next() {
+		r := migrationRecord{}
+		var appliedAt int64
+		err := rows.Scan(&r.Version, &r.Name, &appliedAt)
+		if err != nil {
+			fmt.Println("faile to decode migrations")
+			return nil, err
+		}
+		r.AppliedAt = primitive.DateTime(appliedAt)
+		applied = append(applied, r)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	names := make([]string, 0, len(sqliteMigrations))
+	for _, m := range sqliteMigrations {
+		names = append(names, m.Name)
+	}
+
+	return migrationStatus(names, applied), nil
+}
+
+func (s *SqliteStore) MigrateUp(ctx context.Context) ([]MigrationStatus, error) {
+	statuses, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotTooNew(statuses)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []MigrationStatus{}
+	for i, m := range sqliteMigrations {
+		if !statuses[i].AppliedAt.IsZero() {
+			continue
+		}
+
+		status := MigrationStatus{Version: i + 1, Name: m.Name, AppliedAt: time.Now()}
+		err := s.withTx(ctx, func(tx *sql.Tx) error {
+			err := m.Up(ctx, tx)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, "INSERT INTO migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				status.Version, status.Name, int64(primitive.NewDateTimeFromTime(status.AppliedAt)))
+			return err
+		})
+		if err != nil {
+			fmt.Printf("failed to apply migration %d\n", status.Version)
+			return applied, err
+		}
+		applied = append(applied, status)
+	}
+
+	return applied, nil
+}
+
+func (s *SqliteStore) GetAllSymbols(ctx context.Context) ([]models.Symbol, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+symbolColumns+" FROM symbol ORDER BY symbol")
+	if err != nil {
+		fmt.Println("failed to get all symbols")
+		return nil, err
+	}
+	defer rows.Close()
+
+	symbols := []models.Symbol{}
+	for rows.Next() {
+		symbol, err := scanSymbol(rows)
+		if err != nil {
+			fmt.Println("faile to decode all symbols")
+			return nil, err
+		}
+		symbols = append(symbols, symbol)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	rows.Close()
+
+	err = loadSqliteSymbolLists(ctx, s.DB, symbols)
+	if err != nil {
+		return nil, err
+	}
+	return symbols, nil
+}
+
+func (s *SqliteStore) GetStockPrices(ctx context.Context, opt *GetStockPriceOpt) ([]models.Price, error) {
+	err := opt.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := opt.symbols()
+	args := []any{}
+	for _, symbol := range symbols {
+		args = append(args, symbol)
+	}
+	args = append(args, int64(primitive.NewDateTimeFromTime(opt.From)), int64(primitive.NewDateTimeFromTime(opt.To)))
+
+	order := "DESC"
+	cmp := "<"
+	if opt.Order == ASCENDING {
+		order = "ASC"
+		cmp = ">"
+	}
+
+	query := "SELECT " + projectedColumns(opt.Fields) + " FROM price WHERE symbol IN (?" + strings.Repeat(", ?", len(symbols)-1) + ") AND date >= ? AND date <= ?"
+	if opt.After != nil {
+		query += " AND (symbol > ? OR (symbol = ? AND date " + cmp + " ?))"
+		args = append(args, opt.After.Symbol, opt.After.Symbol, int64(opt.After.Date))
+	}
+	query += " ORDER BY symbol, date " + order + " LIMIT ?"
+	args = append(args, opt.Limit)
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		fmt.Println("failed to get price for symbol")
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPrices(rows, opt.Fields)
+}
+
+func (s *SqliteStore) GetLatestStockPrices(ctx context.Context, symbols []string, n int64) (map[string][]models.Price, error) {
+	latest := map[string][]models.Price{}
+	if len(symbols) == 0 {
+		return latest, nil
+	}
+
+	args := []any{}
+	for _, symbol := range symbols {
+		args = append(args, symbol)
+	}
+	args = append(args, int64(primitive.NewDateTimeFromTime(time.Now())), n)
+
+	rows, err := s.DB.QueryContext(ctx,
+		"SELECT "+priceColumns+" FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY symbol ORDER BY date DESC) AS n FROM price WHERE symbol IN (?"+strings.Repeat(", ?", len(symbols)-1)+") AND date <= ?) WHERE n <= ? ORDER BY symbol, date DESC",
+		args...)
+	if err != nil {
+		fmt.Println("failed to get latest prices")
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices, err := scanPrices(rows, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range prices {
+		latest[p.Symbol] = append(latest[p.Symbol], p)
+	}
+
+	return latest, nil
+}
+
+func (s *SqliteStore) InsertStockPrice(stock models.StockData, ctx context.Context) (*models.Price, error) {
+	p, err := stockDataToPrice(stock.Symbol, stock)
+	if err != nil {
+		fmt.Println("Failed to parse stonkDate")
+		return &p, err
+	}
+
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		symbol, err := findOrCreateSqliteSymbol(ctx, tx, stock.Symbol, p.Date.Time())
+		if err != nil {
+			return err
+		}
+
+		p.Id = primitive.NewObjectID()
+		res, err := tx.ExecContext(ctx, "INSERT INTO price ("+priceColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (symbol, date) DO NOTHING", priceArgs(p)...)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrDuplicatePrice
+		}
+
+		_, err = updateSqliteHeikinAshi(ctx, tx, stock.Symbol, p.Date.Time())
+		if err != nil {
+			return err
+		}
+
+		if p.Date.Time().After(symbol.LastFetchedDate.Time()) {
+			return updateSqliteLastFetchedDate(ctx, tx, stock.Symbol, p.Date)
+		}
+		return nil
+	})
+
+	return &p, err
+}
+
+func (s *SqliteStore) InsertSymbolStockPrices(stocks []models.StockData, symbol string, ctx context.Context) (*UpsertResult, error) {
+	result := &UpsertResult{}
+	if len(stocks) == 0 {
+		return result, errors.New("no stocks found")
+	}
+
+	prices := make([]models.Price, 0, len(stocks))
+	for _, stonk := range stocks {
+		p, err := stockDataToPrice(symbol, stonk)
+		if err != nil {
+			fmt.Println("Failed to parse stonkDate")
+			return result, err
+		}
+		prices = append(prices, p)
+	}
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		sym, err := findOrCreateSqliteSymbol(ctx, tx, symbol, epoch)
+		if err != nil {
+			return err
+		}
+		latestDate := sym.LastFetchedDate
+		var changedFrom primitive.DateTime
+
+		for _, p := range prices {
+			stored := models.Price{}
+			err := tx.QueryRowContext(ctx, "SELECT open, high, low, close, volume FROM price WHERE symbol = ? AND date = ?", symbol, int64(p.Date)).
+				Scan(&stored.Open, &stored.High, &stored.Low, &stored.Close, &stored.Volume)
+
+			switch {
+			case err == sql.ErrNoRows:
+				p.Id = primitive.NewObjectID()
+				_, err = tx.ExecContext(ctx, "INSERT INTO price ("+priceColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", priceArgs(p)...)
+				result.Inserted++
+			case err != nil:
+			case stored.Open != p.Open || stored.High != p.High || stored.Low != p.Low || stored.Close != p.Close || stored.Volume != p.Volume:
+				_, err = tx.ExecContext(ctx, "UPDATE price SET open = ?, high = ?, low = ?, close = ?, volume = ? WHERE symbol = ? AND date = ?",
+					p.Open, p.High, p.Low, p.Close, p.Volume, symbol, int64(p.Date))
+				result.Updated++
+			default:
+				result.Unchanged++
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if changedFrom == 0 || p.Date < changedFrom {
+				changedFrom = p.Date
+			}
+			if p.Date > latestDate {
+				latestDate = p.Date
+			}
+		}
+
+		if changedFrom != 0 {
+			_, err = updateSqliteHeikinAshi(ctx, tx, symbol, changedFrom.Time())
+			if err != nil {
+				return err
+			}
+		}
+
+		if latestDate > sym.LastFetchedDate {
+			return updateSqliteLastFetchedDate(ctx, tx, symbol, latestDate)
+		}
+		return nil
+	})
+
+	if err != nil {
+		fmt.Println("failed to upsert prices")
+		return &UpsertResult{}, err
+	}
+
+	return result, nil
+}
+
+func (s *SqliteStore) ImportStockPrices(ctx context.Context, symbol string, prices []models.Price) (*ImportResult, error) {
+	result := &ImportResult{}
+	if len(prices) == 0 {
+		return result, nil
+	}
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		sym, err := findOrCreateSqliteSymbol(ctx, tx, symbol, epoch)
+		if err != nil {
+			return err
+		}
+		latestDate := sym.LastFetchedDate
+		var changedFrom primitive.DateTime
+
+		for _, p := range prices {
+			p.Symbol = symbol
+			p.Id = primitive.NewObjectID()
+			res, err := tx.ExecContext(ctx, "INSERT INTO price ("+priceColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (symbol, date) DO NOTHING", priceArgs(p)...)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				result.Duplicates++
+			} else {
+				result.Inserted++
+				if changedFrom == 0 || p.Date < changedFrom {
+					changedFrom = p.Date
+				}
+			}
+			if p.Date > latestDate {
+				latestDate = p.Date
+			}
+		}
+
+		if changedFrom != 0 {
+			_, err = updateSqliteHeikinAshi(ctx, tx, symbol, changedFrom.Time())
+			if err != nil {
+				return err
+			}
+		}
+
+		if latestDate > sym.LastFetchedDate {
+			return updateSqliteLastFetchedDate(ctx, tx, symbol, latestDate)
+		}
+		return nil
+	})
+
+	if err != nil {
+		fmt.Println("failed to import prices")
+		return &ImportResult{}, err
+	}
+
+	return result, nil
+}
+
+func (s *SqliteStore) UpdateHeikinAshi(ctx context.Context, symbol string, from time.Time) (int, error) {
+	updated := 0
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		updated, err = updateSqliteHeikinAshi(ctx, tx, symbol, from)
+		return err
+	})
+
+	if err != nil {
+		fmt.Println("failed to update heikin ashi")
+		return 0, err
+	}
+
+	return updated, nil
+}
+
+func (s *SqliteStore) RepairLastFetchedDates(ctx context.Context, dryRun bool) ([]SymbolRepair, error) {
+	repairs := []SymbolRepair{}
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `SELECT p.symbol, MAX(p.date), s.last_fetched_date FROM price p
+			LEFT JOIN symbol s ON s.symbol = p.symbol
+			GROUP BY p.symbol
+			HAVING s.last_fetched_date IS NULL OR s.last_fetched_date != MAX(p.date)
+			ORDER BY p.symbol`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var latestDate int64
+			var lastFetchedDate sql.NullInt64
+			r := SymbolRepair{}
+			err := rows.Scan(&r.Symbol, &latestDate, &lastFetchedDate)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			r.To = primitive.DateTime(latestDate).Time()
+			r.From = primitive.DateTime(lastFetchedDate.Int64).Time()
+			r.Created = !lastFetchedDate.Valid
+			if r.Created {
+				r.From = time.Time{}
+			}
+			repairs = append(repairs, r)
+		}
+		rows.Close()
+		if rows.Err() != nil || dryRun {
+			return rows.Err()
+		}
+
+		for _, r := range repairs {
+			_, err := findOrCreateSqliteSymbol(ctx, tx, r.Symbol, r.To)
+			if err != nil {
+				return err
+			}
+			err = updateSqliteLastFetchedDate(ctx, tx, r.Symbol, primitive.NewDateTimeFromTime(r.To))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		fmt.Println("failed to repair lastFetchedDate")
+		return nil, err
+	}
+
+	return repairs, nil
+}
+
+func (s *SqliteStore) ResolveSymbol(ctx context.Context, symbol string) (models.Symbol, error) {
+	sym := models.Symbol{}
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		sym, err = resolveSqliteSymbol(ctx, tx, symbol)
+		return err
+	})
+	return sym, err
+}
+
+func (s *SqliteStore) RemoveSymbol(ctx context.Context, symbol string) (int, error) {
+	removed := 0
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, table := range []string{"symbol_tag", "watchlist_symbol"} {
+			_, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE symbol_id = (SELECT id FROM symbol WHERE symbol = ?)", symbol)
+			if err != nil {
+				return err
+			}
+		}
+
+		res, err := tx.ExecContext(ctx, "DELETE FROM symbol WHERE symbol = ?", symbol)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrUnknownSymbol
+		}
+
+		res, err = tx.ExecContext(ctx, "DELETE FROM price WHERE symbol = ?", symbol)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		removed = int(n)
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM signals WHERE symbol = ?", symbol)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM symbol_alias WHERE symbol = ?", symbol)
+		return err
+	})
+
+	if err != nil {
+		fmt.Println("failed to remove symbol")
+		return 0, err
+	}
+	return removed, nil
+}
+
+func (s *SqliteStore) ArchiveSymbol(ctx context.Context, symbol string, archived bool) error {
+	res, err := s.DB.ExecContext(ctx, "UPDATE symbol SET archived = ? WHERE symbol = ?", archived, symbol)
+	if err != nil {
+		fmt.Println("failed to archive symbol")
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUnknownSymbol
+	}
+	return nil
+}
+
+func (s *SqliteStore) RenameSymbol(ctx context.Context, symbol string, to string) (int, error) {
+	moved := 0
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		sym, err := scanSymbol(tx.QueryRowContext(ctx, "SELECT "+symbolColumns+" FROM symbol WHERE symbol = ?", symbol))
+		if err == sql.ErrNoRows {
+			return ErrUnknownSymbol
+		}
+		if err != nil {
+			return err
+		}
+
+		other, err := resolveSqliteSymbol(ctx, tx, to)
+		if err == nil && other.Id != sym.Id {
+			return ErrSymbolExists
+		}
+		if err != nil && err != ErrUnknownSymbol {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, "UPDATE price SET symbol = ? WHERE symbol = ?", to, symbol)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		moved = int(n)
+
+		_, err = tx.ExecContext(ctx, "UPDATE trades SET symbol = ? WHERE symbol = ?", to, symbol)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE signals SET symbol = ? WHERE symbol = ?", to, symbol)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE symbol SET symbol = ? WHERE symbol = ?", to, symbol)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM symbol_alias WHERE alias = ?", to)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE symbol_alias SET symbol = ? WHERE symbol = ?", to, symbol)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO symbol_alias (alias, symbol) VALUES (?, ?)", symbol, to)
+		return err
+	})
+
+	if err != nil {
+		fmt.Println("failed to rename symbol")
+		return 0, err
+	}
+	return moved, nil
+}
+
+func (s *SqliteStore) SetSymbolTag(ctx context.Context, symbol string, tag string, tagged bool) error {
+	query := "INSERT OR IGNORE INTO symbol_tag (symbol_id, tag) SELECT id, ? FROM symbol WHERE symbol = ?"
+	if !tagged {
+		query = "DELETE FROM symbol_tag WHERE tag = ? AND symbol_id = (SELECT id FROM symbol WHERE symbol = ?)"
+	}
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		err := sqliteSymbolExists(ctx, tx, symbol)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, query, tag, symbol)
+		return err
+	})
+	if err != nil {
+		fmt.Println("failed to tag symbol")
+	}
+	return err
+}
+
+func (s *SqliteStore) GetWatchlists(ctx context.Context) ([]models.Watchlist, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, name, created_at FROM watchlist ORDER BY name")
+	if err != nil {
+		fmt.Println("failed to get watchlists")
+		return nil, err
+	}
+	defer rows.Close()
+
+	watchlists := []models.Watchlist{}
+	for rows.Next() {
+		w := models.Watchlist{}
+		var id string
+		var createdAt int64
+		err := rows.Scan(&id, &w.Name, &createdAt)
+		if err != nil {
+			fmt.Println("faile to decode watchlists")
+			return nil, err
+		}
+		w.Id, err = primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, err
+		}
+		w.CreatedAt = primitive.DateTime(createdAt)
+		watchlists = append(watchlists, w)
+	}
+
+	return watchlists, rows.Err()
+}
+
+func (s *SqliteStore) CreateWatchlist(ctx context.Context, name string) error {
+	res, err := s.DB.ExecContext(ctx, "INSERT OR IGNORE INTO watchlist (id, name, created_at) VALUES (?, ?, ?)",
+		primitive.NewObjectID().Hex(), name, int64(primitive.NewDateTimeFromTime(time.Now())))
+	if err != nil {
+		fmt.Println("failed to create watchlist")
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrWatchlistExists
+	}
+	return nil
+}
+
+func (s *SqliteStore) DeleteWatchlist(ctx context.Context, name string) error {
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM watchlist WHERE name = ?", name)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrUnknownWatchlist
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM watchlist_symbol WHERE watchlist = ?", name)
+		return err
+	})
+	if err != nil {
+		fmt.Println("failed to delete watchlist")
+	}
+	return err
+}
+
+func (s *SqliteStore) SetWatchlistMember(ctx context.Context, watchlist string, symbol string, member bool) error {
+	query := "INSERT OR IGNORE INTO watchlist_symbol (watchlist, symbol_id) SELECT ?, id FROM symbol WHERE symbol = ?"
+	if !member {
+		query = "DELETE FROM watchlist_symbol WHERE watchlist = ? AND symbol_id = (SELECT id FROM symbol WHERE symbol = ?)"
+	}
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM watchlist WHERE name = ?)", watchlist).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUnknownWatchlist
+		}
+
+		err = sqliteSymbolExists(ctx, tx, symbol)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, query, watchlist, symbol)
+		return err
+	})
+	if err != nil {
+		fmt.Println("failed to update watchlist")
+	}
+	return err
+}
+
+const tradeColumns = "id, symbol, type, date, quantity, price, fees, amount, ratio, note, broker, transaction_id, lots"
+
+func tradeArgs(t models.Trade) []any {
+	lots := make([]string, 0, len(t.Lots))
+	for _, id := range t.Lots {
+		lots = append(lots, id.Hex())
+	}
+	return []any{t.Id.Hex(), t.Symbol, t.Type, int64(t.Date), t.Quantity, t.Price, t.Fees, t.Amount, t.Ratio, t.Note, t.Broker, t.TransactionId, strings.Join(lots, ",")}
+}
+
+const insertTrade = "INSERT INTO trades (" + tradeColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+func (s *SqliteStore) InsertTrade(ctx context.Context, trade models.Trade) (models.Trade, error) {
+	trade.Id = primitive.NewObjectID()
+	_, err := s.DB.ExecContext(ctx, insertTrade, tradeArgs(trade)...)
+	if err != nil {
+		fmt.Println("failed to insert trade")
+		return trade, err
+	}
+	return trade, nil
+}
+
+func (s *SqliteStore) ImportTrades(ctx context.Context, trades []models.Trade) (*ImportResult, error) {
+	result := &ImportResult{}
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, trade := range trades {
+			trade.Id = primitive.NewObjectID()
+			res, err := tx.ExecContext(ctx, insertTrade+" ON CONFLICT DO NOTHING", tradeArgs(trade)...)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				result.Duplicates++
+			} else {
+				result.Inserted++
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		fmt.Println("failed to import trades")
+		return &ImportResult{}, err
+	}
+	return result, nil
+}
+
+func (s *SqliteStore) CreateSymbol(ctx context.Context, symbol string, lastFetchedDate time.Time) (models.Symbol, error) {
+	sym := models.Symbol{
+		Id:              primitive.NewObjectID(),
+		Symbol:          symbol,
+		LastFetchedDate: primitive.NewDateTimeFromTime(lastFetchedDate),
+	}
+	res, err := s.DB.ExecContext(ctx, "INSERT INTO symbol (id, symbol, last_fetched_date) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		sym.Id.Hex(), sym.Symbol, int64(sym.LastFetchedDate))
+	if err != nil {
+		fmt.Println("Failed to insert symbol")
+		return sym, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Symbol{}, ErrSymbolExists
+	}
+	return sym, nil
+}
+
+func (s *SqliteStore) GetTrades(ctx context.Context, symbols []string) ([]models.Trade, error) {
+	query := "SELECT " + tradeColumns + " FROM trades"
+	args := []any{}
+	if len(symbols) > 0 {
+		query += " WHERE symbol IN (?" + strings.Repeat(", ?", len(symbols)-1) + ")"
+		for _, symbol := range symbols {
+			args = append(args, symbol)
+		}
+	}
+	query += " ORDER BY date, rowid"
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		fmt.Println("failed to get trades")
+		return nil, err
+	}
+	defer rows.Close()
+
+	trades := []models.Trade{}
+	for rows.Next() {
+		t := models.Trade{}
+		var id, lots string
+		var date int64
+		err := rows.Scan(&id, &t.Symbol, &t.Type, &date, &t.Quantity, &t.Price, &t.Fees, &t.Amount, &t.Ratio, &t.Note, &t.Broker, &t.TransactionId, &lots)
+		if err != nil {
+			fmt.Println("faile to decode trades")
+			return nil, err
+		}
+		t.Id, err = primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, err
+		}
+		if lots != "" {
+			for _, lot := range strings.Split(lots, ",") {
+				lotId, err := primitive.ObjectIDFromHex(lot)
+				if err != nil {
+					return nil, err
+				}
+				t.Lots = append(t.Lots, lotId)
+			}
+		}
+		t.Date = primitive.DateTime(date)
+		trades = append(trades, t)
+	}
+
+	return trades, rows.Err()
+}
+
+func (s *SqliteStore) DeleteTrade(ctx context.Context, id primitive.ObjectID) error {
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM trades WHERE id = ?", id.Hex())
+	if err != nil {
+		fmt.Println("failed to delete trade")
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUnknownTrade
+	}
+	return nil
+}
+
+func (s *SqliteStore) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func findOrCreateSqliteSymbol(ctx context.Context, tx *sql.Tx, symbol string, lastFetchedDate time.Time) (models.Symbol, error) {
+	s, err := scanSymbol(tx.QueryRowContext(ctx, "SELECT "+symbolColumns+" FROM symbol WHERE symbol = ?", symbol))
+	if err != sql.ErrNoRows {
+		return s, err
+	}
+
+	s = models.Symbol{
+		Id:              primitive.NewObjectID(),
+		Symbol:          symbol,
+		LastFetchedDate: primitive.NewDateTimeFromTime(lastFetchedDate),
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO symbol (id, symbol, last_fetched_date) VALUES (?, ?, ?)", s.Id.Hex(), s.Symbol, int64(s.LastFetchedDate))
+	if err != nil {
+		fmt.Println("Failed to insert symbol")
+	}
+	return s, err
+}
+
+func resolveSqliteSymbol(ctx context.Context, tx *sql.Tx, symbol string) (models.Symbol, error) {
+	s, err := scanSymbol(tx.QueryRowContext(ctx, "SELECT "+symbolColumns+" FROM symbol WHERE symbol = ? OR symbol = (SELECT symbol FROM symbol_alias WHERE alias = ?)", symbol, symbol))
+	if err == sql.ErrNoRows {
+		return s, ErrUnknownSymbol
+	}
+	if err != nil {
+		return s, err
+	}
+
+	symbols := []models.Symbol{s}
+	err = loadSqliteSymbolLists(ctx, tx, symbols)
+	return symbols[0], err
+}
+
+func sqliteSymbolExists(ctx context.Context, tx *sql.Tx, symbol string) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM symbol WHERE symbol = ?)", symbol).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUnknownSymbol
+	}
+	return nil
+}
+
+func updateSqliteLastFetchedDate(ctx context.Context, tx *sql.Tx, symbol string, date primitive.DateTime) error {
+	_, err := tx.ExecContext(ctx, "UPDATE symbol SET last_fetched_date = ? WHERE symbol = ?", int64(date), symbol)
+	if err != nil {
+		fmt.Println("failed to update symbol lastFetch")
+	}
+	return err
+}
+
+func updateSqliteHeikinAshi(ctx context.Context, tx *sql.Tx, symbol string, from time.Time) (int, error) {
+	fromDate := int64(primitive.NewDateTimeFromTime(from))
+
+	rows, err := tx.QueryContext(ctx, "SELECT "+priceColumns+" FROM price WHERE symbol = ? AND date < ? ORDER BY date DESC LIMIT 1", symbol, fromDate)
+	if err != nil {
+		return 0, err
+	}
+	before, err := scanPrices(rows, nil)
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	var prev *models.Price
+	if len(before) == 1 {
+		prev = &before[0]
+		if !hasHeikinAshi(prev) {
+			prev = nil
+			fromDate = int64(primitive.NewDateTimeFromTime(epoch))
+		}
+	}
+
+	rows, err = tx.QueryContext(ctx, "SELECT "+priceColumns+" FROM price WHERE symbol = ? AND date >= ? ORDER BY date", symbol, fromDate)
+	if err != nil {
+		return 0, err
+	}
+	prices, err := scanPrices(rows, nil)
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	chainHeikinAshi(prev, prices)
+	for _, p := range prices {
+		_, err := tx.ExecContext(ctx, "UPDATE price SET ha_open = ?, ha_close = ?, ha_high = ?, ha_low = ? WHERE id = ?",
+			p.HAOpen, p.HAClose, p.HAHigh, p.HALow, p.Id.Hex())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(prices), nil
+}
+
+// querier is a *sql.DB or a *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// loadSqliteSymbolLists sets the aliases, tags and watchlists of symbols.
+func loadSqliteSymbolLists(ctx context.Context, db querier, symbols []models.Symbol) error {
+	// aliases are keyed by ticker, tags and watchlists by symbol id
+	aliases, err := loadSqliteList(ctx, db, "SELECT symbol, alias FROM symbol_alias ORDER BY rowid")
+	if err != nil {
+		fmt.Println("failed to get symbol aliases")
+		return err
+	}
+	tags, err := loadSqliteList(ctx, db, "SELECT symbol_id, tag FROM symbol_tag ORDER BY tag")
+	if err != nil {
+		fmt.Println("failed to get symbol tags")
+		return err
+	}
+	watchlists, err := loadSqliteList(ctx, db, "SELECT symbol_id, watchlist FROM watchlist_symbol ORDER BY watchlist")
+	if err != nil {
+		fmt.Println("failed to get symbol watchlists")
+		return err
+	}
+
+	for i := range symbols {
+		symbols[i].Aliases = aliases[symbols[i].Symbol]
+		symbols[i].Tags = tags[symbols[i].Id.Hex()]
+		symbols[i].Watchlists = watchlists[symbols[i].Id.Hex()]
+	}
+	return nil
+}
+
+// loadSqliteList groups the second column of query by the first one.
+func loadSqliteList(ctx context.Context, db querier, query string) (map[string][]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := map[string][]string{}
+	for rows.Next() {
+		var key, value string
+		err := rows.Scan(&key, &value)
+		if err != nil {
+			return nil, err
+		}
+		lists[key] = append(lists[key], value)
+	}
+	return lists, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+const symbolColumns = "id, symbol, last_fetched_date, archived"
+
+func scanSymbol(row scanner) (models.Symbol, error) {
+	s := models.Symbol{}
+	var id string
+	var lastFetchedDate int64
+
+	err := row.Scan(&id, &s.Symbol, &lastFetchedDate, &s.Archived)
+	if err != nil {
+		return s, err
+	}
+
+	s.Id, err = primitive.ObjectIDFromHex(id)
+	s.LastFetchedDate = primitive.DateTime(lastFetchedDate)
+	return s, err
+}
+
+// sqlitePriceFields are the fields of priceColumns after id, symbol and date
+var sqlitePriceFields = []string{"open", "high", "low", "close", "volume", "afterHours", "preMarket", "haOpen", "haClose", "haHigh", "haLow"}
+
+// projectedColumns returns the columns to select for the projected fields,
+// every column when fields is empty.
+func projectedColumns(fields []string) string {
+	if len(fields) == 0 {
+		return priceColumns
+	}
+
+	columns := "id, symbol, date"
+	for _, f := range fields {
+		if column, ok := priceFieldColumns[f]; ok {
+			columns += ", " + column
+		}
+	}
+	return columns
+}
+
+// scanPrices decodes rows selected with projectedColumns(fields).
+func scanPrices(rows *sql.Rows, fields []string) ([]models.Price, error) {
+	if len(fields) == 0 {
+		fields = sqlitePriceFields
+	}
+
+	prices := []models.Price{}
+	for rows.Next() {
+		p := models.Price{}
+		var id string
+		var date int64
+
+		dest := []any{&id, &p.Symbol, &date}
+		for _, f := range fields {
+			if field := priceField(&p, f); field != nil {
+				dest = append(dest, field)
+			}
+		}
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			fmt.Println("faile to decode all prices")
+			return nil, err
+		}
+		p.Id, err = primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, err
+		}
+		p.Date = primitive.DateTime(date)
+		prices = append(prices, p)
+	}
+
+	return prices, rows.Err()
+}
+
+func priceArgs(p models.Price) []any {
+	return []any{p.Id.Hex(), p.Symbol, int64(p.Date), p.Open, p.High, p.Low, p.Close, p.Volume, p.AfterHours, p.PreMarket, p.HAOpen, p.HAClose, p.HAHigh, p.HALow}
+}
+
+const signalColumns = "id, symbol, date, ha_open, ha_high, ha_low, ha_close, uptrend, bull, bear, spinning_top, doji, gravestone, sentiment, rule_version, evaluated_at"
+
+func (s *SqliteStore) InsertSignal(ctx context.Context, signal models.Signal) (models.Signal, error) {
+	signal.Id = primitive.NewObjectID()
+	_, err := s.DB.ExecContext(ctx, "INSERT INTO signals ("+signalColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		signal.Id.Hex(), signal.Symbol, int64(signal.Date), signal.HAOpen, signal.HAHigh, signal.HALow, signal.HAClose,
+		signal.Uptrend, signal.Bull, signal.Bear, signal.SpinningTop, signal.Doji, signal.Gravestone,
+		signal.Sentiment, signal.RuleVersion, int64(signal.EvaluatedAt))
+	if err != nil {
+		fmt.Println("failed to insert signal")
+		return signal, err
+	}
+	return signal, nil
+}
+
+func (s *SqliteStore) GetSignals(ctx context.Context, opt *GetSignalOpt) ([]models.Signal, error) {
+	query := "SELECT " + signalColumns + " FROM signals WHERE symbol = ?"
+	args := []any{opt.Symbol}
+	if !opt.From.IsZero() {
+		query += " AND date >= ?"
+		args = append(args, int64(primitive.NewDateTimeFromTime(opt.From)))
+	}
+	if !opt.To.IsZero() {
+		query += " AND date <= ?"
+		args = append(args, int64(primitive.NewDateTimeFromTime(opt.To)))
+	}
+	query += " ORDER BY date, rowid"
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		fmt.Println("failed to get signals")
+		return nil, err
+	}
+	defer rows.Close()
+
+	signals := []models.Signal{}
+	for rows.Next() {
+		sig := models.Signal{}
+		var id string
+		var date, evaluatedAt int64
+		err := rows.Scan(&id, &sig.Symbol, &date, &sig.HAOpen, &sig.HAHigh, &sig.HALow, &sig.HAClose,
+			&sig.Uptrend, &sig.Bull, &sig.Bear, &sig.SpinningTop, &sig.Doji, &sig.Gravestone,
+			&sig.Sentiment, &sig.RuleVersion, &evaluatedAt)
+		if err != nil {
+			fmt.Println("faile to decode signals")
+			return nil, err
+		}
+		sig.Id, err = primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, err
+		}
+		sig.Date = primitive.DateTime(date)
+		sig.EvaluatedAt = primitive.DateTime(evaluatedAt)
+		signals = append(signals, sig)
+	}
+
+	return signals, rows.Err()
+}
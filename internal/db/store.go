@@ -0,0 +1,376 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/calculation"
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	MONGO  = "mongo"
+	SQLITE = "sqlite"
+	MEMORY = "memory"
+)
+
+// Store is everything the commands need from persistence. Query is the mongo
+// implementation, SqliteStore keeps everything in a single file and
+// MemoryStore lives and dies with the process.
+type Store interface {
+	GetAllSymbols(ctx context.Context) ([]models.Symbol, error)
+	GetStockPrices(ctx context.Context, opt *GetStockPriceOpt) ([]models.Price, error)
+	// GetLatestStockPrices returns the latest n prices of every symbol, newest
+	// first, in a single query. Symbols without prices are left out.
+	GetLatestStockPrices(ctx context.Context, symbols []string, n int64) (map[string][]models.Price, error)
+	InsertStockPrice(stock models.StockData, ctx context.Context) (*models.Price, error)
+	InsertSymbolStockPrices(stocks []models.StockData, symbol string, ctx context.Context) (*UpsertResult, error)
+	ImportStockPrices(ctx context.Context, symbol string, prices []models.Price) (*ImportResult, error)
+	// UpdateHeikinAshi recomputes the stored heikin ashi candles of symbol from
+	// the date from onwards and returns how many prices were updated.
+	UpdateHeikinAshi(ctx context.Context, symbol string, from time.Time) (int, error)
+	// RepairLastFetchedDates moves the lastFetchedDate of every symbol to its
+	// latest stored price, creating symbols missing for stored prices. Nothing
+	// is written when dryRun is set. It returns the symbols that needed it.
+	RepairLastFetchedDates(ctx context.Context, dryRun bool) ([]SymbolRepair, error)
+	// ResolveSymbol returns the current ticker of symbol, which may be one of
+	// its aliases.
+	ResolveSymbol(ctx context.Context, symbol string) (models.Symbol, error)
+	// RemoveSymbol deletes symbol, its prices and signals, returning how many
+	// prices were deleted.
+	RemoveSymbol(ctx context.Context, symbol string) (int, error)
+	ArchiveSymbol(ctx context.Context, symbol string, archived bool) error
+	// RenameSymbol moves symbol, its prices, trades and signals to the ticker
+	// to, keeping symbol as an alias. It returns how many prices were moved.
+	RenameSymbol(ctx context.Context, symbol string, to string) (int, error)
+	// SetSymbolTag adds tag to symbol, or removes it when tagged is false.
+	SetSymbolTag(ctx context.Context, symbol string, tag string, tagged bool) error
+	// GetWatchlists returns every watchlist ordered by name, the symbols in a
+	// watchlist are listed by Symbol.Watchlists.
+	GetWatchlists(ctx context.Context) ([]models.Watchlist, error)
+	CreateWatchlist(ctx context.Context, name string) error
+	// DeleteWatchlist deletes the watchlist and takes its symbols out of it.
+	DeleteWatchlist(ctx context.Context, name string) error
+	// SetWatchlistMember adds symbol to the watchlist, or takes it out when
+	// member is false.
+	SetWatchlistMember(ctx context.Context, watchlist string, symbol string, member bool) error
+	// InsertTrade records trade in the ledger and returns it with its id.
+	InsertTrade(ctx context.Context, trade models.Trade) (models.Trade, error)
+	// GetTrades returns the trades of symbols, every trade when symbols is
+	// empty, oldest first and in recording order on the same date.
+	GetTrades(ctx context.Context, symbols []string) ([]models.Trade, error)
+	DeleteTrade(ctx context.Context, id primitive.ObjectID) error
+	// ImportTrades records the trades whose broker transaction id is not
+	// stored yet, the others count as duplicates.
+	ImportTrades(ctx context.Context, trades []models.Trade) (*ImportResult, error)
+	// CreateSymbol starts tracking symbol, refresh fetches its prices from the
+	// day after lastFetchedDate.
+	CreateSymbol(ctx context.Context, symbol string, lastFetchedDate time.Time) (models.Symbol, error)
+	// InsertSignal records an evaluation of a bar and returns it with its id.
+	InsertSignal(ctx context.Context, signal models.Signal) (models.Signal, error)
+	// GetSignals returns the signals of a symbol by bar date, oldest first and
+	// in evaluation order on the same bar.
+	GetSignals(ctx context.Context, opt *GetSignalOpt) ([]models.Signal, error)
+}
+
+type SortOrder int
+
+const (
+	DESCENDING SortOrder = iota
+	ASCENDING
+)
+
+// maximum number of prices GetStockPrices returns when no Limit is given
+const defaultPriceLimit = 100
+
+var (
+	ErrDuplicatePrice = errors.New("price already stored for symbol and date")
+	ErrUnknownSymbol  = errors.New("unknown symbol")
+	ErrSymbolExists   = errors.New("symbol already exists")
+
+	ErrUnknownWatchlist = errors.New("unknown watchlist")
+	ErrWatchlistExists  = errors.New("watchlist already exists")
+	ErrUnknownTrade     = errors.New("unknown trade")
+)
+
+type GetStockPriceOpt struct {
+	Symbol string
+	// Symbols adds more symbols to the query. Prices of several symbols are
+	// ordered by symbol, then by date.
+	Symbols []string
+	Limit   int64
+	// From and To bound the dates returned, both inclusive. To defaults to now.
+	From  time.Time
+	To    time.Time
+	Order SortOrder
+	// After continues from the last price of a previous page.
+	After *PriceCursor
+	// Fields projects prices onto these bson fields, symbol and date are always
+	// returned. Every field is returned when empty.
+	Fields []string
+}
+
+// PriceCursor marks the position of a price in the results of GetStockPrices.
+type PriceCursor struct {
+	Symbol string
+	Date   primitive.DateTime
+}
+
+// CursorAfter returns the cursor to fetch the page following p.
+func CursorAfter(p models.Price) *PriceCursor {
+	return &PriceCursor{Symbol: p.Symbol, Date: p.Date}
+}
+
+// priceFieldColumns maps the projectable fields of models.Price, by bson name, to
+// their sqlite column.
+var priceFieldColumns = map[string]string{
+	"open":       "open",
+	"high":       "high",
+	"low":        "low",
+	"close":      "close",
+	"volume":     "volume",
+	"afterHours": "after_hours",
+	"preMarket":  "pre_market",
+	"haOpen":     "ha_open",
+	"haClose":    "ha_close",
+	"haHigh":     "ha_high",
+	"haLow":      "ha_low",
+}
+
+// priceField returns the field of p named by its bson name, nil when it cannot
+// be projected.
+func priceField(p *models.Price, name string) *float64 {
+	switch name {
+	case "open":
+		return &p.Open
+	case "high":
+		return &p.High
+	case "low":
+		return &p.Low
+	case "close":
+		return &p.Close
+	case "volume":
+		return &p.Volume
+	case "afterHours":
+		return &p.AfterHours
+	case "preMarket":
+		return &p.PreMarket
+	case "haOpen":
+		return &p.HAOpen
+	case "haClose":
+		return &p.HAClose
+	case "haHigh":
+		return &p.HAHigh
+	case "haLow":
+		return &p.HALow
+	}
+	return nil
+}
+
+// normalize fills the defaults of opt and checks its fields.
+func (opt *GetStockPriceOpt) normalize() error {
+	if opt.Limit == 0 {
+		opt.Limit = defaultPriceLimit
+	}
+	if opt.To.IsZero() {
+		opt.To = time.Now()
+	}
+	if opt.Symbol == "" && len(opt.Symbols) == 0 {
+		return errors.New("no symbol to get prices for")
+	}
+
+	for _, f := range opt.Fields {
+		if f != "symbol" && f != "date" && priceField(&models.Price{}, f) == nil {
+			return fmt.Errorf("unknown price field: %s", f)
+		}
+	}
+	return nil
+}
+
+// symbols returns every symbol of opt, sorted and without duplicates.
+func (opt *GetStockPriceOpt) symbols() []string {
+	symbols := slices.Clone(opt.Symbols)
+	if opt.Symbol != "" {
+		symbols = append(symbols, opt.Symbol)
+	}
+	slices.Sort(symbols)
+	return slices.Compact(symbols)
+}
+
+// before reports whether a comes before b in the results of opt.
+func (opt *GetStockPriceOpt) before(a, b *PriceCursor) bool {
+	if a.Symbol != b.Symbol {
+		return a.Symbol < b.Symbol
+	}
+	if opt.Order == ASCENDING {
+		return a.Date < b.Date
+	}
+	return a.Date > b.Date
+}
+
+// project clears the fields of p that opt does not ask for.
+func (opt *GetStockPriceOpt) project(p models.Price) models.Price {
+	if len(opt.Fields) == 0 {
+		return p
+	}
+
+	projected := models.Price{
+		Id:     p.Id,
+		Symbol: p.Symbol,
+		Date:   p.Date,
+	}
+	for _, f := range opt.Fields {
+		if field := priceField(&projected, f); field != nil {
+			*field = *priceField(&p, f)
+		}
+	}
+	return projected
+}
+
+type GetSignalOpt struct {
+	Symbol string
+	// From and To bound the bar dates returned, both inclusive. Zero values
+	// leave them open.
+	From time.Time
+	To   time.Time
+}
+
+// matches reports whether the bar date of a signal is within opt.
+func (opt *GetSignalOpt) matches(date primitive.DateTime) bool {
+	if !opt.From.IsZero() && date < primitive.NewDateTimeFromTime(opt.From) {
+		return false
+	}
+	return opt.To.IsZero() || date <= primitive.NewDateTimeFromTime(opt.To)
+}
+
+type UpsertResult struct {
+	Inserted int
+	// Updated counts stored dates whose values changed
+	Updated   int
+	Unchanged int
+	Failed    int
+}
+
+type SymbolRepair struct {
+	Symbol string
+	From   time.Time
+	To     time.Time
+	// Created is set when the symbol did not exist
+	Created bool
+}
+
+type ImportResult struct {
+	Inserted   int
+	Duplicates int
+}
+
+// landedWatermark returns the lastFetchedDate after writing prices for dates,
+// where failed holds the indexes of dates that did not land. It never moves
+// past the earliest failed date so the next refresh fetches it again.
+func landedWatermark(lastFetchedDate time.Time, dates []time.Time, failed map[int]bool) time.Time {
+	var firstFailed time.Time
+	for i, d := range dates {
+		if failed[i] && (firstFailed.IsZero() || d.Before(firstFailed)) {
+			firstFailed = d
+		}
+	}
+
+	latestDate := lastFetchedDate
+	for i, d := range dates {
+		if failed[i] || (!firstFailed.IsZero() && !d.Before(firstFailed)) {
+			continue
+		}
+		if d.After(latestDate) {
+			latestDate = d
+		}
+	}
+
+	return latestDate
+}
+
+func stockDataToPrice(symbol string, stonk models.StockData) (models.Price, error) {
+	stonkDate, err := time.Parse("2006-01-02", stonk.From)
+	if err != nil {
+		return models.Price{}, err
+	}
+
+	return models.Price{
+		Symbol: symbol,
+		Date:   primitive.NewDateTimeFromTime(stonkDate),
+		Open:   stonk.Open,
+		Close:  stonk.Close,
+		High:   stonk.High,
+		Low:    stonk.Low,
+		Volume: stonk.Volume,
+	}, nil
+}
+
+// epoch is the lastFetchedDate of a symbol nothing has been fetched for
+var epoch = time.Unix(0, 0).UTC()
+
+// chainHeikinAshi sets the heikin ashi candle of prices, sorted by date,
+// chaining from prev, the stored price right before them. A symbol's first
+// price has no prev and seeds the chain with its own open and close.
+func chainHeikinAshi(prev *models.Price, prices []models.Price) {
+	for i := range prices {
+		price := calculation.PriceCal{
+			Open:  prices[i].Open,
+			Close: prices[i].Close,
+			High:  prices[i].High,
+			Low:   prices[i].Low,
+		}
+		ha := price
+		if prev != nil {
+			ha = calculation.PriceCal{
+				Open:  prev.HAOpen,
+				Close: prev.HAClose,
+			}
+		}
+
+		ha = calculation.GetHeikinAshi(&price, &ha)
+		prices[i].HAOpen = ha.Open
+		prices[i].HAClose = ha.Close
+		prices[i].HAHigh = ha.High
+		prices[i].HALow = ha.Low
+		prev = &prices[i]
+	}
+}
+
+// hasHeikinAshi reports whether p was stored with its heikin ashi candle.
+// Prices written before candles were persisted have none, and chaining from
+// them needs a rebuild from the symbol's first price.
+func hasHeikinAshi(p *models.Price) bool {
+	return p.HAOpen != 0 || p.HAClose != 0
+}
+
+// renamedAliases returns the aliases of s once renamed to the ticker to: its
+// current ticker becomes an alias and to stops being one.
+func renamedAliases(s models.Symbol, to string) []string {
+	aliases := []string{}
+	for _, a := range s.Aliases {
+		if a != to && a != s.Symbol {
+			aliases = append(aliases, a)
+		}
+	}
+	return append(aliases, s.Symbol)
+}
+
+// setMember returns list with value added, or without it when member is
+// false. list itself is left untouched, it may be shared with callers.
+func setMember(list []string, value string, member bool) []string {
+	updated := []string{}
+	for _, v := range list {
+		if v != value {
+			updated = append(updated, v)
+		}
+	}
+	if member {
+		updated = append(updated, value)
+		slices.Sort(updated)
+	}
+	return updated
+}
@@ -0,0 +1,906 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testStores returns a fresh store of every backend that can run here, mongo
+// only when MONGODB_URL_TEST is set.
+func testStores(t *testing.T) map[string]Store {
+	stores := map[string]Store{
+		MEMORY: InitMemoryStore(),
+	}
+
+	sqliteStore, err := InitSqliteStore(context.Background(), filepath.Join(t.TempDir(), "stonk.db"))
+	if err != nil {
+		t.Fatalf("error opening sqlite store: %v", err)
+	}
+	t.Cleanup(func() { sqliteStore.Close() })
+	_, err = sqliteStore.MigrateUp(context.Background())
+	if err != nil {
+		t.Fatalf("error migrating sqlite store: %v", err)
+	}
+	stores[SQLITE] = sqliteStore
+
+	if url := os.Getenv("MONGODB_URL_TEST"); url != "" {
+		dbClient, err := Init(url)
+		if err != nil {
+			t.Fatalf("error connecting to mongo: %v", err)
+		}
+		stonkDb := dbClient.Database("stonk-test")
+		priceColl, _ := InitPriceCollection(context.Background(), stonkDb)
+		symbolColl, _ := InitSymbolCollection(context.Background(), stonkDb)
+		t.Cleanup(func() {
+			priceColl.Drop(context.Background())
+			symbolColl.Drop(context.Background())
+			Disconnect(dbClient)
+		})
+		t.Cleanup(func() { dbClient.Database("stonk-test").Collection("migrations").Drop(context.Background()) })
+		stores[MONGO] = &Query{
+			SymbolColl: symbolColl,
+			PriceColl:  priceColl,
+		}
+		_, err = stores[MONGO].(*Query).MigrateUp(context.Background())
+		if err != nil {
+			t.Fatalf("error migrating mongo store: %v", err)
+		}
+
+		tsDb := dbClient.Database("stonk-test-timeseries")
+		tsPriceColl, _ := InitPriceCollection(context.Background(), tsDb)
+		tsSymbolColl, _ := InitSymbolCollection(context.Background(), tsDb)
+		t.Cleanup(func() { tsDb.Drop(context.Background()) })
+		tsQuery := &Query{
+			SymbolColl: tsSymbolColl,
+			PriceColl:  tsPriceColl,
+		}
+		_, err = tsQuery.MigrateToTimeSeries(context.Background())
+		switch {
+		case errors.Is(err, ErrTimeSeriesUnsupported):
+			t.Logf("skipping the time-series store: %v", err)
+		case err != nil:
+			t.Fatalf("error migrating to time-series: %v", err)
+		default:
+			_, err = tsQuery.MigrateUp(context.Background())
+			if err != nil {
+				t.Fatalf("error migrating time-series store: %v", err)
+			}
+			stores[MONGO+"-"+TIMESERIES_LAYOUT] = tsQuery
+		}
+	}
+
+	return stores
+}
+
+func stock(symbol, date string, close float64) models.StockData {
+	return models.StockData{
+		Status: "OK",
+		From:   date,
+		Symbol: symbol,
+		Open:   229.57,
+		High:   230.585,
+		Low:    227.2,
+		Close:  close,
+		Volume: 30219759,
+	}
+}
+
+func lastFetchedDate(t *testing.T, store Store, symbol string) string {
+	symbols, err := store.GetAllSymbols(context.Background())
+	if err != nil {
+		t.Fatalf("error getting symbols: %v", err)
+	}
+	for _, s := range symbols {
+		if s.Symbol == symbol {
+			return s.LastFetchedDate.Time().Format("2006-01-02")
+		}
+	}
+	return ""
+}
+
+func TestSqliteMigrateLegacyFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "stonk.db")
+
+	// the tables as created before migrations and heikin ashi highs and lows
+	legacy, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatalf("error opening sqlite file: %v", err)
+	}
+	_, err = legacy.ExecContext(ctx, `CREATE TABLE symbol (
+	id TEXT PRIMARY KEY,
+	symbol TEXT NOT NULL UNIQUE,
+	last_fetched_date INTEGER NOT NULL
+);
+CREATE TABLE price (
+	id TEXT PRIMARY KEY,
+	symbol TEXT NOT NULL,
+	date INTEGER NOT NULL,
+	open REAL NOT NULL,
+	high REAL NOT NULL,
+	low REAL NOT NULL,
+	close REAL NOT NULL,
+	volume REAL NOT NULL,
+	after_hours REAL NOT NULL DEFAULT 0,
+	pre_market REAL NOT NULL DEFAULT 0,
+	ha_open REAL NOT NULL DEFAULT 0,
+	ha_close REAL NOT NULL DEFAULT 0,
+	UNIQUE (symbol, date)
+);
+INSERT INTO symbol VALUES ('65a000000000000000000001', 'ARM', 1722902400000);
+INSERT INTO price (id, symbol, date, open, high, low, close, volume) VALUES ('65a000000000000000000002', 'ARM', 1722902400000, 110, 116, 108, 113.39, 1000);`)
+	legacy.Close()
+	if err != nil {
+		t.Fatalf("error creating legacy tables: %v", err)
+	}
+
+	store, err := InitSqliteStore(ctx, path)
+	if err != nil {
+		t.Fatalf("error opening legacy sqlite store: %v", err)
+	}
+	defer store.Close()
+
+	applied, err := store.MigrateUp(ctx)
+	if err != nil || len(applied) != len(sqliteMigrations) {
+		t.Fatalf("expected %d migrations applied, got %+v, %v", len(sqliteMigrations), applied, err)
+	}
+
+	_, err = store.InsertSymbolStockPrices([]models.StockData{stock("ARM", "2024-08-07", 115.00)}, "ARM", ctx)
+	if err != nil {
+		t.Fatalf("error inserting into legacy price table: %v", err)
+	}
+	prices, err := store.GetStockPrices(ctx, &GetStockPriceOpt{Symbol: "ARM", Limit: 10})
+	if err != nil || len(prices) != 2 {
+		t.Fatalf("expected 2 prices, got %+v, %v", prices, err)
+	}
+	// the legacy price was backfilled once its columns existed
+	for i, p := range prices {
+		if p.HAOpen == 0 || p.HAHigh == 0 || p.HALow == 0 || p.HAClose == 0 {
+			t.Fatalf("Test case %d: expected a heikin ashi candle, got %+v", i, p)
+		}
+	}
+}
+
+func TestStoreUpsert(t *testing.T) {
+	for name, store := range testStores(t) {
+		ctx := context.Background()
+
+		res, err := store.InsertSymbolStockPrices([]models.StockData{stock("AAPL", "2025-02-03", 227.65), stock("AAPL", "2025-02-04", 227.65)}, "AAPL", ctx)
+		if err != nil {
+			t.Fatalf("%s: error: %v", name, err)
+		}
+		if res.Inserted != 2 {
+			t.Fatalf("%s: expected 2 inserted, got %+v", name, res)
+		}
+
+		res, err = store.InsertSymbolStockPrices([]models.StockData{stock("AAPL", "2025-02-03", 227.65), stock("AAPL", "2025-02-04", 228.01), stock("AAPL", "2025-02-05", 227.65)}, "AAPL", ctx)
+		if err != nil {
+			t.Fatalf("%s: error: %v", name, err)
+		}
+		if res.Inserted != 1 || res.Updated != 1 || res.Unchanged != 1 {
+			t.Fatalf("%s: expected 1 inserted, 1 updated, 1 unchanged, got %+v", name, res)
+		}
+		if d := lastFetchedDate(t, store, "AAPL"); d != "2025-02-05" {
+			t.Fatalf("%s: expected latest date 2025-02-05, got %s", name, d)
+		}
+
+		_, err = store.InsertSymbolStockPrices([]models.StockData{stock("AAPL", "2025-01-30", 227.65)}, "AAPL", ctx)
+		if err != nil {
+			t.Fatalf("%s: error: %v", name, err)
+		}
+		if d := lastFetchedDate(t, store, "AAPL"); d != "2025-02-05" {
+			t.Fatalf("%s: expected latest date to stay 2025-02-05, got %s", name, d)
+		}
+	}
+}
+
+func TestStoreGetStockPrices(t *testing.T) {
+	for name, store := range testStores(t) {
+		ctx := context.Background()
+
+		store.InsertSymbolStockPrices([]models.StockData{
+			stock("IBM", "2025-02-07", 252.34),
+			stock("IBM", "2025-02-10", 252.34),
+			stock("IBM", "2025-02-11", 252.34),
+			stock("IBM", time.Now().AddDate(0, 0, 7).Format("2006-01-02"), 252.34),
+		}, "IBM", ctx)
+		store.InsertSymbolStockPrices([]models.StockData{stock("GOOGL", "2025-02-10", 185.34)}, "GOOGL", ctx)
+
+		cases := []struct {
+			input          GetStockPriceOpt
+			expectedLength int
+			expectedFirst  string
+		}{
+			{input: GetStockPriceOpt{Symbol: "IBM", Limit: 2}, expectedLength: 2, expectedFirst: "2025-02-11"},
+			{input: GetStockPriceOpt{Symbol: "IBM", Limit: 5}, expectedLength: 3, expectedFirst: "2025-02-11"},
+			{input: GetStockPriceOpt{Symbol: "GOOGL", Limit: 10}, expectedLength: 1, expectedFirst: "2025-02-10"},
+		}
+
+		for i, c := range cases {
+			prices, err := store.GetStockPrices(ctx, &c.input)
+			if err != nil {
+				t.Fatalf("%s: Test case %d: error: %v", name, i, err)
+			}
+			if len(prices) != c.expectedLength {
+				t.Fatalf("%s: Test case %d: expected %d prices, got %d", name, i, c.expectedLength, len(prices))
+			}
+			if prices[0].Date.Time().Format("2006-01-02") != c.expectedFirst {
+				t.Fatalf("%s: Test case %d: expected latest price on %s, got %s", name, i, c.expectedFirst, prices[0].Date.Time().Format("2006-01-02"))
+			}
+		}
+	}
+}
+
+func TestStoreImport(t *testing.T) {
+	for name, store := range testStores(t) {
+		ctx := context.Background()
+
+		newPrice := func(date string) models.Price {
+			d, _ := time.Parse("2006-01-02", date)
+			return models.Price{Date: primitive.NewDateTimeFromTime(d), Open: 1, High: 2, Low: 0.5, Close: 1.5}
+		}
+
+		res, err := store.ImportStockPrices(ctx, "ARM", []models.Price{newPrice("2024-10-14"), newPrice("2024-10-15")})
+		if err != nil || res.Inserted != 2 || res.Duplicates != 0 {
+			t.Fatalf("%s: expected 2 inserted, got %+v, %v", name, res, err)
+		}
+
+		res, err = store.ImportStockPrices(ctx, "ARM", []models.Price{newPrice("2024-10-15"), newPrice("2024-10-16")})
+		if err != nil || res.Inserted != 1 || res.Duplicates != 1 {
+			t.Fatalf("%s: expected 1 inserted 1 duplicate, got %+v, %v", name, res, err)
+		}
+
+		if d := lastFetchedDate(t, store, "ARM"); d != "2024-10-16" {
+			t.Fatalf("%s: expected latest date 2024-10-16, got %s", name, d)
+		}
+
+		_, err = store.InsertStockPrice(stock("ARM", "2024-10-16", 1.5), ctx)
+		if !errors.Is(err, ErrDuplicatePrice) {
+			t.Fatalf("%s: expected duplicate price to be rejected, got %v", name, err)
+		}
+	}
+}
+
+func TestStoreHeikinAshi(t *testing.T) {
+	for name, store := range testStores(t) {
+		ctx := context.Background()
+
+		store.InsertSymbolStockPrices([]models.StockData{
+			stock("ARM", "2024-08-06", 113.39),
+			stock("ARM", "2024-08-07", 115.00),
+		}, "ARM", ctx)
+		// an older price lands after the newer ones and the chain is rebuilt from it
+		store.ImportStockPrices(ctx, "ARM", []models.Price{{
+			Date:  primitive.NewDateTimeFromTime(time.Date(2024, 8, 5, 0, 0, 0, 0, time.UTC)),
+			Open:  123.73,
+			High:  125.00,
+			Low:   100.00,
+			Close: 104.78,
+		}})
+
+		prices, err := store.GetStockPrices(ctx, &GetStockPriceOpt{Symbol: "ARM", Limit: 10})
+		if err != nil || len(prices) != 3 {
+			t.Fatalf("%s: expected 3 prices, got %d, %v", name, len(prices), err)
+		}
+
+		expected := make([]models.Price, len(prices))
+		for i, p := range prices {
+			expected[len(prices)-1-i] = p
+		}
+		chainHeikinAshi(nil, expected)
+
+		for i, p := range prices {
+			e := expected[len(prices)-1-i]
+			if p.HAOpen != e.HAOpen || p.HAClose != e.HAClose || p.HAHigh != e.HAHigh || p.HALow != e.HALow {
+				t.Fatalf("%s: Test case %d: expected heikin ashi %v, %v, %v, %v, got %v, %v, %v, %v", name, i, e.HAOpen, e.HAHigh, e.HALow, e.HAClose, p.HAOpen, p.HAHigh, p.HALow, p.HAClose)
+			}
+		}
+
+		updated, err := store.UpdateHeikinAshi(ctx, "ARM", epoch)
+		if err != nil || updated != 3 {
+			t.Fatalf("%s: expected 3 rebuilt prices, got %d, %v", name, updated, err)
+		}
+	}
+}
+
+func TestStoreGetStockPricesOptions(t *testing.T) {
+	for name, store := range testStores(t) {
+		ctx := context.Background()
+
+		store.InsertSymbolStockPrices([]models.StockData{
+			stock("IBM", "2025-02-06", 252.34),
+			stock("IBM", "2025-02-07", 252.34),
+			stock("IBM", "2025-02-10", 252.34),
+			stock("IBM", "2025-02-11", 252.34),
+		}, "IBM", ctx)
+		store.InsertSymbolStockPrices([]models.StockData{
+			stock("GOOGL", "2025-02-07", 185.34),
+			stock("GOOGL", "2025-02-10", 185.34),
+		}, "GOOGL", ctx)
+
+		from := time.Date(2025, 2, 7, 0, 0, 0, 0, time.UTC)
+		to := time.Date(2025, 2, 10, 0, 0, 0, 0, time.UTC)
+
+		cases := []struct {
+			input    GetStockPriceOpt
+			expected []string
+		}{
+			{input: GetStockPriceOpt{Symbol: "IBM", From: from, To: to}, expected: []string{"IBM 2025-02-10", "IBM 2025-02-07"}},
+			{input: GetStockPriceOpt{Symbol: "IBM", From: from, Order: ASCENDING}, expected: []string{"IBM 2025-02-07", "IBM 2025-02-10", "IBM 2025-02-11"}},
+			{input: GetStockPriceOpt{Symbols: []string{"IBM", "GOOGL"}, To: to, Order: ASCENDING, Limit: 4}, expected: []string{"GOOGL 2025-02-07", "GOOGL 2025-02-10", "IBM 2025-02-06", "IBM 2025-02-07"}},
+			{input: GetStockPriceOpt{Symbol: "IBM", Symbols: []string{"GOOGL"}, After: &PriceCursor{Symbol: "GOOGL", Date: primitive.NewDateTimeFromTime(from)}}, expected: []string{"IBM 2025-02-11", "IBM 2025-02-10", "IBM 2025-02-07", "IBM 2025-02-06"}},
+			{input: GetStockPriceOpt{Symbol: "IBM", Order: ASCENDING, After: &PriceCursor{Symbol: "IBM", Date: primitive.NewDateTimeFromTime(from)}}, expected: []string{"IBM 2025-02-10", "IBM 2025-02-11"}},
+		}
+
+		for i, c := range cases {
+			prices, err := store.GetStockPrices(ctx, &c.input)
+			if err != nil {
+				t.Fatalf("%s: Test case %d: error: %v", name, i, err)
+			}
+			actual := []string{}
+			for _, p := range prices {
+				actual = append(actual, p.Symbol+" "+p.Date.Time().Format("2006-01-02"))
+			}
+			if strings.Join(actual, ",") != strings.Join(c.expected, ",") {
+				t.Fatalf("%s: Test case %d: expected %v, got %v", name, i, c.expected, actual)
+			}
+		}
+
+		// paging through every price one page at a time
+		opt := &GetStockPriceOpt{Symbols: []string{"IBM", "GOOGL"}, Limit: 4}
+		pages := 0
+		seen := 0
+		for {
+			prices, err := store.GetStockPrices(ctx, opt)
+			if err != nil {
+				t.Fatalf("%s: error paging: %v", name, err)
+			}
+			if len(prices) == 0 {
+				break
+			}
+			pages++
+			seen += len(prices)
+			opt.After = CursorAfter(prices[len(prices)-1])
+		}
+		if pages != 2 || seen != 6 {
+			t.Fatalf("%s: expected 6 prices over 2 pages, got %d over %d", name, seen, pages)
+		}
+
+		prices, err := store.GetStockPrices(ctx, &GetStockPriceOpt{Symbol: "IBM", Fields: []string{"close"}, Limit: 1})
+		if err != nil {
+			t.Fatalf("%s: error projecting: %v", name, err)
+		}
+		if prices[0].Close != 252.34 || prices[0].Open != 0 || prices[0].Date.Time().Format("2006-01-02") != "2025-02-11" {
+			t.Fatalf("%s: expected only date and close, got %+v", name, prices[0])
+		}
+
+		_, err = store.GetStockPrices(ctx, &GetStockPriceOpt{Symbol: "IBM", Fields: []string{"dividend"}})
+		if err == nil {
+			t.Fatalf("%s: expected an error for an unknown field", name)
+		}
+	}
+}
+
+func TestStoreGetLatestStockPrices(t *testing.T) {
+	for name, store := range testStores(t) {
+		ctx := context.Background()
+
+		store.InsertSymbolStockPrices([]models.StockData{
+			stock("IBM", "2025-02-07", 252.34),
+			stock("IBM", "2025-02-10", 252.34),
+			stock("IBM", "2025-02-11", 252.34),
+		}, "IBM", ctx)
+		store.InsertSymbolStockPrices([]models.StockData{stock("GOOGL", "2025-02-10", 185.34)}, "GOOGL", ctx)
+
+		latest, err := store.GetLatestStockPrices(ctx, []string{"IBM", "GOOGL", "MISSING"}, 2)
+		if err != nil {
+			t.Fatalf("%s: error: %v", name, err)
+		}
+
+		cases := []struct {
+			symbol   string
+			expected []string
+		}{
+			{symbol: "IBM", expected: []string{"2025-02-11", "2025-02-10"}},
+			{symbol: "GOOGL", expected: []string{"2025-02-10"}},
+			{symbol: "MISSING", expected: []string{}},
+		}
+
+		for i, c := range cases {
+			actual := []string{}
+			for _, p := range latest[c.symbol] {
+				actual = append(actual, p.Date.Time().Format("2006-01-02"))
+			}
+			if strings.Join(actual, ",") != strings.Join(c.expected, ",") {
+				t.Fatalf("%s: Test case %d: expected %v, got %v", name, i, c.expected, actual)
+			}
+		}
+		if _, ok := latest["MISSING"]; ok {
+			t.Fatalf("%s: expected no entry for a symbol without prices", name)
+		}
+	}
+}
+
+func TestStoreMigrations(t *testing.T) {
+	for name, store := range testStores(t) {
+		ctx := context.Background()
+
+		applied, err := UpgradeSchema(ctx, store)
+		if err != nil || len(applied) != 0 {
+			t.Fatalf("%s: expected a migrated store to be up to date, got %v, %v", name, applied, err)
+		}
+
+		migrator, ok := store.(Migrator)
+		if !ok {
+			continue
+		}
+
+		applied, err = migrator.MigrateUp(ctx)
+		if err != nil || len(applied) != 0 {
+			t.Fatalf("%s: expected nothing left to apply, got %v, %v", name, applied, err)
+		}
+
+		statuses, err := migrator.MigrationStatus(ctx)
+		if err != nil {
+			t.Fatalf("%s: error: %v", name, err)
+		}
+		for i, s := range statuses {
+			if s.Version != i+1 || s.Name == "" || s.AppliedAt.IsZero() {
+				t.Fatalf("%s: Test case %d: expected an applied migration, got %+v", name, i, s)
+			}
+		}
+	}
+}
+
+func TestSqliteStoreSchemaVersion(t *testing.T) {
+	ctx := context.Background()
+	store, err := InitSqliteStore(ctx, filepath.Join(t.TempDir(), "stonk.db"))
+	if err != nil {
+		t.Fatalf("error opening sqlite store: %v", err)
+	}
+	defer store.Close()
+
+	applied, err := UpgradeSchema(ctx, store)
+	if err != nil || len(applied) != len(sqliteMigrations) {
+		t.Fatalf("expected a new store to be migrated, got %v, %v", applied, err)
+	}
+
+	// a newer binary applied one more migration
+	_, err = store.DB.ExecContext(ctx, "INSERT INTO migrations (version, name, applied_at) VALUES (?, ?, ?)", len(sqliteMigrations)+1, "from the future", 0)
+	if err != nil {
+		t.Fatalf("error recording migration: %v", err)
+	}
+
+	_, err = UpgradeSchema(ctx, store)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("expected the schema to be too new, got %v", err)
+	}
+	_, err = store.MigrateUp(ctx)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("expected migrate up to refuse a newer schema, got %v", err)
+	}
+}
+
+// breakWatermarks leaves IBM with a watermark behind its prices and GOOGL with
+// prices but no symbol, as interrupted writes outside of a transaction would.
+func breakWatermarks(t *testing.T, store Store) {
+	ctx := context.Background()
+	lagging := primitive.NewDateTimeFromTime(time.Date(2025, 2, 7, 0, 0, 0, 0, time.UTC))
+
+	var err error
+	switch s := store.(type) {
+	case *MemoryStore:
+		s.symbols["IBM"].LastFetchedDate = lagging
+		delete(s.symbols, "GOOGL")
+	case *SqliteStore:
+		_, err = s.DB.ExecContext(ctx, "UPDATE symbol SET last_fetched_date = ? WHERE symbol = 'IBM'", int64(lagging))
+		if err == nil {
+			_, err = s.DB.ExecContext(ctx, "DELETE FROM symbol WHERE symbol = 'GOOGL'")
+		}
+	case *Query:
+		_, err = s.SymbolColl.UpdateOne(ctx, bson.D{{Key: "symbol", Value: "IBM"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "lastFetchedDate", Value: lagging}}}})
+		if err == nil {
+			_, err = s.SymbolColl.DeleteOne(ctx, bson.D{{Key: "symbol", Value: "GOOGL"}})
+		}
+	}
+	if err != nil {
+		t.Fatalf("error breaking watermarks: %v", err)
+	}
+}
+
+func TestStoreRepairLastFetchedDates(t *testing.T) {
+	for name, store := range testStores(t) {
+		ctx := context.Background()
+
+		store.InsertSymbolStockPrices([]models.StockData{
+			stock("IBM", "2025-02-07", 252.34),
+			stock("IBM", "2025-02-10", 252.34),
+		}, "IBM", ctx)
+		store.InsertSymbolStockPrices([]models.StockData{stock("GOOGL", "2025-02-10", 185.34)}, "GOOGL", ctx)
+		store.InsertSymbolStockPrices([]models.StockData{stock("ARM", "2025-02-10", 140.12)}, "ARM", ctx)
+		breakWatermarks(t, store)
+
+		repairs, err := store.RepairLastFetchedDates(ctx, true)
+		if err != nil || len(repairs) != 2 {
+			t.Fatalf("%s: expected 2 symbols to repair, got %+v, %v", name, repairs, err)
+		}
+		if d := lastFetchedDate(t, store, "IBM"); d != "2025-02-07" {
+			t.Fatalf("%s: expected a dry run to change nothing, got %s", name, d)
+		}
+
+		repairs, err = store.RepairLastFetchedDates(ctx, false)
+		if err != nil {
+			t.Fatalf("%s: error: %v", name, err)
+		}
+
+		cases := []struct {
+			expected SymbolRepair
+		}{
+			{expected: SymbolRepair{Symbol: "GOOGL", To: time.Date(2025, 2, 10, 0, 0, 0, 0, time.UTC), Created: true}},
+			{expected: SymbolRepair{Symbol: "IBM", From: time.Date(2025, 2, 7, 0, 0, 0, 0, time.UTC), To: time.Date(2025, 2, 10, 0, 0, 0, 0, time.UTC)}},
+		}
+		if len(repairs) != len(cases) {
+			t.Fatalf("%s: expected %d repairs, got %+v", name, len(cases), repairs)
+		}
+		for i, c := range cases {
+			r := repairs[i]
+			if r.Symbol != c.expected.Symbol || !r.From.Equal(c.expected.From) || !r.To.Equal(c.expected.To) || r.Created != c.expected.Created {
+				t.Fatalf("%s: Test case %d: expected %+v, got %+v", name, i, c.expected, r)
+			}
+		}
+
+		for _, symbol := range []string{"IBM", "GOOGL", "ARM"} {
+			if d := lastFetchedDate(t, store, symbol); d != "2025-02-10" {
+				t.Fatalf("%s: expected %s to be fetched up to 2025-02-10, got %s", name, symbol, d)
+			}
+		}
+
+		repairs, _ = store.RepairLastFetchedDates(ctx, false)
+		if len(repairs) != 0 {
+			t.Fatalf("%s: expected nothing left to repair, got %+v", name, repairs)
+		}
+	}
+}
+
+func TestStoreSymbolLifecycle(t *testing.T) {
+	for name, store := range testStores(t) {
+		ctx := context.Background()
+
+		store.InsertSymbolStockPrices([]models.StockData{
+			stock("FB", "2025-02-07", 714.52),
+			stock("FB", "2025-02-10", 717.40),
+		}, "FB", ctx)
+		store.InsertSymbolStockPrices([]models.StockData{stock("IBM", "2025-02-10", 252.34)}, "IBM", ctx)
+
+		moved, err := store.RenameSymbol(ctx, "FB", "META")
+		if err != nil || moved != 2 {
+			t.Fatalf("%s: expected 2 prices renamed, got %d, %v", name, moved, err)
+		}
+		if _, err := store.RenameSymbol(ctx, "META", "IBM"); !errors.Is(err, ErrSymbolExists) {
+			t.Fatalf("%s: expected ErrSymbolExists, got %v", name, err)
+		}
+		if _, err := store.RenameSymbol(ctx, "FB", "FACEBOOK"); !errors.Is(err, ErrUnknownSymbol) {
+			t.Fatalf("%s: expected ErrUnknownSymbol renaming an alias, got %v", name, err)
+		}
+
+		cases := []struct {
+			symbol   string
+			expected string
+		}{
+			{symbol: "META", expected: "META"},
+			{symbol: "FB", expected: "META"},
+			{symbol: "IBM", expected: "IBM"},
+		}
+		for i, c := range cases {
+			s, err := store.ResolveSymbol(ctx, c.symbol)
+			if err != nil || s.Symbol != c.expected {
+				t.Fatalf("%s: Test case %d: expected %s, got %+v, %v", name, i, c.expected, s, err)
+			}
+		}
+
+		prices, _ := store.GetStockPrices(ctx, &GetStockPriceOpt{Symbol: "META"})
+		if len(prices) != 2 || prices[0].Symbol != "META" {
+			t.Fatalf("%s: expected the prices under META, got %+v", name, prices)
+		}
+		if d := lastFetchedDate(t, store, "META"); d != "2025-02-10" {
+			t.Fatalf("%s: expected the watermark to follow the rename, got %s", name, d)
+		}
+
+		// renaming back drops the alias it becomes
+		store.RenameSymbol(ctx, "META", "FB")
+		s, _ := store.ResolveSymbol(ctx, "FB")
+		if s.Symbol != "FB" || len(s.Aliases) != 1 || s.Aliases[0] != "META" {
+			t.Fatalf("%s: expected FB aliased by META, got %+v", name, s)
+		}
+
+		err = store.ArchiveSymbol(ctx, "FB", true)
+		if err != nil {
+			t.Fatalf("%s: error archiving: %v", name, err)
+		}
+		s, _ = store.ResolveSymbol(ctx, "FB")
+		if !s.Archived {
+			t.Fatalf("%s: expected FB archived, got %+v", name, s)
+		}
+		if store.ArchiveSymbol(ctx, "MISSING", true) != ErrUnknownSymbol {
+			t.Fatalf("%s: expected ErrUnknownSymbol archiving a missing symbol", name)
+		}
+
+		removed, err := store.RemoveSymbol(ctx, "FB")
+		if err != nil || removed != 2 {
+			t.Fatalf("%s: expected 2 prices removed, got %d, %v", name, removed, err)
+		}
+		if _, err := store.ResolveSymbol(ctx, "META"); err != ErrUnknownSymbol {
+			t.Fatalf("%s: expected the aliases removed, got %v", name, err)
+		}
+		prices, _ = store.GetStockPrices(ctx, &GetStockPriceOpt{Symbol: "FB"})
+		if len(prices) != 0 {
+			t.Fatalf("%s: expected no prices left, got %+v", name, prices)
+		}
+		symbols, _ := store.GetAllSymbols(ctx)
+		if len(symbols) != 1 || symbols[0].Symbol != "IBM" {
+			t.Fatalf("%s: expected only IBM left, got %+v", name, symbols)
+		}
+	}
+}
+
+func TestStoreWatchlists(t *testing.T) {
+	for name, store := range testStores(t) {
+		ctx := context.Background()
+
+		store.InsertSymbolStockPrices([]models.StockData{stock("IBM", "2025-02-10", 252.34)}, "IBM", ctx)
+		store.InsertSymbolStockPrices([]models.StockData{stock("ARM", "2025-02-10", 140.12)}, "ARM", ctx)
+
+		for _, w := range []string{"core", "candidates"} {
+			err := store.CreateWatchlist(ctx, w)
+			if err != nil {
+				t.Fatalf("%s: error creating watchlist %s: %v", name, w, err)
+			}
+		}
+		if err := store.CreateWatchlist(ctx, "core"); !errors.Is(err, ErrWatchlistExists) {
+			t.Fatalf("%s: expected ErrWatchlistExists, got %v", name, err)
+		}
+
+		watchlists, err := store.GetWatchlists(ctx)
+		if err != nil || len(watchlists) != 2 || watchlists[0].Name != "candidates" || watchlists[1].Name != "core" {
+			t.Fatalf("%s: expected candidates and core, got %+v, %v", name, watchlists, err)
+		}
+
+		cases := []struct {
+			watchlist string
+			symbol    string
+			member    bool
+			expected  error
+		}{
+			{watchlist: "core", symbol: "IBM", member: true},
+			{watchlist: "core", symbol: "IBM", member: true},
+			{watchlist: "candidates", symbol: "IBM", member: true},
+			{watchlist: "core", symbol: "ARM", member: true},
+			{watchlist: "core", symbol: "ARM", member: false},
+			{watchlist: "missing", symbol: "IBM", member: true, expected: ErrUnknownWatchlist},
+			{watchlist: "core", symbol: "MISSING", member: true, expected: ErrUnknownSymbol},
+		}
+		for i, c := range cases {
+			err := store.SetWatchlistMember(ctx, c.watchlist, c.symbol, c.member)
+			if !errors.Is(err, c.expected) {
+				t.Fatalf("%s: Test case %d: expected %v, got %v", name, i, c.expected, err)
+			}
+		}
+
+		for _, tag := range []string{"tech", "ai", "tech"} {
+			err := store.SetSymbolTag(ctx, "IBM", tag, true)
+			if err != nil {
+				t.Fatalf("%s: error tagging: %v", name, err)
+			}
+		}
+		store.SetSymbolTag(ctx, "IBM", "ai", false)
+		if err := store.SetSymbolTag(ctx, "MISSING", "ai", true); !errors.Is(err, ErrUnknownSymbol) {
+			t.Fatalf("%s: expected ErrUnknownSymbol, got %v", name, err)
+		}
+
+		// renames keep the tags and watchlists
+		store.RenameSymbol(ctx, "IBM", "IBMX")
+		s, _ := store.ResolveSymbol(ctx, "IBMX")
+		if !slices.Equal(s.Tags, []string{"tech"}) || !slices.Equal(s.Watchlists, []string{"candidates", "core"}) {
+			t.Fatalf("%s: expected tags [tech] and watchlists [candidates core], got %+v", name, s)
+		}
+
+		err = store.DeleteWatchlist(ctx, "core")
+		if err != nil {
+			t.Fatalf("%s: error deleting watchlist: %v", name, err)
+		}
+		if err := store.DeleteWatchlist(ctx, "core"); !errors.Is(err, ErrUnknownWatchlist) {
+			t.Fatalf("%s: expected ErrUnknownWatchlist, got %v", name, err)
+		}
+		symbols, _ := store.GetAllSymbols(ctx)
+		for _, s := range symbols {
+			if slices.Contains(s.Watchlists, "core") {
+				t.Fatalf("%s: expected %s out of the deleted watchlist, got %+v", name, s.Symbol, s.Watchlists)
+			}
+		}
+	}
+}
+
+func TestStoreTrades(t *testing.T) {
+	for name, store := range testStores(t) {
+		ctx := context.Background()
+
+		trade := func(symbol, tradeType, date string, quantity float64) models.Trade {
+			d, _ := time.Parse("2006-01-02", date)
+			return models.Trade{Symbol: symbol, Type: tradeType, Date: primitive.NewDateTimeFromTime(d), Quantity: quantity, Price: 100, Note: "test"}
+		}
+
+		inserted := []models.Trade{}
+		for _, tr := range []models.Trade{
+			trade("IBM", "buy", "2025-02-10", 10),
+			trade("ARM", "buy", "2025-02-07", 5),
+			trade("IBM", "sell", "2025-02-07", 1),
+			trade("IBM", "buy", "2025-02-07", 2),
+		} {
+			if tr.Type == "sell" {
+				tr.Lots = []primitive.ObjectID{inserted[0].Id}
+			}
+			tr, err := store.InsertTrade(ctx, tr)
+			if err != nil || tr.Id.IsZero() {
+				t.Fatalf("%s: error inserting trade: %+v, %v", name, tr, err)
+			}
+			inserted = append(inserted, tr)
+		}
+
+		cases := []struct {
+			symbols  []string
+			expected []int
+		}{
+			{symbols: nil, expected: []int{1, 2, 3, 0}},
+			{symbols: []string{"IBM"}, expected: []int{2, 3, 0}},
+			{symbols: []string{"MISSING"}, expected: []int{}},
+		}
+		for i, c := range cases {
+			trades, err := store.GetTrades(ctx, c.symbols)
+			if err != nil || len(trades) != len(c.expected) {
+				t.Fatalf("%s: Test case %d: expected %d trades, got %+v, %v", name, i, len(c.expected), trades, err)
+			}
+			for j, k := range c.expected {
+				if !reflect.DeepEqual(trades[j], inserted[k]) {
+					t.Fatalf("%s: Test case %d: expected %+v at %d, got %+v", name, i, inserted[k], j, trades[j])
+				}
+			}
+		}
+
+		err := store.DeleteTrade(ctx, inserted[1].Id)
+		if err != nil {
+			t.Fatalf("%s: error deleting trade: %v", name, err)
+		}
+		if err := store.DeleteTrade(ctx, inserted[1].Id); !errors.Is(err, ErrUnknownTrade) {
+			t.Fatalf("%s: expected ErrUnknownTrade, got %v", name, err)
+		}
+
+		// trades follow renamed symbols
+		store.InsertSymbolStockPrices([]models.StockData{stock("IBM", "2025-02-10", 252.34)}, "IBM", ctx)
+		store.RenameSymbol(ctx, "IBM", "IBMX")
+		trades, _ := store.GetTrades(ctx, []string{"IBMX"})
+		if len(trades) != 3 {
+			t.Fatalf("%s: expected 3 trades moved to IBMX, got %+v", name, trades)
+		}
+	}
+}
+
+func TestStoreImportTrades(t *testing.T) {
+	for name, store := range testStores(t) {
+		ctx := context.Background()
+
+		d := primitive.NewDateTimeFromTime(time.Date(2025, 2, 10, 0, 0, 0, 0, time.UTC))
+		trades := []models.Trade{
+			{Symbol: "IBM", Type: "buy", Date: d, Quantity: 1, Price: 250, Broker: "ibkr", TransactionId: "1"},
+			{Symbol: "IBM", Type: "buy", Date: d, Quantity: 1, Price: 250, Broker: "ibkr", TransactionId: "2"},
+			{Symbol: "IBM", Type: "buy", Date: d, Quantity: 1, Price: 250, Broker: "schwab", TransactionId: "1"},
+		}
+		store.InsertTrade(ctx, models.Trade{Symbol: "IBM", Type: "buy", Date: d, Quantity: 1, Price: 250})
+
+		cases := []struct {
+			trades   []models.Trade
+			expected ImportResult
+		}{
+			{trades: trades, expected: ImportResult{Inserted: 3}},
+			{trades: trades[1:], expected: ImportResult{Duplicates: 2}},
+			{trades: append(trades[:1:1], trades[0]), expected: ImportResult{Duplicates: 2}},
+			{trades: []models.Trade{{Symbol: "IBM", Type: "buy", Date: d, Quantity: 1, Price: 250}}, expected: ImportResult{Inserted: 1}},
+		}
+		for i, c := range cases {
+			res, err := store.ImportTrades(ctx, c.trades)
+			if err != nil || *res != c.expected {
+				t.Fatalf("%s: Test case %d: expected %+v, got %+v, %v", name, i, c.expected, res, err)
+			}
+		}
+
+		stored, _ := store.GetTrades(ctx, nil)
+		if len(stored) != 5 || stored[1].Broker != "ibkr" || stored[1].TransactionId != "1" {
+			t.Fatalf("%s: expected 5 trades with their transaction ids, got %+v", name, stored)
+		}
+
+		s, err := store.CreateSymbol(ctx, "IBM", time.Date(2025, 2, 7, 0, 0, 0, 0, time.UTC))
+		if err != nil || s.Symbol != "IBM" || s.Id.IsZero() {
+			t.Fatalf("%s: expected IBM created, got %+v, %v", name, s, err)
+		}
+		if d := lastFetchedDate(t, store, "IBM"); d != "2025-02-07" {
+			t.Fatalf("%s: expected IBM fetched up to 2025-02-07, got %s", name, d)
+		}
+		if _, err := store.CreateSymbol(ctx, "IBM", epoch); !errors.Is(err, ErrSymbolExists) {
+			t.Fatalf("%s: expected ErrSymbolExists, got %v", name, err)
+		}
+	}
+}
+
+func TestStoreSignals(t *testing.T) {
+	for name, store := range testStores(t) {
+		ctx := context.Background()
+
+		signal := func(symbol, date, sentiment string) models.Signal {
+			d, _ := time.Parse("2006-01-02", date)
+			return models.Signal{
+				Symbol: symbol, Date: primitive.NewDateTimeFromTime(d), HAOpen: 250, HAHigh: 255, HALow: 249, HAClose: 252,
+				Uptrend: true, Doji: true, Sentiment: sentiment, RuleVersion: 1, EvaluatedAt: primitive.NewDateTimeFromTime(time.Now().Truncate(time.Millisecond)),
+			}
+		}
+
+		inserted := []models.Signal{}
+		for _, s := range []models.Signal{
+			signal("IBM", "2025-02-10", "sell"),
+			signal("ARM", "2025-02-07", "buy"),
+			signal("IBM", "2025-02-07", "hold"),
+			signal("IBM", "2025-02-10", "hold, add"),
+		} {
+			s, err := store.InsertSignal(ctx, s)
+			if err != nil || s.Id.IsZero() {
+				t.Fatalf("%s: error inserting signal: %+v, %v", name, s, err)
+			}
+			inserted = append(inserted, s)
+		}
+
+		from, _ := time.Parse("2006-01-02", "2025-02-08")
+		cases := []struct {
+			opt      GetSignalOpt
+			expected []int
+		}{
+			{opt: GetSignalOpt{Symbol: "IBM"}, expected: []int{2, 0, 3}},
+			{opt: GetSignalOpt{Symbol: "IBM", From: from}, expected: []int{0, 3}},
+			{opt: GetSignalOpt{Symbol: "IBM", To: from}, expected: []int{2}},
+			{opt: GetSignalOpt{Symbol: "MISSING"}, expected: []int{}},
+		}
+		for i, c := range cases {
+			signals, err := store.GetSignals(ctx, &c.opt)
+			if err != nil || len(signals) != len(c.expected) {
+				t.Fatalf("%s: Test case %d: expected %d signals, got %+v, %v", name, i, len(c.expected), signals, err)
+			}
+			for j, k := range c.expected {
+				if signals[j] != inserted[k] {
+					t.Fatalf("%s: Test case %d: expected %+v at %d, got %+v", name, i, inserted[k], j, signals[j])
+				}
+			}
+		}
+
+		// signals follow renamed symbols and go with removed ones
+		store.InsertSymbolStockPrices([]models.StockData{stock("IBM", "2025-02-10", 252.34)}, "IBM", ctx)
+		store.RenameSymbol(ctx, "IBM", "IBMX")
+		signals, _ := store.GetSignals(ctx, &GetSignalOpt{Symbol: "IBMX"})
+		if len(signals) != 3 {
+			t.Fatalf("%s: expected 3 signals moved to IBMX, got %+v", name, signals)
+		}
+		store.RemoveSymbol(ctx, "IBMX")
+		signals, _ = store.GetSignals(ctx, &GetSignalOpt{Symbol: "IBMX"})
+		if len(signals) != 0 {
+			t.Fatalf("%s: expected the signals of IBMX removed, got %+v", name, signals)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	PLAIN_LAYOUT      = "plain"
+	TIMESERIES_LAYOUT = "timeseries"
+)
+
+// suffix of the plain price collection kept as a backup by MigrateToTimeSeries
+const plainBackupSuffix = "_plain"
+
+// first server version updating time-series measurements with a filter on _id,
+// UpdateHeikinAshi and upsertTimeSeriesPrices rely on it
+const minTimeSeriesMajorVersion = 7
+
+var ErrTimeSeriesUnsupported = errors.New(fmt.Sprintf("the time-series layout needs mongodb %d.0 or newer", minTimeSeriesMajorVersion))
+
+// GetPriceLayout reports whether coll is a time-series collection. A
+// collection that does not exist yet is plain, that is what InitPriceCollection
+// creates.
+func GetPriceLayout(ctx context.Context, coll *mongo.Collection) (string, error) {
+	specs, err := coll.Database().ListCollectionSpecifications(ctx, bson.D{{Key: "name", Value: coll.Name()}})
+	if err != nil {
+		fmt.Println("failed to list collections")
+		return "", err
+	}
+
+	if len(specs) == 1 && specs[0].Type == TIMESERIES_LAYOUT {
+		return TIMESERIES_LAYOUT, nil
+	}
+	return PLAIN_LAYOUT, nil
+}
+
+// MigrateToTimeSeries moves the prices into a time-series collection with
+// timeField date and metaField symbol. The plain collection is renamed with
+// plainBackupSuffix and kept, running it again resumes an interrupted copy.
+// It returns the number of prices copied, servers older than
+// minTimeSeriesMajorVersion are refused with ErrTimeSeriesUnsupported.
+func (q *Query) MigrateToTimeSeries(ctx context.Context) (int, error) {
+	database := q.PriceColl.Database()
+	name := q.PriceColl.Name()
+	backup := database.Collection(name + plainBackupSuffix)
+
+	version, err := serverMajorVersion(ctx, database)
+	if err != nil {
+		return 0, err
+	}
+	if version < minTimeSeriesMajorVersion {
+		return 0, fmt.Errorf("%w, the server runs %d", ErrTimeSeriesUnsupported, version)
+	}
+
+	layout, err := GetPriceLayout(ctx, q.PriceColl)
+	if err != nil {
+		return 0, err
+	}
+
+	if layout == PLAIN_LAYOUT {
+		// time-series collections cannot be renamed, so the plain one moves aside
+		err := database.Client().Database("admin").RunCommand(ctx, bson.D{
+			{Key: "renameCollection", Value: database.Name() + "." + name},
+			{Key: "to", Value: database.Name() + "." + backup.Name()},
+		}).Err()
+		var cmdErr mongo.CommandError
+		// NamespaceNotFound, nothing stored yet
+		if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Code == 26) {
+			fmt.Println("failed to rename price collection")
+			return 0, err
+		}
+
+		err = database.CreateCollection(ctx, name, options.CreateCollection().SetTimeSeriesOptions(
+			options.TimeSeries().SetTimeField("date").SetMetaField("symbol").SetGranularity("hours")))
+		if err != nil {
+			fmt.Println("failed to create time-series price collection")
+			return 0, err
+		}
+	}
+
+	q.PriceColl = database.Collection(name)
+	q.TimeSeries = true
+
+	_, err = InitPriceCollection(ctx, database)
+	if err != nil {
+		return 0, err
+	}
+
+	symbols, err := backup.Distinct(ctx, "symbol", bson.D{})
+	if err != nil {
+		fmt.Println("failed to get symbols to migrate")
+		return 0, err
+	}
+
+	copied := 0
+	for _, s := range symbols {
+		symbol, ok := s.(string)
+		if !ok {
+			continue
+		}
+
+		n, err := q.copyPrices(ctx, backup, symbol)
+		copied += n
+		if err != nil {
+			fmt.Printf("failed to migrate prices of symbol: %s\n", symbol)
+			return copied, err
+		}
+	}
+
+	return copied, nil
+}
+
+// serverMajorVersion asks the server of database for its major version.
+func serverMajorVersion(ctx context.Context, database *mongo.Database) (int, error) {
+	info := struct {
+		VersionArray []int32 `bson:"versionArray"`
+	}{}
+	err := database.RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info)
+	if err != nil {
+		fmt.Println("failed to get server version")
+		return 0, err
+	}
+	if len(info.VersionArray) == 0 {
+		return 0, errors.New("server did not report its version")
+	}
+	return int(info.VersionArray[0]), nil
+}
+
+// copyPrices inserts the prices of symbol stored in from and missing from the
+// price collection, importBatchSize at a time so that neither side of a long
+// history is held in memory.
+func (q *Query) copyPrices(ctx context.Context, from *mongo.Collection, symbol string) (int, error) {
+	cursor, err := from.Find(ctx, bson.D{{Key: "symbol", Value: symbol}},
+		options.Find().SetSort(bson.D{{Key: "date", Value: 1}}).SetBatchSize(importBatchSize))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	copied := 0
+	batch := make([]models.Price, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		duplicates, err := q.insertTimeSeriesPrices(ctx, symbol, batch)
+		if err != nil {
+			return err
+		}
+		copied += len(batch) - duplicates
+		batch = batch[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		p := models.Price{}
+		err := cursor.Decode(&p)
+		if err != nil {
+			return copied, err
+		}
+
+		batch = append(batch, p)
+		if len(batch) == importBatchSize {
+			err := flush()
+			if err != nil {
+				return copied, err
+			}
+		}
+	}
+	if cursor.Err() != nil {
+		return copied, cursor.Err()
+	}
+
+	return copied, flush()
+}
+
+// existingPrices returns the stored prices of symbol on dates. Time-series
+// collections have no unique index, so writes look up what is already there
+// instead. The lookup and the write that follows are not atomic: two
+// processes writing the same symbol at once, say overlapping refresh runs, can
+// both insert a date. Run a single writer against the time-series layout.
+func (q *Query) existingPrices(ctx context.Context, symbol string, dates []primitive.DateTime) (map[primitive.DateTime]models.Price, error) {
+	cursor, err := q.PriceColl.Find(ctx, bson.D{
+		{Key: "symbol", Value: symbol},
+		{Key: "date", Value: bson.D{{Key: "$in", Value: dates}}},
+	})
+	if err != nil {
+		fmt.Println("failed to get price for symbol")
+		return nil, err
+	}
+
+	var prices []models.Price
+	err = cursor.All(ctx, &prices)
+	if err != nil {
+		fmt.Println("faile to decode all prices")
+		return nil, err
+	}
+
+	existing := map[primitive.DateTime]models.Price{}
+	for _, p := range prices {
+		existing[p.Date] = p
+	}
+	return existing, nil
+}
+
+// upsertTimeSeriesPrices is the time-series counterpart of the upsert bulk
+// write: new dates are inserted and changed ones updated by _id. It returns
+// the indexes of prices that failed to land.
+func (q *Query) upsertTimeSeriesPrices(ctx context.Context, symbol string, prices []models.Price, result *UpsertResult) (map[int]bool, error) {
+	dates := make([]primitive.DateTime, 0, len(prices))
+	for _, p := range prices {
+		dates = append(dates, p.Date)
+	}
+	existing, err := q.existingPrices(ctx, symbol, dates)
+	if err != nil {
+		return nil, err
+	}
+
+	writes := []mongo.WriteModel{}
+	indexes := []int{}
+	inserts := []bool{}
+	for i, p := range prices {
+		stored, ok := existing[p.Date]
+		switch {
+		case !ok:
+			writes = append(writes, mongo.NewInsertOneModel().SetDocument(p))
+			existing[p.Date] = p
+			result.Inserted++
+		case stored.Open != p.Open || stored.High != p.High || stored.Low != p.Low || stored.Close != p.Close || stored.Volume != p.Volume:
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.D{{Key: "_id", Value: stored.Id}}).
+				SetUpdate(bson.D{{Key: "$set", Value: bson.D{
+					{Key: "open", Value: p.Open},
+					{Key: "high", Value: p.High},
+					{Key: "low", Value: p.Low},
+					{Key: "close", Value: p.Close},
+					{Key: "volume", Value: p.Volume},
+				}}}))
+			result.Updated++
+		default:
+			result.Unchanged++
+			continue
+		}
+		indexes = append(indexes, i)
+		inserts = append(inserts, !ok)
+	}
+
+	failed := map[int]bool{}
+	if len(writes) == 0 {
+		return failed, nil
+	}
+
+	_, err = q.PriceColl.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		return nil, err
+	}
+	for _, we := range bulkErr.WriteErrors {
+		failed[indexes[we.Index]] = true
+		if inserts[we.Index] {
+			result.Inserted--
+		} else {
+			result.Updated--
+		}
+	}
+	result.Failed = len(failed)
+
+	return failed, err
+}
+
+// insertTimeSeriesPrices inserts the prices whose date is not stored yet and
+// returns how many were skipped as duplicates.
+func (q *Query) insertTimeSeriesPrices(ctx context.Context, symbol string, prices []models.Price) (int, error) {
+	dates := make([]primitive.DateTime, 0, len(prices))
+	for _, p := range prices {
+		dates = append(dates, p.Date)
+	}
+	existing, err := q.existingPrices(ctx, symbol, dates)
+	if err != nil {
+		return 0, err
+	}
+
+	docs := make([]interface{}, 0, len(prices))
+	for _, p := range prices {
+		if _, ok := existing[p.Date]; ok {
+			continue
+		}
+		existing[p.Date] = p
+		docs = append(docs, p)
+	}
+
+	if len(docs) > 0 {
+		_, err = q.PriceColl.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(prices) - len(docs), nil
+}
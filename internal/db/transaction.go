@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// supportsTransactions reports whether writes to the price collection can run
+// in a transaction: the deployment must be a replica set or a sharded cluster,
+// and time-series collections cannot be written to in transactions. The
+// answer is asked once per Query.
+func (q *Query) supportsTransactions(ctx context.Context) bool {
+	if q.TimeSeries {
+		return false
+	}
+
+	q.txOnce.Do(func() {
+		var hello struct {
+			SetName string `bson:"setName"`
+			Msg     string `bson:"msg"`
+		}
+		err := q.PriceColl.Database().Client().Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err != nil {
+			fmt.Println("failed to get deployment topology, writing without transactions")
+			return
+		}
+		q.txSupported = hello.SetName != "" || hello.Msg == "isdbgrid"
+	})
+
+	return q.txSupported
+}
+
+// withTransaction runs fn in a session transaction when transactional, fn is
+// then retried from the start on transient errors. Otherwise fn runs as is,
+// one write at a time, which is how standalone servers work: writers order
+// their writes so that an interruption is caught up by the next refresh or by
+// RepairLastFetchedDates.
+func (q *Query) withTransaction(ctx context.Context, transactional bool, fn func(ctx context.Context) error) error {
+	if !transactional {
+		return fn(ctx)
+	}
+
+	session, err := q.PriceColl.Database().Client().StartSession()
+	if err != nil {
+		fmt.Println("failed to start session")
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
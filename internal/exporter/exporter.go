@@ -0,0 +1,181 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/calculation"
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	CSV     = "csv"
+	JSONL   = "jsonl"
+	PARQUET = "parquet"
+)
+
+// rows buffered per parquet row group, a row group is held in memory until
+// it is flushed
+const parquetRowGroupSize = 64 * 1024
+
+// Row is one exported price with its heikin ashi candle and the patterns
+// detected on it.
+type Row struct {
+	Symbol      string    `json:"symbol" parquet:"symbol,dict"`
+	Date        time.Time `json:"date" parquet:"date,timestamp(millisecond)"`
+	Open        float64   `json:"open" parquet:"open"`
+	High        float64   `json:"high" parquet:"high"`
+	Low         float64   `json:"low" parquet:"low"`
+	Close       float64   `json:"close" parquet:"close"`
+	Volume      float64   `json:"volume" parquet:"volume"`
+	HAOpen      float64   `json:"haOpen" parquet:"ha_open"`
+	HAHigh      float64   `json:"haHigh" parquet:"ha_high"`
+	HALow       float64   `json:"haLow" parquet:"ha_low"`
+	HAClose     float64   `json:"haClose" parquet:"ha_close"`
+	Uptrend     bool      `json:"uptrend" parquet:"uptrend"`
+	Bull        bool      `json:"bull" parquet:"bull"`
+	Bear        bool      `json:"bear" parquet:"bear"`
+	SpinningTop bool      `json:"spinningTop" parquet:"spinning_top"`
+	Doji        bool      `json:"doji" parquet:"doji"`
+	Gravestone  bool      `json:"gravestone" parquet:"gravestone"`
+	Sentiment   string    `json:"sentiment" parquet:"sentiment,dict"`
+}
+
+// NewRow builds the row of p, prev being the price stored right before it.
+// Without prev the patterns are read against p itself, the same way the first
+// heikin ashi candle of a symbol is seeded.
+func NewRow(p models.Price, prev *models.Price) Row {
+	price := calculation.PriceCal{
+		Open:  p.Open,
+		Close: p.Close,
+		High:  p.High,
+		Low:   p.Low,
+	}
+	ha := price
+	if prev != nil {
+		ha = calculation.PriceCal{
+			Open:  prev.HAOpen,
+			Close: prev.HAClose,
+		}
+	}
+	pt := calculation.GetPatterns(&price, &ha)
+
+	return Row{
+		Symbol:      p.Symbol,
+		Date:        p.Date.Time().UTC(),
+		Open:        p.Open,
+		High:        p.High,
+		Low:         p.Low,
+		Close:       p.Close,
+		Volume:      p.Volume,
+		HAOpen:      p.HAOpen,
+		HAHigh:      p.HAHigh,
+		HALow:       p.HALow,
+		HAClose:     p.HAClose,
+		Uptrend:     pt.Uptrend,
+		Bull:        pt.Bull,
+		Bear:        pt.Bear,
+		SpinningTop: pt.SpinningTop,
+		Doji:        pt.Doji,
+		Gravestone:  pt.Gravestone,
+		Sentiment:   pt.Sentiment(),
+	}
+}
+
+// Writer writes rows one at a time. Close must be called to complete the
+// output, it does not close the underlying io.Writer.
+type Writer interface {
+	Write(row Row) error
+	Close() error
+}
+
+func InitWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case CSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case JSONL:
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	case PARQUET:
+		return &parquetWriter{w: parquet.NewGenericWriter[Row](w)}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown export format: %s", format))
+}
+
+var csvHeader = []string{"symbol", "date", "open", "high", "low", "close", "volume", "ha_open", "ha_high", "ha_low", "ha_close", "uptrend", "bull", "bear", "spinning_top", "doji", "gravestone", "sentiment"}
+
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (c *csvWriter) Write(row Row) error {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		err := c.w.Write(csvHeader)
+		if err != nil {
+			return err
+		}
+	}
+
+	f := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return c.w.Write([]string{
+		row.Symbol, row.Date.Format("2006-01-02"),
+		f(row.Open), f(row.High), f(row.Low), f(row.Close), f(row.Volume),
+		f(row.HAOpen), f(row.HAHigh), f(row.HALow), f(row.HAClose),
+		strconv.FormatBool(row.Uptrend), strconv.FormatBool(row.Bull), strconv.FormatBool(row.Bear),
+		strconv.FormatBool(row.SpinningTop), strconv.FormatBool(row.Doji), strconv.FormatBool(row.Gravestone),
+		row.Sentiment,
+	})
+}
+
+func (c *csvWriter) Close() error {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		c.w.Write(csvHeader)
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) Write(row Row) error {
+	return j.enc.Encode(row)
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
+
+type parquetWriter struct {
+	w        *parquet.GenericWriter[Row]
+	buffered int
+}
+
+func (p *parquetWriter) Write(row Row) error {
+	_, err := p.w.Write([]Row{row})
+	if err != nil {
+		return err
+	}
+
+	p.buffered++
+	if p.buffered == parquetRowGroupSize {
+		p.buffered = 0
+		return p.w.Flush()
+	}
+	return nil
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.Close()
+}
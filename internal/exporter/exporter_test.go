@@ -0,0 +1,126 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"github.com/parquet-go/parquet-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testRows() []Row {
+	prev := models.Price{
+		Symbol:  "ARM",
+		Date:    primitive.NewDateTimeFromTime(time.Date(2024, 8, 5, 0, 0, 0, 0, time.UTC)),
+		HAOpen:  123.73,
+		HAClose: 104.78,
+	}
+	p := models.Price{
+		Symbol:  "ARM",
+		Date:    primitive.NewDateTimeFromTime(time.Date(2024, 8, 6, 0, 0, 0, 0, time.UTC)),
+		Open:    115.53,
+		High:    117.97,
+		Low:     109.50,
+		Close:   113.39,
+		Volume:  12000000,
+		HAOpen:  114.255,
+		HAHigh:  117.97,
+		HALow:   109.50,
+		HAClose: 114.0975,
+	}
+	return []Row{NewRow(prev, nil), NewRow(p, &prev)}
+}
+
+func writeRows(t *testing.T, format string, rows []Row) []byte {
+	buf := &bytes.Buffer{}
+	w, err := InitWriter(buf, format)
+	if err != nil {
+		t.Fatalf("error creating %s writer: %v", format, err)
+	}
+	for _, row := range rows {
+		err := w.Write(row)
+		if err != nil {
+			t.Fatalf("error writing %s: %v", format, err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("error closing %s writer: %v", format, err)
+	}
+	return buf.Bytes()
+}
+
+func TestNewRow(t *testing.T) {
+	rows := testRows()
+
+	if rows[1].Uptrend || !rows[1].Doji || rows[1].Sentiment != "buy" {
+		t.Fatalf("expected a doji in a downtrend to buy, got %+v", rows[1])
+	}
+	if rows[1].Date.Format("2006-01-02") != "2024-08-06" {
+		t.Fatalf("unexpected date: %s", rows[1].Date)
+	}
+}
+
+func TestWriteCsv(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(string(writeRows(t, CSV, testRows()))), "\n")
+
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %d lines", len(lines))
+	}
+	if lines[0] != strings.Join(csvHeader, ",") {
+		t.Fatalf("unexpected header: %s", lines[0])
+	}
+	if lines[2] != "ARM,2024-08-06,115.53,117.97,109.5,113.39,12000000,114.255,117.97,109.5,114.0975,false,false,false,false,true,false,buy" {
+		t.Fatalf("unexpected row: %s", lines[2])
+	}
+
+	empty := strings.TrimSpace(string(writeRows(t, CSV, nil)))
+	if empty != strings.Join(csvHeader, ",") {
+		t.Fatalf("expected only the header, got %s", empty)
+	}
+}
+
+func TestWriteJsonl(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(string(writeRows(t, JSONL, testRows()))), "\n")
+
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 rows, got %d lines", len(lines))
+	}
+	row := Row{}
+	err := json.Unmarshal([]byte(lines[1]), &row)
+	if err != nil {
+		t.Fatalf("error decoding row: %v", err)
+	}
+	if row != testRows()[1] {
+		t.Fatalf("expected %+v, got %+v", testRows()[1], row)
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	data := writeRows(t, PARQUET, testRows())
+
+	rows, err := parquet.Read[Row](bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("error reading parquet: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	for i, row := range rows {
+		expected := testRows()[i]
+		if row.Symbol != expected.Symbol || !row.Date.Equal(expected.Date) || row.HAClose != expected.HAClose || row.Sentiment != expected.Sentiment {
+			t.Fatalf("Test case %d: expected %+v, got %+v", i, expected, row)
+		}
+	}
+}
+
+func TestInitWriter(t *testing.T) {
+	_, err := InitWriter(&bytes.Buffer{}, "xlsx")
+	if err == nil {
+		t.Fatalf("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,317 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	CSV   = "csv"
+	JSONL = "jsonl"
+)
+
+var fields = []string{"symbol", "date", "open", "high", "low", "close", "volume"}
+
+// column names recognised without an explicit mapping, compared case-insensitively
+var defaultColumns = map[string][]string{
+	"symbol": {"symbol", "ticker"},
+	"date":   {"date", "timestamp", "time"},
+	"open":   {"open", "o"},
+	"high":   {"high", "h"},
+	"low":    {"low", "l"},
+	"close":  {"close", "c"},
+	"volume": {"volume", "vol", "v"},
+}
+
+var defaultDateLayouts = []string{
+	"2006-01-02",
+	time.RFC3339,
+	"01/02/2006",
+	"20060102",
+}
+
+type Options struct {
+	Format string
+	// Symbol is used for rows that carry no symbol column
+	Symbol string
+	// Mapping overrides the source column of a field, e.g. close -> Adj Close
+	Mapping    map[string]string
+	DateLayout string
+}
+
+type Rejection struct {
+	Line   int
+	Reason string
+}
+
+type Result struct {
+	Prices   []models.Price
+	Rejected []Rejection
+}
+
+// ParseMapping reads a comma separated list of field=column pairs.
+func ParseMapping(s string) (map[string]string, error) {
+	return parseMapping(s, fields)
+}
+
+func parseMapping(s string, known []string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if s == "" {
+		return mapping, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		field, column, ok := strings.Cut(pair, "=")
+		field = strings.ToLower(strings.TrimSpace(field))
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid column mapping: %s", pair)
+		}
+		if !slices.Contains(known, field) {
+			return nil, fmt.Errorf("unknown field in column mapping: %s", field)
+		}
+		mapping[field] = strings.TrimSpace(column)
+	}
+
+	return mapping, nil
+}
+
+// Read parses every row of r into a price. Rows that fail validation are
+// collected in Result.Rejected rather than failing the whole read.
+func Read(r io.Reader, opt Options) (*Result, error) {
+	switch opt.Format {
+	case CSV:
+		return readCsv(r, opt)
+	case JSONL:
+		return readJsonl(r, opt)
+	}
+	return nil, fmt.Errorf("unsupported import format: %s", opt.Format)
+}
+
+func readCsv(r io.Reader, opt Options) (*Result, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := resolveColumns(header, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	line := 1
+	for {
+		record, err := reader.Read()
+		line++
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result.Rejected = append(result.Rejected, Rejection{Line: line, Reason: err.Error()})
+			continue
+		}
+
+		row := map[string]string{}
+		for field, i := range columns {
+			if i < len(record) {
+				row[field] = record[i]
+			}
+		}
+
+		price, err := toPrice(row, opt)
+		if err != nil {
+			result.Rejected = append(result.Rejected, Rejection{Line: line, Reason: err.Error()})
+			continue
+		}
+		result.Prices = append(result.Prices, price)
+	}
+
+	return result, nil
+}
+
+func readJsonl(r io.Reader, opt Options) (*Result, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	result := &Result{}
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		obj := map[string]interface{}{}
+		err := json.Unmarshal([]byte(text), &obj)
+		if err != nil {
+			result.Rejected = append(result.Rejected, Rejection{Line: line, Reason: err.Error()})
+			continue
+		}
+
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		columns, err := resolveColumns(keys, opt)
+		if err != nil {
+			result.Rejected = append(result.Rejected, Rejection{Line: line, Reason: err.Error()})
+			continue
+		}
+
+		row := map[string]string{}
+		for field, i := range columns {
+			switch v := obj[keys[i]].(type) {
+			case string:
+				row[field] = v
+			case float64:
+				row[field] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+
+		price, err := toPrice(row, opt)
+		if err != nil {
+			result.Rejected = append(result.Rejected, Rejection{Line: line, Reason: err.Error()})
+			continue
+		}
+		result.Prices = append(result.Prices, price)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// resolveColumns maps every field to its index in names.
+func resolveColumns(names []string, opt Options) (map[string]int, error) {
+	index := map[string]int{}
+	for i, name := range names {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	columns := map[string]int{}
+	for _, field := range fields {
+		if column, ok := opt.Mapping[field]; ok {
+			i, found := index[strings.ToLower(column)]
+			if !found {
+				return nil, fmt.Errorf("mapped column %s for %s not found", column, field)
+			}
+			columns[field] = i
+			continue
+		}
+		for _, alias := range defaultColumns[field] {
+			if i, found := index[alias]; found {
+				columns[field] = i
+				break
+			}
+		}
+	}
+
+	for _, field := range fields {
+		if _, ok := columns[field]; ok {
+			continue
+		}
+		if field == "symbol" && opt.Symbol != "" {
+			continue
+		}
+		// volume is optional, some exports only carry prices
+		if field == "volume" {
+			continue
+		}
+		return nil, fmt.Errorf("no column found for %s", field)
+	}
+
+	return columns, nil
+}
+
+func toPrice(row map[string]string, opt Options) (models.Price, error) {
+	p := models.Price{}
+
+	symbol := strings.ToUpper(strings.TrimSpace(row["symbol"]))
+	if symbol == "" {
+		symbol = opt.Symbol
+	}
+	if symbol == "" {
+		return p, errors.New("missing symbol")
+	}
+
+	date, err := parseDate(strings.TrimSpace(row["date"]), opt.DateLayout)
+	if err != nil {
+		return p, err
+	}
+
+	values := map[string]float64{}
+	for _, field := range []string{"open", "high", "low", "close", "volume"} {
+		raw := strings.ReplaceAll(strings.TrimSpace(row[field]), ",", "")
+		if raw == "" && field == "volume" {
+			continue
+		}
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return p, fmt.Errorf("invalid %s: %q", field, row[field])
+		}
+		values[field] = f
+	}
+
+	p = models.Price{
+		Symbol: symbol,
+		Date:   primitive.NewDateTimeFromTime(date),
+		Open:   values["open"],
+		High:   values["high"],
+		Low:    values["low"],
+		Close:  values["close"],
+		Volume: values["volume"],
+	}
+
+	return p, validate(p)
+}
+
+func validate(p models.Price) error {
+	if p.Open <= 0 || p.High <= 0 || p.Low <= 0 || p.Close <= 0 {
+		return errors.New("prices must be positive")
+	}
+	if p.Volume < 0 {
+		return errors.New("volume must not be negative")
+	}
+	if p.High < max(p.Open, p.Close, p.Low) {
+		return errors.New("high is below open, close or low")
+	}
+	if p.Low > min(p.Open, p.Close) {
+		return errors.New("low is above open or close")
+	}
+	return nil
+}
+
+// parseDate truncates to the day so imported rows line up with fetched ones.
+func parseDate(s, layout string) (time.Time, error) {
+	layouts := defaultDateLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+
+	for _, l := range layouts {
+		t, err := time.Parse(l, s)
+		if err == nil {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date: %q", s)
+}
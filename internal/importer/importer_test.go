@@ -0,0 +1,84 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadCsv(t *testing.T) {
+	input := `Trade Date,Open,High,Low,Close,Adj Close,Volume
+02/06/2025,231.29,233.80,230.43,233.22,232.97,29925300
+02/07/2025,232.60,234.00,227.26,227.63,227.38,39707200
+02/08/2025,232.60,220.00,227.26,227.63,227.38,39707200
+not a date,232.60,234.00,227.26,227.63,227.38,39707200
+02/10/2025,229.57,230.59,227.20,227.65,227.40,
+`
+	mapping, err := ParseMapping("date=Trade Date")
+	if err != nil {
+		t.Fatalf("error parsing mapping: %v", err)
+	}
+
+	res, err := Read(strings.NewReader(input), Options{
+		Format:     CSV,
+		Symbol:     "AAPL",
+		Mapping:    mapping,
+		DateLayout: "01/02/2006",
+	})
+	if err != nil {
+		t.Fatalf("error reading csv: %v", err)
+	}
+
+	if len(res.Prices) != 3 {
+		t.Fatalf("expected 3 prices, got %d", len(res.Prices))
+	}
+	if len(res.Rejected) != 2 {
+		t.Fatalf("expected 2 rejected rows, got %d", len(res.Rejected))
+	}
+	if res.Rejected[0].Line != 4 || res.Rejected[1].Line != 5 {
+		t.Fatalf("unexpected rejected lines: %+v", res.Rejected)
+	}
+	if res.Prices[2].Symbol != "AAPL" || res.Prices[2].Date.Time().Format("2006-01-02") != "2025-02-10" {
+		t.Fatalf("unexpected price: %+v", res.Prices[2])
+	}
+}
+
+func TestReadJsonl(t *testing.T) {
+	input := `{"ticker":"ibm","date":"2025-02-10","open":255.28,"high":256.93,"low":252.02,"close":252.34,"volume":3370284}
+{"ticker":"IBM","date":"2025-02-11","open":"255.28","high":"256.93","low":"252.02","close":"252.34"}
+{"date":"2025-02-12","open":255.28,"high":256.93,"low":252.02,"close":252.34}
+not json
+`
+	res, err := Read(strings.NewReader(input), Options{Format: JSONL})
+	if err != nil {
+		t.Fatalf("error reading jsonl: %v", err)
+	}
+
+	if len(res.Prices) != 2 {
+		t.Fatalf("expected 2 prices, got %d", len(res.Prices))
+	}
+	if len(res.Rejected) != 2 {
+		t.Fatalf("expected 2 rejected rows, got %d", len(res.Rejected))
+	}
+	if res.Prices[0].Symbol != "IBM" || res.Prices[0].Volume != 3370284 {
+		t.Fatalf("unexpected price: %+v", res.Prices[0])
+	}
+}
+
+func TestParseMapping(t *testing.T) {
+	cases := []struct {
+		input string
+		err   bool
+	}{
+		{input: "", err: false},
+		{input: "date=Trade Date,close=Adj Close", err: false},
+		{input: "date", err: true},
+		{input: "price=Close", err: true},
+	}
+
+	for i, c := range cases {
+		_, err := ParseMapping(c.input)
+		if (err != nil) != c.err {
+			t.Fatalf("Test case %d: expected error: %v, got: %v", i, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package importer
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"github.com/jingen11/stonk-tracker/internal/portfolio"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var tradeFields = []string{"symbol", "date", "action", "quantity", "price", "fees", "amount", "ratio", "id"}
+
+// fields a trade row cannot do without, the others depend on the action
+var requiredTradeFields = []string{"symbol", "date", "action"}
+
+// number of leading rows searched for the header, broker exports often start
+// with an account title
+const maxPreambleRows = 10
+
+// ActionRule maps the broker actions starting with Prefix, compared
+// case-insensitively, to a trade type.
+type ActionRule struct {
+	Prefix string
+	Type   string
+}
+
+// TradePreset describes the trade history export of a broker. A column of
+// Columns may sum several columns joined with +, e.g. fees paid as commission
+// and fees.
+type TradePreset struct {
+	Columns    map[string]string
+	Actions    []ActionRule
+	DateLayout string
+}
+
+const GENERIC_PRESET = "generic"
+
+var TradePresets = map[string]TradePreset{
+	GENERIC_PRESET: {
+		Actions: []ActionRule{
+			{Prefix: "buy", Type: portfolio.BUY},
+			{Prefix: "sell", Type: portfolio.SELL},
+			{Prefix: "dividend", Type: portfolio.DIVIDEND},
+			{Prefix: "split", Type: portfolio.SPLIT},
+		},
+	},
+	"ibkr": {
+		Columns: map[string]string{
+			"symbol":   "Symbol",
+			"date":     "TradeDate",
+			"action":   "Buy/Sell",
+			"quantity": "Quantity",
+			"price":    "TradePrice",
+			"fees":     "IBCommission",
+			"amount":   "NetCash",
+			"id":       "TransactionID",
+		},
+		Actions: []ActionRule{
+			{Prefix: "BUY", Type: portfolio.BUY},
+			{Prefix: "SELL", Type: portfolio.SELL},
+		},
+		DateLayout: "20060102",
+	},
+	"schwab": {
+		Columns: map[string]string{
+			"symbol":   "Symbol",
+			"date":     "Date",
+			"action":   "Action",
+			"quantity": "Quantity",
+			"price":    "Price",
+			"fees":     "Fees & Comm",
+			"amount":   "Amount",
+		},
+		Actions: []ActionRule{
+			{Prefix: "Reinvest Shares", Type: portfolio.BUY},
+			{Prefix: "Buy", Type: portfolio.BUY},
+			{Prefix: "Sell", Type: portfolio.SELL},
+			{Prefix: "Qualified Dividend", Type: portfolio.DIVIDEND},
+			{Prefix: "Cash Dividend", Type: portfolio.DIVIDEND},
+			{Prefix: "Non-Qualified Div", Type: portfolio.DIVIDEND},
+			{Prefix: "Special Dividend", Type: portfolio.DIVIDEND},
+			{Prefix: "Reinvest Dividend", Type: portfolio.DIVIDEND},
+		},
+		DateLayout: "01/02/2006",
+	},
+	"fidelity": {
+		Columns: map[string]string{
+			"symbol":   "Symbol",
+			"date":     "Run Date",
+			"action":   "Action",
+			"quantity": "Quantity",
+			"price":    "Price ($)",
+			"fees":     "Commission ($)+Fees ($)",
+			"amount":   "Amount ($)",
+		},
+		Actions: []ActionRule{
+			{Prefix: "YOU BOUGHT", Type: portfolio.BUY},
+			{Prefix: "REINVESTMENT", Type: portfolio.BUY},
+			{Prefix: "YOU SOLD", Type: portfolio.SELL},
+			{Prefix: "DIVIDEND RECEIVED", Type: portfolio.DIVIDEND},
+		},
+		DateLayout: "01/02/2006",
+	},
+}
+
+// column names of the generic preset, compared case-insensitively
+var defaultTradeColumns = map[string][]string{
+	"symbol":   {"symbol", "ticker"},
+	"date":     {"date", "trade date"},
+	"action":   {"action", "type", "side"},
+	"quantity": {"quantity", "qty", "shares"},
+	"price":    {"price"},
+	"fees":     {"fees", "commission"},
+	"amount":   {"amount"},
+	"ratio":    {"ratio"},
+	"id":       {"id", "transaction id", "trade id"},
+}
+
+type TradeOptions struct {
+	// Preset defaults to the generic one
+	Preset string
+	// Broker is stored with the trades, transaction ids are unique per
+	// broker. It defaults to the preset name.
+	Broker string
+	// Mapping overrides the source columns of the preset
+	Mapping map[string]string
+	// Actions are tried before the rules of the preset
+	Actions    []ActionRule
+	DateLayout string
+}
+
+type TradeResult struct {
+	Trades   []models.Trade
+	Rejected []Rejection
+	// Skipped rows carry an action that is not a trade, e.g. a deposit
+	Skipped []Rejection
+}
+
+// ParseTradeMapping reads a comma separated list of field=column pairs.
+func ParseTradeMapping(s string) (map[string]string, error) {
+	return parseMapping(s, tradeFields)
+}
+
+// ParseActions reads a comma separated list of action=type pairs.
+func ParseActions(s string) ([]ActionRule, error) {
+	rules := []ActionRule{}
+	if s == "" {
+		return rules, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		prefix, tradeType, ok := strings.Cut(pair, "=")
+		tradeType = strings.ToLower(strings.TrimSpace(tradeType))
+		if !ok || strings.TrimSpace(prefix) == "" {
+			return nil, fmt.Errorf("invalid action mapping: %s", pair)
+		}
+		if !slices.Contains([]string{portfolio.BUY, portfolio.SELL, portfolio.DIVIDEND, portfolio.SPLIT}, tradeType) {
+			return nil, fmt.Errorf("unknown trade type in action mapping: %s", tradeType)
+		}
+		rules = append(rules, ActionRule{Prefix: strings.TrimSpace(prefix), Type: tradeType})
+	}
+
+	return rules, nil
+}
+
+// ReadTrades parses a broker trade history CSV into trades, oldest first.
+// Trades without a transaction id get one derived from their content, so that
+// importing the same file again finds them already stored.
+func ReadTrades(r io.Reader, opt TradeOptions) (*TradeResult, error) {
+	if opt.Preset == "" {
+		opt.Preset = GENERIC_PRESET
+	}
+	preset, ok := TradePresets[opt.Preset]
+	if !ok {
+		return nil, fmt.Errorf("unknown trade preset: %s", opt.Preset)
+	}
+	if opt.Broker == "" {
+		opt.Broker = opt.Preset
+	}
+	if opt.DateLayout == "" {
+		opt.DateLayout = preset.DateLayout
+	}
+	mapping := map[string]string{}
+	for field, column := range preset.Columns {
+		mapping[field] = column
+	}
+	for field, column := range opt.Mapping {
+		mapping[field] = column
+	}
+	actions := append(slices.Clone(opt.Actions), preset.Actions...)
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var columns map[string][]int
+	line := 0
+	for columns == nil {
+		header, err := reader.Read()
+		line++
+		if err == io.EOF {
+			return nil, errors.New("no header row found")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var headerErr error
+		columns, headerErr = resolveTradeColumns(header, mapping)
+		if headerErr != nil && line == maxPreambleRows {
+			return nil, headerErr
+		}
+	}
+
+	result := &TradeResult{}
+	// occurrences of identical rows, so that two equal fills keep distinct ids
+	seen := map[string]int{}
+	for {
+		record, err := reader.Read()
+		line++
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result.Rejected = append(result.Rejected, Rejection{Line: line, Reason: err.Error()})
+			continue
+		}
+		if filledFields(record) <= 1 {
+			// blank lines and the disclaimers brokers append
+			continue
+		}
+
+		row := map[string]string{}
+		for field, indexes := range columns {
+			values := []string{}
+			for _, i := range indexes {
+				if i < len(record) {
+					values = append(values, record[i])
+				}
+			}
+			row[field] = strings.Join(values, "+")
+		}
+
+		tradeType := matchAction(row["action"], actions)
+		if tradeType == "" {
+			result.Skipped = append(result.Skipped, Rejection{Line: line, Reason: fmt.Sprintf("unsupported action: %q", strings.TrimSpace(row["action"]))})
+			continue
+		}
+
+		trade, err := toTrade(row, tradeType, opt)
+		if err != nil {
+			result.Rejected = append(result.Rejected, Rejection{Line: line, Reason: err.Error()})
+			continue
+		}
+		if trade.TransactionId == "" {
+			key := contentKey(trade)
+			seen[key]++
+			trade.TransactionId = contentId(key, seen[key])
+		}
+		result.Trades = append(result.Trades, trade)
+	}
+
+	// brokers tend to export newest first, the ledger replays oldest first
+	if len(result.Trades) > 1 && result.Trades[0].Date > result.Trades[len(result.Trades)-1].Date {
+		slices.Reverse(result.Trades)
+	}
+	sort.SliceStable(result.Trades, func(i, j int) bool {
+		return result.Trades[i].Date < result.Trades[j].Date
+	})
+
+	return result, nil
+}
+
+// resolveTradeColumns maps every field to its indexes in header, several for
+// the columns summed with +.
+func resolveTradeColumns(header []string, mapping map[string]string) (map[string][]int, error) {
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	columns := map[string][]int{}
+	for _, field := range tradeFields {
+		if column, ok := mapping[field]; ok {
+			for _, part := range strings.Split(column, "+") {
+				i, found := index[strings.ToLower(strings.TrimSpace(part))]
+				if !found {
+					return nil, fmt.Errorf("mapped column %s for %s not found", part, field)
+				}
+				columns[field] = append(columns[field], i)
+			}
+			continue
+		}
+		for _, alias := range defaultTradeColumns[field] {
+			if i, found := index[alias]; found {
+				columns[field] = []int{i}
+				break
+			}
+		}
+	}
+
+	for _, field := range requiredTradeFields {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("no column found for %s", field)
+		}
+	}
+	return columns, nil
+}
+
+func filledFields(record []string) int {
+	filled := 0
+	for _, f := range record {
+		if strings.TrimSpace(f) != "" {
+			filled++
+		}
+	}
+	return filled
+}
+
+func matchAction(action string, rules []ActionRule) string {
+	action = strings.ToUpper(strings.TrimSpace(action))
+	for _, r := range rules {
+		if strings.HasPrefix(action, strings.ToUpper(r.Prefix)) {
+			return r.Type
+		}
+	}
+	return ""
+}
+
+func toTrade(row map[string]string, tradeType string, opt TradeOptions) (models.Trade, error) {
+	t := models.Trade{
+		Type:          tradeType,
+		Broker:        opt.Broker,
+		TransactionId: strings.TrimSpace(row["id"]),
+	}
+
+	t.Symbol = strings.ToUpper(strings.TrimSpace(row["symbol"]))
+	if t.Symbol == "" {
+		return t, errors.New("missing symbol")
+	}
+
+	date, err := parseTradeDate(strings.TrimSpace(row["date"]), opt.DateLayout)
+	if err != nil {
+		return t, err
+	}
+	t.Date = primitive.NewDateTimeFromTime(date)
+
+	values := map[string]float64{}
+	for _, field := range []string{"quantity", "price", "fees", "amount", "ratio"} {
+		v, err := parseAmount(row[field])
+		if err != nil {
+			return t, fmt.Errorf("invalid %s: %q", field, row[field])
+		}
+		// brokers sign quantities, fees and amounts by cash flow, the trade
+		// type carries the direction
+		values[field] = math.Abs(v)
+	}
+
+	switch tradeType {
+	case portfolio.BUY, portfolio.SELL:
+		t.Quantity = values["quantity"]
+		t.Price = values["price"]
+		t.Fees = values["fees"]
+	case portfolio.DIVIDEND:
+		t.Amount = values["amount"]
+	case portfolio.SPLIT:
+		t.Ratio = values["ratio"]
+		if t.Ratio == 0 {
+			return t, errors.New("split without a ratio, record it with trade split")
+		}
+	}
+
+	return t, portfolio.Validate(t)
+}
+
+// parseTradeDate also reads dates followed by a note, e.g. "01/02/2025 as of
+// 12/31/2024".
+func parseTradeDate(s, layout string) (time.Time, error) {
+	t, err := parseDate(s, layout)
+	if err == nil {
+		return t, nil
+	}
+	if first, _, found := strings.Cut(s, " "); found {
+		return parseDate(first, layout)
+	}
+	return t, err
+}
+
+// parseAmount reads numbers as brokers write them: $1,234.56, (12.50) for a
+// negative amount, empty for zero.
+func parseAmount(s string) (float64, error) {
+	total := 0.0
+	for _, part := range strings.Split(s, "+") {
+		part = strings.TrimSpace(part)
+		negative := strings.HasPrefix(part, "(") && strings.HasSuffix(part, ")")
+		part = strings.NewReplacer("$", "", ",", "", "(", "", ")", "", " ", "").Replace(part)
+		if part == "" || part == "-" {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return 0, fmt.Errorf("invalid amount: %q", s)
+		}
+		if negative {
+			v = -v
+		}
+		total += v
+	}
+	return total, nil
+}
+
+func contentKey(t models.Trade) string {
+	return fmt.Sprintf("%s|%s|%d|%s|%g|%g|%g|%g|%g", t.Broker, t.Symbol, t.Date, t.Type, t.Quantity, t.Price, t.Fees, t.Amount, t.Ratio)
+}
+
+// contentId derives a transaction id from the n-th trade of the file with key.
+func contentId(key string, n int) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d", key, n)))
+	return "row-" + hex.EncodeToString(sum[:8])
+}
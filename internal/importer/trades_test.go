@@ -0,0 +1,138 @@
+package importer
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jingen11/stonk-tracker/internal/portfolio"
+)
+
+func TestReadTrades(t *testing.T) {
+	cases := []struct {
+		input    string
+		opt      TradeOptions
+		expected []string
+		rejected int
+		skipped  int
+	}{
+		{
+			input: `Symbol,TradeDate,Buy/Sell,Quantity,TradePrice,IBCommission,NetCash,TransactionID
+AAPL,20250203,BUY,10,228.01,-1,-2281.1,1001
+AAPL,20250210,SELL,-4,227.65,-1.02,909.58,1002
+MSFT,2025-02-11,BUY,1,410,-1,-411,1003
+`,
+			opt:      TradeOptions{Preset: "ibkr"},
+			expected: []string{"AAPL buy 10 228.01 1 1001", "AAPL sell 4 227.65 1.02 1002"},
+			rejected: 1,
+		},
+		{
+			input: `"Transactions  for account ...XXXX as of 02/14/2025"
+"Date","Action","Symbol","Description","Quantity","Price","Fees & Comm","Amount"
+"02/12/2025","Qualified Dividend","AAPL","APPLE INC","","","","$2.50"
+"02/10/2025 as of 02/07/2025","Sell","AAPL","APPLE INC","4","$227.65","$0.02","$910.58"
+"02/03/2025","Buy","AAPL","APPLE INC","10","$228.01","","-$2,280.10"
+"02/01/2025","MoneyLink Transfer","","Tfr BANK","","","","$5,000.00"
+"Transactions Total","","","","","","","$3,633.98"
+`,
+			opt:      TradeOptions{Preset: "schwab"},
+			expected: []string{"AAPL buy 10 228.01 0 ", "AAPL sell 4 227.65 0.02 ", "AAPL dividend 2.5  "},
+			skipped:  2,
+		},
+		{
+			input: `
+Run Date,Action,Symbol,Description,Type,Quantity,Price ($),Commission ($),Fees ($),Amount ($)
+02/10/2025,YOU SOLD APPLE INC (AAPL) (Cash),AAPL,APPLE INC,Cash,-4,227.65,,0.02,910.56
+02/03/2025,YOU BOUGHT APPLE INC (AAPL) (Cash),AAPL,APPLE INC,Cash,10,228.01,1,,-2281.10
+
+"The data and information in this spreadsheet is provided to you solely for your use"
+`,
+			opt:      TradeOptions{Preset: "fidelity"},
+			expected: []string{"AAPL buy 10 228.01 1 ", "AAPL sell 4 227.65 0.02 "},
+		},
+		{
+			input: `ticker,date,side,qty,price,fee,ref
+ibm,2025-02-10,Bought,2,250,1.5,x1
+ibm,2025-02-10,Bought,2,250,1.5,
+ibm,2025-02-11,split,,,,
+`,
+			opt: TradeOptions{
+				Mapping: map[string]string{"fees": "fee", "id": "ref"},
+				Actions: []ActionRule{{Prefix: "bought", Type: portfolio.BUY}},
+			},
+			expected: []string{"IBM buy 2 250 1.5 x1", "IBM buy 2 250 1.5 "},
+			rejected: 1,
+		},
+	}
+
+	for i, c := range cases {
+		res, err := ReadTrades(strings.NewReader(c.input), c.opt)
+		if err != nil {
+			t.Fatalf("Test case %d: error reading trades: %v", i, err)
+		}
+		if len(res.Rejected) != c.rejected || len(res.Skipped) != c.skipped {
+			t.Fatalf("Test case %d: expected %d rejected and %d skipped, got %+v and %+v", i, c.rejected, c.skipped, res.Rejected, res.Skipped)
+		}
+		if len(res.Trades) != len(c.expected) {
+			t.Fatalf("Test case %d: expected %d trades, got %+v", i, len(c.expected), res.Trades)
+		}
+
+		for j, tr := range res.Trades {
+			if tr.TransactionId == "" || tr.Broker == "" {
+				t.Fatalf("Test case %d: expected a broker and transaction id, got %+v", i, tr)
+			}
+			id := tr.TransactionId
+			if strings.HasPrefix(id, "row-") {
+				id = ""
+			}
+			got := strings.Join([]string{tr.Symbol, tr.Type, ftoa(tr.Quantity + tr.Amount), ftoa(tr.Price), ftoa(tr.Fees), id}, " ")
+			if tr.Type == portfolio.DIVIDEND {
+				got = strings.Join([]string{tr.Symbol, tr.Type, ftoa(tr.Amount), "", id}, " ")
+			}
+			if got != c.expected[j] {
+				t.Fatalf("Test case %d: expected %q at %d, got %q", i, c.expected[j], j, got)
+			}
+		}
+	}
+}
+
+func TestReadTradesContentIds(t *testing.T) {
+	input := `symbol,date,action,quantity,price
+IBM,2025-02-10,buy,2,250
+IBM,2025-02-10,buy,2,250
+`
+	first, _ := ReadTrades(strings.NewReader(input), TradeOptions{})
+	second, _ := ReadTrades(strings.NewReader(input), TradeOptions{})
+
+	if first.Trades[0].TransactionId == first.Trades[1].TransactionId {
+		t.Fatalf("expected equal fills to get distinct ids, got %+v", first.Trades)
+	}
+	for i := range first.Trades {
+		if first.Trades[i].TransactionId != second.Trades[i].TransactionId {
+			t.Fatalf("Test case %d: expected the same id on every read, got %s and %s", i, first.Trades[i].TransactionId, second.Trades[i].TransactionId)
+		}
+	}
+}
+
+func TestParseActions(t *testing.T) {
+	cases := []struct {
+		input string
+		err   bool
+	}{
+		{input: "", err: false},
+		{input: "Bought=buy,Sold=SELL", err: false},
+		{input: "Bought", err: true},
+		{input: "Transfer=deposit", err: true},
+	}
+
+	for i, c := range cases {
+		_, err := ParseActions(c.input)
+		if (err != nil) != c.err {
+			t.Fatalf("Test case %d: expected error %v, got %v", i, c.err, err)
+		}
+	}
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
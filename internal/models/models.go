@@ -19,6 +19,19 @@ type Symbol struct {
 	Id              primitive.ObjectID `bson:"_id,omitempty"`
 	Symbol          string             `bson:"symbol"`
 	LastFetchedDate primitive.DateTime `bson:"lastFetchedDate"`
+	// Archived symbols keep their prices but are no longer refreshed
+	Archived bool `bson:"archived,omitempty"`
+	// Aliases are the previous tickers of the symbol
+	Aliases []string `bson:"aliases,omitempty"`
+	Tags    []string `bson:"tags,omitempty"`
+	// Watchlists are the names of the watchlists the symbol is in
+	Watchlists []string `bson:"watchlists,omitempty"`
+}
+
+type Watchlist struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
+	Name      string             `bson:"name"`
+	CreatedAt primitive.DateTime `bson:"createdAt"`
 }
 
 type Price struct {
@@ -34,4 +47,52 @@ type Price struct {
 	PreMarket  float64            `bson:"preMarket"`
 	HAOpen     float64            `bson:"haOpen,omitempty"`
 	HAClose    float64            `bson:"haClose,omitempty"`
+	HAHigh     float64            `bson:"haHigh,omitempty"`
+	HALow      float64            `bson:"haLow,omitempty"`
+}
+
+// Trade is an entry of the trade ledger. Buys and sells move Quantity shares
+// at Price, dividends pay Amount in cash and splits multiply the held shares
+// by Ratio.
+type Trade struct {
+	Id       primitive.ObjectID `bson:"_id,omitempty"`
+	Symbol   string             `bson:"symbol"`
+	Type     string             `bson:"type"`
+	Date     primitive.DateTime `bson:"date"`
+	Quantity float64            `bson:"quantity,omitempty"`
+	Price    float64            `bson:"price,omitempty"`
+	Fees     float64            `bson:"fees,omitempty"`
+	Amount   float64            `bson:"amount,omitempty"`
+	Ratio    float64            `bson:"ratio,omitempty"`
+	Note     string             `bson:"note,omitempty"`
+	// Broker and TransactionId identify imported trades, an import skips the
+	// transactions already recorded for the broker
+	Broker        string `bson:"broker,omitempty"`
+	TransactionId string `bson:"transactionId,omitempty"`
+	// Lots are the ids of the buys a sell disposes of, the lots it is matched
+	// against with the specific lot method
+	Lots []primitive.ObjectID `bson:"lots,omitempty"`
+}
+
+// Signal is an evaluation of the heikin ashi candle of a bar by the info
+// command, kept to audit what was reported on a given day.
+type Signal struct {
+	Id     primitive.ObjectID `bson:"_id,omitempty"`
+	Symbol string             `bson:"symbol"`
+	// Date is the date of the bar evaluated
+	Date        primitive.DateTime `bson:"date"`
+	HAOpen      float64            `bson:"haOpen"`
+	HAHigh      float64            `bson:"haHigh"`
+	HALow       float64            `bson:"haLow"`
+	HAClose     float64            `bson:"haClose"`
+	Uptrend     bool               `bson:"uptrend"`
+	Bull        bool               `bson:"bull"`
+	Bear        bool               `bson:"bear"`
+	SpinningTop bool               `bson:"spinningTop"`
+	Doji        bool               `bson:"doji"`
+	Gravestone  bool               `bson:"gravestone"`
+	Sentiment   string             `bson:"sentiment"`
+	// RuleVersion is the calculation.RULE_VERSION the signal was evaluated with
+	RuleVersion int                `bson:"ruleVersion"`
+	EvaluatedAt primitive.DateTime `bson:"evaluatedAt"`
 }
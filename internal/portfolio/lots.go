@@ -0,0 +1,472 @@
+package portfolio
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// lot selection methods of MatchLots
+const (
+	FIFO         = "fifo"
+	LIFO         = "lifo"
+	HIGHEST_COST = "hifo"
+	// SPECIFIC sells the lots named by the sell, FIFO when it names none
+	SPECIFIC = "specific"
+)
+
+var LotMethods = []string{FIFO, LIFO, HIGHEST_COST, SPECIFIC}
+
+// days on either side of a sale at a loss in which buying the symbol again
+// makes it a wash sale
+const washSaleDays = 30
+
+// Lot is what is left of a buy. A lot is split in two when only part of it
+// replaces shares sold in a wash sale, both parts keep the buy id.
+type Lot struct {
+	// Id is the id of the buy trade
+	Id       primitive.ObjectID
+	Symbol   string
+	Acquired time.Time
+	// HoldingStart is when the holding period starts, before Acquired when
+	// the lot replaces shares sold in a wash sale: their holding period
+	// carries over
+	HoldingStart time.Time
+	Quantity     float64
+	// Cost is the basis of Quantity, fees and wash sale adjustments included
+	Cost float64
+	// WashSaleAdjustment is the part of Cost added by wash sales
+	WashSaleAdjustment float64
+}
+
+func (l Lot) CostPerShare() float64 {
+	if l.Quantity == 0 {
+		return 0
+	}
+	return l.Cost / l.Quantity
+}
+
+// take splits quantity off the lot, its cost and adjustment follow pro rata.
+func (l *Lot) take(quantity float64) Lot {
+	part := *l
+	part.Quantity = quantity
+	part.Cost = l.Cost * quantity / l.Quantity
+	part.WashSaleAdjustment = l.WashSaleAdjustment * quantity / l.Quantity
+
+	l.Quantity -= quantity
+	l.Cost -= part.Cost
+	l.WashSaleAdjustment -= part.WashSaleAdjustment
+	return part
+}
+
+// carryHoldingPeriod moves the start of the holding period back by held, the
+// holding period of the shares the lot replaces.
+func (l *Lot) carryHoldingPeriod(held time.Duration) {
+	start := l.Acquired.Add(-held)
+	if start.Before(l.HoldingStart) {
+		l.HoldingStart = start
+	}
+}
+
+// Disposal is the part of a sell matched against one lot.
+type Disposal struct {
+	Symbol   string
+	LotId    primitive.ObjectID
+	SellId   primitive.ObjectID
+	Acquired time.Time
+	// HoldingStart is the HoldingStart of the lot, Acquired when zero
+	HoldingStart time.Time
+	Sold         time.Time
+	Quantity     float64
+	// Proceeds are net of the sell fees
+	Proceeds float64
+	Cost     float64
+	// WashSale is set on losses disallowed by a buy within 30 days,
+	// Adjustment is the disallowed part of the loss
+	WashSale   bool
+	Adjustment float64
+}
+
+// Gain is the realised gain, before wash sale adjustments.
+func (d Disposal) Gain() float64 {
+	return d.Proceeds - d.Cost
+}
+
+// ReportedGain is the gain once the disallowed loss is added back.
+func (d Disposal) ReportedGain() float64 {
+	return d.Gain() + d.Adjustment
+}
+
+// HeldSince is the start of the holding period of the shares sold.
+func (d Disposal) HeldSince() time.Time {
+	if d.HoldingStart.IsZero() {
+		return d.Acquired
+	}
+	return d.HoldingStart
+}
+
+// LongTerm reports whether the shares were held for more than a year.
+func (d Disposal) LongTerm() bool {
+	return d.Sold.After(d.HeldSince().AddDate(1, 0, 0))
+}
+
+func (d Disposal) Term() string {
+	if d.LongTerm() {
+		return "long"
+	}
+	return "short"
+}
+
+type LotResult struct {
+	// Lots still held, by symbol in acquisition order
+	Lots      []Lot
+	Disposals []Disposal
+}
+
+// washAdjustment is a disallowed loss waiting for the buy replacing the
+// shares sold.
+type washAdjustment struct {
+	quantity   float64
+	adjustment float64
+	// held is the holding period of the shares sold
+	held time.Duration
+}
+
+type lotMatcher struct {
+	method string
+	lots   map[string][]Lot
+	// buys by symbol, to look for wash sale replacements
+	buys map[string][]models.Trade
+	// shares of each buy not used as a wash sale replacement yet
+	replaceable map[primitive.ObjectID]float64
+	// buys replayed so far
+	bought map[primitive.ObjectID]bool
+	// adjustments for buys after the sale they replace
+	pending   map[primitive.ObjectID][]washAdjustment
+	disposals []Disposal
+}
+
+// MatchLots replays trades, oldest first, matching every sell against the
+// lots bought before it with method. Sales at a loss are flagged as wash
+// sales when the symbol is bought within 30 days before or after them: the
+// disallowed loss is reported as an adjustment and added to the cost of the
+// replacing lot.
+func MatchLots(trades []models.Trade, method string) (*LotResult, error) {
+	if !slices.Contains(LotMethods, method) {
+		return nil, errors.New(fmt.Sprintf("unknown lot method: %s", method))
+	}
+
+	m := &lotMatcher{
+		method:      method,
+		lots:        map[string][]Lot{},
+		buys:        map[string][]models.Trade{},
+		replaceable: map[primitive.ObjectID]float64{},
+		bought:      map[primitive.ObjectID]bool{},
+		pending:     map[primitive.ObjectID][]washAdjustment{},
+	}
+	for _, t := range trades {
+		if t.Type == BUY {
+			m.buys[t.Symbol] = append(m.buys[t.Symbol], t)
+			m.replaceable[t.Id] = t.Quantity
+		}
+	}
+
+	for _, t := range trades {
+		var err error
+		switch t.Type {
+		case BUY:
+			m.buy(t)
+		case SELL:
+			err = m.sell(t)
+		case SPLIT:
+			m.split(t)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &LotResult{Disposals: m.disposals}
+	symbols := make([]string, 0, len(m.lots))
+	for symbol := range m.lots {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		result.Lots = append(result.Lots, m.lots[symbol]...)
+	}
+	return result, nil
+}
+
+func (m *lotMatcher) buy(t models.Trade) {
+	acquired := t.Date.Time().UTC()
+	lot := Lot{
+		Id:           t.Id,
+		Symbol:       t.Symbol,
+		Acquired:     acquired,
+		HoldingStart: acquired,
+		Quantity:     t.Quantity,
+		Cost:         t.Quantity*t.Price + t.Fees,
+	}
+	m.bought[t.Id] = true
+
+	for _, adj := range m.pending[t.Id] {
+		part := lot.take(min(adj.quantity, lot.Quantity))
+		part.Cost += adj.adjustment
+		part.WashSaleAdjustment += adj.adjustment
+		part.carryHoldingPeriod(adj.held)
+		m.lots[t.Symbol] = append(m.lots[t.Symbol], part)
+	}
+	delete(m.pending, t.Id)
+
+	if lot.Quantity > quantityEpsilon {
+		m.lots[t.Symbol] = append(m.lots[t.Symbol], lot)
+	}
+}
+
+func (m *lotMatcher) sell(t models.Trade) error {
+	lots := m.lots[t.Symbol]
+	order, err := m.lotOrder(t, lots)
+	if err != nil {
+		return err
+	}
+
+	sold := t.Date.Time().UTC()
+	proceeds := t.Quantity*t.Price - t.Fees
+	left := t.Quantity
+	disposals := []Disposal{}
+	for _, i := range order {
+		if left <= quantityEpsilon {
+			break
+		}
+		quantity := min(left, lots[i].Quantity)
+		part := lots[i].take(quantity)
+		left -= quantity
+
+		disposals = append(disposals, Disposal{
+			Symbol:       t.Symbol,
+			LotId:        part.Id,
+			SellId:       t.Id,
+			Acquired:     part.Acquired,
+			HoldingStart: part.HoldingStart,
+			Sold:         sold,
+			Quantity:     quantity,
+			Proceeds:     proceeds * quantity / t.Quantity,
+			Cost:         part.Cost,
+		})
+	}
+	if left > quantityEpsilon {
+		return errors.New(fmt.Sprintf("%s: selling %g shares on %s but only %g held in the matched lots",
+			t.Symbol, t.Quantity, sold.Format("2006-01-02"), t.Quantity-left))
+	}
+
+	open := lots[:0]
+	for _, l := range lots {
+		if l.Quantity > quantityEpsilon {
+			open = append(open, l)
+		}
+	}
+	m.lots[t.Symbol] = open
+
+	// shares sold together do not replace each other
+	selling := map[primitive.ObjectID]bool{}
+	for _, d := range disposals {
+		selling[d.LotId] = true
+	}
+	for i := range disposals {
+		if disposals[i].Gain() < 0 {
+			m.washSale(&disposals[i], selling)
+		}
+	}
+	m.disposals = append(m.disposals, disposals...)
+	return nil
+}
+
+// split multiplies the shares of t.Symbol held, the shares of buys already
+// replayed that may still replace a wash sale, and those waiting for the
+// adjustment of a later buy.
+func (m *lotMatcher) split(t models.Trade) {
+	for i := range m.lots[t.Symbol] {
+		m.lots[t.Symbol][i].Quantity *= t.Ratio
+	}
+	for _, b := range m.buys[t.Symbol] {
+		if m.bought[b.Id] {
+			m.replaceable[b.Id] *= t.Ratio
+		}
+		for i := range m.pending[b.Id] {
+			m.pending[b.Id][i].quantity *= t.Ratio
+		}
+	}
+}
+
+// lotOrder returns the indexes of lots in the order the sell t consumes them.
+func (m *lotMatcher) lotOrder(t models.Trade, lots []Lot) ([]int, error) {
+	order := make([]int, len(lots))
+	for i := range order {
+		order[i] = i
+	}
+
+	switch m.method {
+	case LIFO:
+		slices.Reverse(order)
+	case HIGHEST_COST:
+		sort.SliceStable(order, func(a, b int) bool {
+			return lots[order[a]].CostPerShare() > lots[order[b]].CostPerShare()
+		})
+	case SPECIFIC:
+		if len(t.Lots) == 0 {
+			break
+		}
+		named := []int{}
+		for _, id := range t.Lots {
+			found := false
+			for i, l := range lots {
+				if l.Id == id {
+					named = append(named, i)
+					found = true
+				}
+			}
+			if !found {
+				return nil, errors.New(fmt.Sprintf("%s: lot %s sold on %s is not held",
+					t.Symbol, id.Hex(), t.Date.Time().UTC().Format("2006-01-02")))
+			}
+		}
+		// only the named lots may be sold
+		return named, nil
+	}
+	return order, nil
+}
+
+// washSale flags d when the symbol was bought within washSaleDays of the
+// sale, by other lots than the ones sold with it, and moves the disallowed
+// loss and the holding period of d to the replacing shares. Buys already replayed only replace the shares
+// still held.
+func (m *lotMatcher) washSale(d *Disposal, sold map[primitive.ObjectID]bool) {
+	from := d.Sold.AddDate(0, 0, -washSaleDays)
+	to := d.Sold.AddDate(0, 0, washSaleDays)
+	loss := -d.Gain()
+	held := d.Sold.Sub(d.HeldSince())
+
+	left := d.Quantity
+	for _, b := range m.buys[d.Symbol] {
+		date := b.Date.Time().UTC()
+		if sold[b.Id] || date.Before(from) || date.After(to) {
+			continue
+		}
+
+		replaceable := m.replaceable[b.Id]
+		if m.bought[b.Id] {
+			held := 0.0
+			for _, l := range m.lots[d.Symbol] {
+				if l.Id == b.Id {
+					held += l.Quantity
+				}
+			}
+			replaceable = min(replaceable, held)
+		}
+		if replaceable <= quantityEpsilon {
+			continue
+		}
+
+		replaced := min(left, replaceable)
+		m.replaceable[b.Id] -= replaced
+		left -= replaced
+
+		adjustment := loss * replaced / d.Quantity
+		d.WashSale = true
+		d.Adjustment += adjustment
+		m.adjust(b, replaced, adjustment, held)
+
+		if left <= quantityEpsilon {
+			return
+		}
+	}
+}
+
+// adjust adds a disallowed loss and the holding period held to quantity
+// shares of the buy b, now when it was replayed and once it is otherwise.
+func (m *lotMatcher) adjust(b models.Trade, quantity float64, adjustment float64, held time.Duration) {
+	if !m.bought[b.Id] {
+		m.pending[b.Id] = append(m.pending[b.Id], washAdjustment{quantity: quantity, adjustment: adjustment, held: held})
+		return
+	}
+
+	lots := m.lots[b.Symbol]
+	left := quantity
+	for i := 0; i < len(lots) && left > quantityEpsilon; i++ {
+		if lots[i].Id != b.Id {
+			continue
+		}
+		if lots[i].Quantity > left+quantityEpsilon {
+			part := lots[i].take(left)
+			lots = slices.Insert(lots, i, part)
+		}
+		share := adjustment * lots[i].Quantity / quantity
+		lots[i].Cost += share
+		lots[i].WashSaleAdjustment += share
+		lots[i].carryHoldingPeriod(held)
+		left -= lots[i].Quantity
+	}
+	m.lots[b.Symbol] = lots
+}
+
+type GainsSummary struct {
+	ShortTerm float64
+	LongTerm  float64
+	// Disallowed is the loss disallowed by wash sales, already added back to
+	// ShortTerm and LongTerm
+	Disallowed float64
+}
+
+// Summarise sums the reported gains of disposals by holding period.
+func Summarise(disposals []Disposal) GainsSummary {
+	s := GainsSummary{}
+	for _, d := range disposals {
+		if d.LongTerm() {
+			s.LongTerm += d.ReportedGain()
+		} else {
+			s.ShortTerm += d.ReportedGain()
+		}
+		s.Disallowed += d.Adjustment
+	}
+	return s
+}
+
+var disposalsCsvHeader = []string{"symbol", "quantity", "acquired", "sold", "proceeds", "cost", "adjustment", "gain", "term", "wash_sale", "lot", "sell", "held_since"}
+
+// WriteDisposalsCsv writes one row per disposal, the columns of a realised
+// gains report.
+func WriteDisposalsCsv(w io.Writer, disposals []Disposal) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(disposalsCsvHeader)
+	if err != nil {
+		return err
+	}
+
+	money := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	}
+	for _, d := range disposals {
+		err := writer.Write([]string{
+			d.Symbol, strconv.FormatFloat(d.Quantity, 'f', -1, 64),
+			d.Acquired.Format("2006-01-02"), d.Sold.Format("2006-01-02"),
+			money(d.Proceeds), money(d.Cost), money(d.Adjustment), money(d.ReportedGain()),
+			d.Term(), strconv.FormatBool(d.WashSale), d.LotId.Hex(), d.SellId.Hex(),
+			d.HeldSince().Format("2006-01-02"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
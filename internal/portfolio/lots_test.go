@@ -0,0 +1,261 @@
+package portfolio
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// withIds gives trades the ids a store would, lots are told apart by them.
+func withIds(trades ...models.Trade) []models.Trade {
+	for i := range trades {
+		trades[i].Id = primitive.NewObjectID()
+	}
+	return trades
+}
+
+type expectedDisposal struct {
+	lot        int
+	quantity   float64
+	gain       float64
+	adjustment float64
+	longTerm   bool
+}
+
+func checkDisposals(t *testing.T, i int, trades []models.Trade, disposals []Disposal, expected []expectedDisposal) {
+	t.Helper()
+	if len(disposals) != len(expected) {
+		t.Fatalf("Test case %d: expected %d disposals, got %+v", i, len(expected), disposals)
+	}
+	for j, e := range expected {
+		d := disposals[j]
+		if d.LotId != trades[e.lot].Id || !near(d.Quantity, e.quantity) || !near(d.Gain(), e.gain) ||
+			!near(d.Adjustment, e.adjustment) || d.WashSale != (e.adjustment != 0) || d.LongTerm() != e.longTerm {
+			t.Fatalf("Test case %d: expected %+v at %d, got %+v with gain %g", i, e, j, d, d.Gain())
+		}
+	}
+}
+
+func TestMatchLots(t *testing.T) {
+	trades := withIds(
+		trade("IBM", BUY, "2023-01-03", 10, 100),
+		trade("IBM", BUY, "2024-06-03", 10, 150),
+		trade("IBM", BUY, "2024-09-03", 10, 120),
+		trade("IBM", SELL, "2025-01-10", 15, 130),
+	)
+	specific := append([]models.Trade{}, trades...)
+	specific[3].Lots = []primitive.ObjectID{trades[2].Id, trades[0].Id}
+
+	cases := []struct {
+		method   string
+		trades   []models.Trade
+		expected []expectedDisposal
+	}{
+		{
+			method: FIFO, trades: trades,
+			expected: []expectedDisposal{{lot: 0, quantity: 10, gain: 300, longTerm: true}, {lot: 1, quantity: 5, gain: -100}},
+		},
+		{
+			method: LIFO, trades: trades,
+			expected: []expectedDisposal{{lot: 2, quantity: 10, gain: 100}, {lot: 1, quantity: 5, gain: -100}},
+		},
+		{
+			method: HIGHEST_COST, trades: trades,
+			expected: []expectedDisposal{{lot: 1, quantity: 10, gain: -200}, {lot: 2, quantity: 5, gain: 50}},
+		},
+		{
+			method: SPECIFIC, trades: specific,
+			expected: []expectedDisposal{{lot: 2, quantity: 10, gain: 100}, {lot: 0, quantity: 5, gain: 150, longTerm: true}},
+		},
+		{
+			method: SPECIFIC, trades: trades,
+			expected: []expectedDisposal{{lot: 0, quantity: 10, gain: 300, longTerm: true}, {lot: 1, quantity: 5, gain: -100}},
+		},
+	}
+
+	for i, c := range cases {
+		result, err := MatchLots(c.trades, c.method)
+		if err != nil {
+			t.Fatalf("Test case %d: error matching lots: %v", i, err)
+		}
+		checkDisposals(t, i, c.trades, result.Disposals, c.expected)
+
+		held := 0.0
+		for _, l := range result.Lots {
+			held += l.Quantity
+		}
+		if !near(held, 15) {
+			t.Fatalf("Test case %d: expected 15 shares left in lots, got %+v", i, result.Lots)
+		}
+	}
+
+	if _, err := MatchLots(trades, "average"); err == nil {
+		t.Fatalf("expected an error on an unknown method")
+	}
+	oversold := append([]models.Trade{}, specific...)
+	oversold[3].Lots = oversold[3].Lots[:1]
+	if _, err := MatchLots(oversold, SPECIFIC); err == nil {
+		t.Fatalf("expected an error selling more than the named lots hold")
+	}
+}
+
+func TestMatchLotsWashSale(t *testing.T) {
+	cases := []struct {
+		trades   []models.Trade
+		expected []expectedDisposal
+		// cost of the lots left
+		lots []float64
+	}{
+		{
+			// bought back after the sale, the disallowed loss moves to the new lot
+			trades: withIds(
+				trade("IBM", BUY, "2025-01-02", 10, 100),
+				trade("IBM", SELL, "2025-02-03", 10, 80),
+				trade("IBM", BUY, "2025-02-20", 6, 85),
+			),
+			expected: []expectedDisposal{{lot: 0, quantity: 10, gain: -200, adjustment: 120}},
+			lots:     []float64{630},
+		},
+		{
+			// bought before the sale, only part of the lot replaces the shares sold
+			trades: withIds(
+				trade("IBM", BUY, "2025-01-02", 10, 100),
+				trade("IBM", BUY, "2025-01-20", 20, 90),
+				trade("IBM", SELL, "2025-02-03", 10, 80),
+			),
+			expected: []expectedDisposal{{lot: 0, quantity: 10, gain: -200, adjustment: 200}},
+			lots:     []float64{1100, 900},
+		},
+		{
+			// outside of the 30 days
+			trades: withIds(
+				trade("IBM", BUY, "2025-01-02", 10, 100),
+				trade("IBM", SELL, "2025-02-03", 10, 80),
+				trade("IBM", BUY, "2025-03-06", 10, 85),
+			),
+			expected: []expectedDisposal{{lot: 0, quantity: 10, gain: -200}},
+			lots:     []float64{850},
+		},
+		{
+			// the adjusted lot sold later carries the disallowed loss
+			trades: withIds(
+				trade("IBM", BUY, "2025-01-02", 10, 100),
+				trade("IBM", SELL, "2025-02-03", 10, 80),
+				trade("IBM", BUY, "2025-02-20", 6, 85),
+				trade("IBM", SELL, "2025-06-02", 6, 100),
+			),
+			expected: []expectedDisposal{{lot: 0, quantity: 10, gain: -200, adjustment: 120}, {lot: 2, quantity: 6, gain: -30}},
+			lots:     []float64{},
+		},
+		{
+			// the replacing lot bought back later keeps the holding period of the shares sold
+			trades: withIds(
+				trade("IBM", BUY, "2024-01-02", 10, 100),
+				trade("IBM", SELL, "2024-12-02", 10, 80),
+				trade("IBM", BUY, "2024-12-20", 10, 85),
+				trade("IBM", SELL, "2025-02-03", 10, 90),
+			),
+			expected: []expectedDisposal{{lot: 0, quantity: 10, gain: -200, adjustment: 200}, {lot: 2, quantity: 10, gain: -150, longTerm: true}},
+			lots:     []float64{},
+		},
+		{
+			// and so does the one bought before the sale
+			trades: withIds(
+				trade("IBM", BUY, "2024-01-02", 10, 100),
+				trade("IBM", BUY, "2024-11-20", 10, 90),
+				trade("IBM", SELL, "2024-12-02", 10, 80),
+				trade("IBM", SELL, "2025-01-10", 10, 95),
+			),
+			expected: []expectedDisposal{{lot: 0, quantity: 10, gain: -200, adjustment: 200}, {lot: 1, quantity: 10, gain: -150, longTerm: true}},
+			lots:     []float64{},
+		},
+		{
+			// a split between the sale and the buy replacing it scales the shares replaced
+			trades: withIds(
+				trade("IBM", BUY, "2025-01-02", 10, 100),
+				trade("IBM", SELL, "2025-02-03", 10, 80),
+				trade("IBM", SPLIT, "2025-02-10", 2, 0),
+				trade("IBM", BUY, "2025-02-20", 20, 42.5),
+			),
+			expected: []expectedDisposal{{lot: 0, quantity: 10, gain: -200, adjustment: 200}},
+			lots:     []float64{1050},
+		},
+		{
+			// a split between the buy and the sale it replaces scales the shares it may replace
+			trades: withIds(
+				trade("IBM", BUY, "2025-01-02", 10, 100),
+				trade("IBM", BUY, "2025-01-20", 10, 90),
+				trade("IBM", SPLIT, "2025-01-27", 2, 0),
+				trade("IBM", SELL, "2025-02-03", 20, 40),
+			),
+			expected: []expectedDisposal{{lot: 0, quantity: 20, gain: -200, adjustment: 200}},
+			lots:     []float64{1100},
+		},
+	}
+
+	for i, c := range cases {
+		result, err := MatchLots(c.trades, FIFO)
+		if err != nil {
+			t.Fatalf("Test case %d: error matching lots: %v", i, err)
+		}
+		checkDisposals(t, i, c.trades, result.Disposals, c.expected)
+
+		if len(result.Lots) != len(c.lots) {
+			t.Fatalf("Test case %d: expected lots costing %v, got %+v", i, c.lots, result.Lots)
+		}
+		for j, cost := range c.lots {
+			if !near(result.Lots[j].Cost, cost) {
+				t.Fatalf("Test case %d: expected lots costing %v, got %+v", i, c.lots, result.Lots)
+			}
+		}
+	}
+}
+
+func TestMatchLotsSplit(t *testing.T) {
+	trades := withIds(
+		trade("IBM", BUY, "2025-01-02", 10, 100),
+		trade("IBM", SPLIT, "2025-01-03", 4, 0),
+		trade("IBM", SELL, "2025-01-06", 20, 30),
+	)
+	result, err := MatchLots(trades, FIFO)
+	if err != nil {
+		t.Fatalf("error matching lots: %v", err)
+	}
+	checkDisposals(t, 0, trades, result.Disposals, []expectedDisposal{{lot: 0, quantity: 20, gain: 100}})
+	if len(result.Lots) != 1 || !near(result.Lots[0].Quantity, 20) || !near(result.Lots[0].CostPerShare(), 25) {
+		t.Fatalf("expected 20 shares left at 25, got %+v", result.Lots)
+	}
+}
+
+func TestWriteDisposalsCsv(t *testing.T) {
+	acquired := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	disposals := []Disposal{
+		{Symbol: "IBM", Acquired: acquired, Sold: acquired.AddDate(1, 0, 1), Quantity: 10, Proceeds: 1300, Cost: 1000},
+		{Symbol: "IBM", Acquired: acquired, Sold: acquired.AddDate(0, 1, 0), Quantity: 2.5, Proceeds: 200, Cost: 250, WashSale: true, Adjustment: 50},
+	}
+
+	buf := &bytes.Buffer{}
+	err := WriteDisposalsCsv(buf, disposals)
+	if err != nil {
+		t.Fatalf("error writing csv: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := []string{
+		"symbol,quantity,acquired,sold,proceeds,cost,adjustment,gain,term,wash_sale,lot,sell,held_since",
+		"IBM,10,2024-01-02,2025-01-03,1300.00,1000.00,0.00,300.00,long,false,",
+		"IBM,2.5,2024-01-02,2024-02-02,200.00,250.00,50.00,0.00,short,true,",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %q", len(expected), lines)
+	}
+	for i, e := range expected {
+		if !strings.HasPrefix(lines[i], e) {
+			t.Fatalf("Test case %d: expected %q, got %q", i, e, lines[i])
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package portfolio
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+)
+
+const (
+	BUY      = "buy"
+	SELL     = "sell"
+	DIVIDEND = "dividend"
+	SPLIT    = "split"
+)
+
+// quantities below this are rounding left overs of splits and count as closed
+const quantityEpsilon = 1e-9
+
+// Validate checks that trade carries what its type needs.
+func Validate(trade models.Trade) error {
+	if trade.Symbol == "" {
+		return errors.New("trade has no symbol")
+	}
+
+	switch trade.Type {
+	case BUY, SELL:
+		if trade.Quantity <= 0 || trade.Price < 0 || trade.Fees < 0 {
+			return errors.New(fmt.Sprintf("%s needs a positive quantity and no negative price or fees", trade.Type))
+		}
+	case DIVIDEND:
+		if trade.Amount <= 0 {
+			return errors.New("dividend needs a positive amount")
+		}
+	case SPLIT:
+		if trade.Ratio <= 0 {
+			return errors.New("split needs a positive ratio")
+		}
+	default:
+		return errors.New(fmt.Sprintf("unknown trade type: %s", trade.Type))
+	}
+	return nil
+}
+
+// Position is what is held of a symbol, valued at average cost: buys and their
+// fees raise the cost, sells realise the difference between their proceeds
+// and the average cost of the shares sold.
+type Position struct {
+	Symbol   string
+	Quantity float64
+	// Cost is the cost basis of the held quantity, fees included
+	Cost float64
+	// Realised is the profit of sells plus dividends, fees deducted
+	Realised  float64
+	Dividends float64
+
+	// LastClose is zero until the position is valued
+	LastClose float64
+	LastDate  time.Time
+
+	// Err is why the trades of the symbol stopped replaying, e.g. a sell of
+	// shares not held. The position holds what the trades before it left.
+	Err error
+}
+
+func (p Position) AverageCost() float64 {
+	if p.Quantity == 0 {
+		return 0
+	}
+	return p.Cost / p.Quantity
+}
+
+func (p Position) MarketValue() float64 {
+	return p.Quantity * p.LastClose
+}
+
+// Unrealised is zero while the position has no price.
+func (p Position) Unrealised() float64 {
+	if p.LastClose == 0 {
+		return 0
+	}
+	return p.MarketValue() - p.Cost
+}
+
+// Open reports whether shares are still held.
+func (p Position) Open() bool {
+	return p.Quantity > 0
+}
+
+// Value sets the latest close of the position.
+func (p *Position) Value(price models.Price) {
+	p.LastClose = price.Close
+	p.LastDate = price.Date.Time().UTC()
+}
+
+// Apply adds trade to the position. Selling more than is held is an error,
+// short positions are not tracked.
+func (p *Position) Apply(trade models.Trade) error {
+	switch trade.Type {
+	case BUY:
+		p.Quantity += trade.Quantity
+		p.Cost += trade.Quantity*trade.Price + trade.Fees
+	case SELL:
+		if trade.Quantity > p.Quantity+quantityEpsilon {
+			return errors.New(fmt.Sprintf("%s: selling %g shares on %s but only %g held",
+				trade.Symbol, trade.Quantity, trade.Date.Time().UTC().Format("2006-01-02"), p.Quantity))
+		}
+		cost := p.AverageCost() * trade.Quantity
+		p.Realised += trade.Quantity*trade.Price - trade.Fees - cost
+		p.Quantity -= trade.Quantity
+		p.Cost -= cost
+		if p.Quantity < quantityEpsilon {
+			p.Quantity = 0
+			p.Cost = 0
+		}
+	case DIVIDEND:
+		p.Dividends += trade.Amount
+		p.Realised += trade.Amount
+	case SPLIT:
+		// the cost basis is unchanged, it is spread over more shares
+		p.Quantity *= trade.Ratio
+	default:
+		return errors.New(fmt.Sprintf("unknown trade type: %s", trade.Type))
+	}
+	return nil
+}
+
+// Positions replays trades, oldest first, into one position per symbol
+// ordered by symbol. A symbol whose trades do not replay keeps the error on its
+// position and the others are still returned, along with the errors joined.
+func Positions(trades []models.Trade) ([]Position, error) {
+	bySymbol := map[string]*Position{}
+	for _, t := range trades {
+		p, ok := bySymbol[t.Symbol]
+		if !ok {
+			p = &Position{Symbol: t.Symbol}
+			bySymbol[t.Symbol] = p
+		}
+		if p.Err != nil {
+			continue
+		}
+
+		p.Err = p.Apply(t)
+	}
+
+	positions := make([]Position, 0, len(bySymbol))
+	for _, p := range bySymbol {
+		positions = append(positions, *p)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].Symbol < positions[j].Symbol
+	})
+
+	errs := []error{}
+	for _, p := range positions {
+		if p.Err != nil {
+			errs = append(errs, p.Err)
+		}
+	}
+	return positions, errors.Join(errs...)
+}
+
+type Totals struct {
+	Cost        float64
+	MarketValue float64
+	Unrealised  float64
+	Realised    float64
+	Dividends   float64
+}
+
+// Total sums the positions. Positions without a price are left out of the
+// market value and unrealised profit, their cost still counts. Positions with
+// an error are left out altogether.
+func Total(positions []Position) Totals {
+	total := Totals{}
+	for _, p := range positions {
+		if p.Err != nil {
+			continue
+		}
+		total.Cost += p.Cost
+		total.MarketValue += p.MarketValue()
+		total.Unrealised += p.Unrealised()
+		total.Realised += p.Realised
+		total.Dividends += p.Dividends
+	}
+	return total
+}
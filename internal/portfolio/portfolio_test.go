@@ -0,0 +1,130 @@
+package portfolio
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func trade(symbol, tradeType, date string, quantity, price float64) models.Trade {
+	d, _ := time.Parse("2006-01-02", date)
+	t := models.Trade{Symbol: symbol, Type: tradeType, Date: primitive.NewDateTimeFromTime(d)}
+	switch tradeType {
+	case DIVIDEND:
+		t.Amount = price
+	case SPLIT:
+		t.Ratio = quantity
+	default:
+		t.Quantity = quantity
+		t.Price = price
+	}
+	return t
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestPositions(t *testing.T) {
+	withFees := trade("IBM", BUY, "2025-01-02", 10, 100)
+	withFees.Fees = 5
+
+	cases := []struct {
+		trades   []models.Trade
+		expected Position
+	}{
+		{
+			trades:   []models.Trade{trade("IBM", BUY, "2025-01-02", 10, 100), trade("IBM", BUY, "2025-01-03", 10, 110)},
+			expected: Position{Symbol: "IBM", Quantity: 20, Cost: 2100},
+		},
+		{
+			trades:   []models.Trade{withFees, trade("IBM", SELL, "2025-01-03", 4, 120)},
+			expected: Position{Symbol: "IBM", Quantity: 6, Cost: 603, Realised: 480 - 402},
+		},
+		{
+			trades:   []models.Trade{trade("IBM", BUY, "2025-01-02", 10, 100), trade("IBM", SELL, "2025-01-03", 10, 90)},
+			expected: Position{Symbol: "IBM", Quantity: 0, Cost: 0, Realised: -100},
+		},
+		{
+			trades:   []models.Trade{trade("IBM", BUY, "2025-01-02", 10, 100), trade("IBM", SPLIT, "2025-01-03", 4, 0), trade("IBM", SELL, "2025-01-06", 20, 30)},
+			expected: Position{Symbol: "IBM", Quantity: 20, Cost: 500, Realised: 100},
+		},
+		{
+			trades:   []models.Trade{trade("IBM", BUY, "2025-01-02", 10, 100), trade("IBM", DIVIDEND, "2025-01-03", 0, 16.7)},
+			expected: Position{Symbol: "IBM", Quantity: 10, Cost: 1000, Realised: 16.7, Dividends: 16.7},
+		},
+	}
+
+	for i, c := range cases {
+		positions, err := Positions(c.trades)
+		if err != nil || len(positions) != 1 {
+			t.Fatalf("Test case %d: expected one position, got %+v, %v", i, positions, err)
+		}
+		p := positions[0]
+		if p.Symbol != c.expected.Symbol || !near(p.Quantity, c.expected.Quantity) || !near(p.Cost, c.expected.Cost) ||
+			!near(p.Realised, c.expected.Realised) || !near(p.Dividends, c.expected.Dividends) {
+			t.Fatalf("Test case %d: expected %+v, got %+v", i, c.expected, p)
+		}
+	}
+}
+
+func TestPositionsOversold(t *testing.T) {
+	positions, err := Positions([]models.Trade{
+		trade("ARM", BUY, "2025-01-02", 5, 140),
+		trade("IBM", BUY, "2025-01-02", 10, 100),
+		trade("IBM", SELL, "2025-01-03", 11, 100),
+		trade("IBM", BUY, "2025-01-06", 1, 100),
+	})
+	if err == nil {
+		t.Fatalf("expected an error selling more than held")
+	}
+	// the other symbols are still valued, the broken one stops at the bad sell
+	if len(positions) != 2 || positions[0].Err != nil || !near(positions[0].Quantity, 5) ||
+		positions[1].Err == nil || !near(positions[1].Quantity, 10) {
+		t.Fatalf("expected ARM held and IBM stopped at 10 shares with an error, got %+v", positions)
+	}
+	if total := Total(positions); !near(total.Cost, 700) {
+		t.Fatalf("expected the broken position left out of the total, got %+v", total)
+	}
+}
+
+func TestTotal(t *testing.T) {
+	positions, _ := Positions([]models.Trade{
+		trade("IBM", BUY, "2025-01-02", 10, 100),
+		trade("ARM", BUY, "2025-01-02", 5, 140),
+		trade("ARM", SELL, "2025-01-03", 5, 150),
+		trade("NEW", BUY, "2025-01-02", 1, 10),
+	})
+	positions[1].Value(models.Price{Close: 110})
+
+	total := Total(positions)
+	expected := Totals{Cost: 1010, MarketValue: 1100, Unrealised: 100, Realised: 50}
+	if total != expected {
+		t.Fatalf("expected %+v, got %+v", expected, total)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		trade models.Trade
+		valid bool
+	}{
+		{trade: trade("IBM", BUY, "2025-01-02", 10, 100), valid: true},
+		{trade: trade("IBM", SELL, "2025-01-02", 0, 100), valid: false},
+		{trade: trade("IBM", DIVIDEND, "2025-01-02", 0, 12), valid: true},
+		{trade: trade("IBM", DIVIDEND, "2025-01-02", 0, 0), valid: false},
+		{trade: trade("IBM", SPLIT, "2025-01-02", 2, 0), valid: true},
+		{trade: trade("", BUY, "2025-01-02", 1, 1), valid: false},
+		{trade: trade("IBM", "transfer", "2025-01-02", 1, 1), valid: false},
+	}
+
+	for i, c := range cases {
+		err := Validate(c.trade)
+		if (err == nil) != c.valid {
+			t.Fatalf("Test case %d: expected valid %v, got %v", i, c.valid, err)
+		}
+	}
+}
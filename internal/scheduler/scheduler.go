@@ -0,0 +1,148 @@
+// Package scheduler fetches prices for many symbols with a bounded number of
+// requests in flight.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/calendar"
+	"github.com/jingen11/stonk-tracker/internal/models"
+	stonkapi "github.com/jingen11/stonk-tracker/internal/stonkApi"
+)
+
+const DEFAULT_CONCURRENCY = 4
+
+// Task asks for the daily bars of Symbol from From to To, both included.
+type Task struct {
+	Symbol string
+	From   time.Time
+	To     time.Time
+}
+
+type Result struct {
+	Task   Task
+	Stocks []models.StockData
+	Err    error
+}
+
+type Scheduler struct {
+	Provider    stonkapi.PriceProvider
+	Concurrency int
+}
+
+func InitScheduler(provider stonkapi.PriceProvider, concurrency int) *Scheduler {
+	if concurrency <= 0 {
+		concurrency = DEFAULT_CONCURRENCY
+	}
+
+	return &Scheduler{
+		Provider:    provider,
+		Concurrency: concurrency,
+	}
+}
+
+// Run fetches every task and returns the results grouped by symbol, each group
+// ordered by date. Providers without range queries get one task per trading
+// day. Tasks not started before ctx is done fail with ctx.Err().
+func (s *Scheduler) Run(ctx context.Context, tasks []Task) map[string][]Result {
+	if !s.Provider.Capabilities().RangeQueries {
+		tasks = splitByDay(tasks)
+	}
+
+	results := make([]Result, len(tasks))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+
+	for w := 0; w < s.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = s.fetch(ctx, tasks[i])
+			}
+		}()
+	}
+
+	for i := range tasks {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			results[i] = Result{Task: tasks[i], Err: ctx.Err()}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	grouped := map[string][]Result{}
+	for _, r := range results {
+		grouped[r.Task.Symbol] = append(grouped[r.Task.Symbol], r)
+	}
+	for _, group := range grouped {
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].Task.From.Before(group[j].Task.From)
+		})
+	}
+
+	return grouped
+}
+
+func (s *Scheduler) fetch(ctx context.Context, task Task) Result {
+	res := Result{Task: task}
+
+	if ctx.Err() != nil {
+		res.Err = ctx.Err()
+		return res
+	}
+
+	from := task.From.Format("2006-01-02")
+	to := task.To.Format("2006-01-02")
+
+	if !s.Provider.Capabilities().RangeQueries {
+		stock, err := s.Provider.GetPrices(ctx, task.Symbol, from)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		res.Stocks = []models.StockData{stock}
+		return res
+	}
+
+	stocks, err := s.Provider.GetPricesRange(ctx, task.Symbol, from, to)
+	sort.Slice(stocks, func(i, j int) bool {
+		return stocks[i].From < stocks[j].From
+	})
+	res.Stocks = stocks
+	res.Err = err
+	return res
+}
+
+// Stocks flattens the bars of results in order. Days the provider had no data
+// for are not treated as errors. Bars stop at the first failed task, whose
+// error is returned, so storing them never moves lastFetchedDate past a gap.
+func Stocks(results []Result) ([]models.StockData, error) {
+	stocks := []models.StockData{}
+
+	for _, r := range results {
+		// a failed range keeps the pages read before the failure
+		stocks = append(stocks, r.Stocks...)
+		if r.Err != nil && !errors.Is(r.Err, stonkapi.ErrNoData) {
+			return stocks, r.Err
+		}
+	}
+
+	return stocks, nil
+}
+
+func splitByDay(tasks []Task) []Task {
+	split := []Task{}
+	for _, t := range tasks {
+		for _, d := range calendar.TradingDaysBetween(t.From, t.To) {
+			split = append(split, Task{Symbol: t.Symbol, From: d, To: d})
+		}
+	}
+	return split
+}
@@ -0,0 +1,143 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+	stonkapi "github.com/jingen11/stonk-tracker/internal/stonkApi"
+)
+
+type fakeProvider struct {
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	calls       int
+	ranges      bool
+}
+
+func (f *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *fakeProvider) Capabilities() stonkapi.Capabilities {
+	return stonkapi.Capabilities{RangeQueries: f.ranges}
+}
+
+func (f *fakeProvider) enter() {
+	f.mu.Lock()
+	f.calls++
+	f.inFlight++
+	f.maxInFlight = max(f.maxInFlight, f.inFlight)
+	f.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+}
+
+func (f *fakeProvider) leave() {
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+}
+
+func (f *fakeProvider) GetPrices(ctx context.Context, symbol, date string) (models.StockData, error) {
+	f.enter()
+	defer f.leave()
+	if symbol == "BAD" || (symbol == "GAP" && date == "2025-02-05") {
+		return models.StockData{}, stonkapi.ErrUpstream
+	}
+	return models.StockData{Symbol: symbol, From: date}, nil
+}
+
+func (f *fakeProvider) GetPricesRange(ctx context.Context, symbol, from, to string) ([]models.StockData, error) {
+	f.enter()
+	defer f.leave()
+	if symbol == "BAD" {
+		return nil, stonkapi.ErrUpstream
+	}
+	// out of order on purpose
+	return []models.StockData{{Symbol: symbol, From: to}, {Symbol: symbol, From: from}}, nil
+}
+
+func day(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestRunPerDay(t *testing.T) {
+	provider := &fakeProvider{ranges: false}
+	s := InitScheduler(provider, 2)
+
+	results := s.Run(context.Background(), []Task{
+		{Symbol: "AAPL", From: day("2025-02-03"), To: day("2025-02-14")},
+		{Symbol: "IBM", From: day("2025-02-10"), To: day("2025-02-11")},
+		{Symbol: "BAD", From: day("2025-02-10"), To: day("2025-02-10")},
+		{Symbol: "GAP", From: day("2025-02-03"), To: day("2025-02-07")},
+	})
+
+	if provider.maxInFlight > 2 {
+		t.Fatalf("expected at most 2 requests in flight, got %d", provider.maxInFlight)
+	}
+	if provider.calls != 18 {
+		t.Fatalf("expected 18 requests, got %d", provider.calls)
+	}
+
+	stocks, err := Stocks(results["AAPL"])
+	if err != nil || len(stocks) != 10 {
+		t.Fatalf("expected 10 AAPL bars without error, got %d, %v", len(stocks), err)
+	}
+	for i := 1; i < len(stocks); i++ {
+		if stocks[i-1].From >= stocks[i].From {
+			t.Fatalf("expected AAPL bars in order, got %s before %s", stocks[i-1].From, stocks[i].From)
+		}
+	}
+
+	_, err = Stocks(results["BAD"])
+	if !errors.Is(err, stonkapi.ErrUpstream) {
+		t.Fatalf("expected upstream error for BAD, got %v", err)
+	}
+
+	stocks, err = Stocks(results["GAP"])
+	if !errors.Is(err, stonkapi.ErrUpstream) || len(stocks) != 2 {
+		t.Fatalf("expected the 2 bars before the failed day and an upstream error for GAP, got %d, %v", len(stocks), err)
+	}
+}
+
+func TestRunRange(t *testing.T) {
+	provider := &fakeProvider{ranges: true}
+	s := InitScheduler(provider, 4)
+
+	results := s.Run(context.Background(), []Task{
+		{Symbol: "AAPL", From: day("2025-02-03"), To: day("2025-02-14")},
+	})
+
+	if provider.calls != 1 {
+		t.Fatalf("expected a single request, got %d", provider.calls)
+	}
+	stocks, _ := Stocks(results["AAPL"])
+	if len(stocks) != 2 || stocks[0].From != "2025-02-03" {
+		t.Fatalf("expected bars sorted by date, got %+v", stocks)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	provider := &fakeProvider{ranges: true}
+	s := InitScheduler(provider, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := s.Run(ctx, []Task{
+		{Symbol: "AAPL", From: day("2025-02-03"), To: day("2025-02-14")},
+		{Symbol: "IBM", From: day("2025-02-03"), To: day("2025-02-14")},
+	})
+
+	if provider.calls != 0 {
+		t.Fatalf("expected no requests after cancel, got %d", provider.calls)
+	}
+	if !errors.Is(results["IBM"][0].Err, context.Canceled) {
+		t.Fatalf("expected cancelled result, got %v", results["IBM"][0].Err)
+	}
+}
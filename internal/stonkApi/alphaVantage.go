@@ -0,0 +1,227 @@
+package stonkapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+)
+
+const ALPHA_VANTAGE_HOST_NAME = "www.alphavantage.co"
+
+// compact responses only hold the latest 100 bars
+const alphaVantageCompactSize = 100
+
+type AlphaVantageClient struct {
+	*keyRing
+	Client      http.Client
+	RetryPolicy RetryPolicy
+	scheme      string
+	host        string
+}
+
+type alphaVantageResponse struct {
+	TimeSeries   map[string]alphaVantageBar `json:"Time Series (Daily)"`
+	ErrorMessage string                     `json:"Error Message"`
+	Note         string                     `json:"Note"`
+	Information  string                     `json:"Information"`
+}
+
+type alphaVantageBar struct {
+	Open   string `json:"1. open"`
+	High   string `json:"2. high"`
+	Low    string `json:"3. low"`
+	Close  string `json:"4. close"`
+	Volume string `json:"5. volume"`
+}
+
+func InitAlphaVantageClient(apiKeys []string) *AlphaVantageClient {
+	client := http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	return &AlphaVantageClient{
+		keyRing:     initKeyRing(apiKeys, ALPHA_VANTAGE_FREE_TIER),
+		Client:      client,
+		RetryPolicy: DEFAULT_RETRY_POLICY,
+		scheme:      "https",
+		host:        ALPHA_VANTAGE_HOST_NAME,
+	}
+}
+
+func (client *AlphaVantageClient) Name() string {
+	return ALPHA_VANTAGE
+}
+
+func (client *AlphaVantageClient) Capabilities() Capabilities {
+	return Capabilities{
+		RangeQueries:   true,
+		Adjusted:       false,
+		RequiresApiKey: true,
+	}
+}
+
+func (client *AlphaVantageClient) GetPrices(ctx context.Context, symbol, date string) (models.StockData, error) {
+	stocks, err := client.GetPricesRange(ctx, symbol, date, date)
+	if err != nil {
+		return models.StockData{}, err
+	}
+	if len(stocks) == 0 {
+		return models.StockData{}, fmt.Errorf("%w for symbol: %s on %s", ErrNoData, symbol, date)
+	}
+	return stocks[0], nil
+}
+
+func (client *AlphaVantageClient) GetPricesRange(ctx context.Context, symbol, from, to string) ([]models.StockData, error) {
+	fromDate, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return nil, err
+	}
+	toDate, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return nil, err
+	}
+
+	outputSize := "compact"
+	if time.Since(fromDate) > alphaVantageCompactSize*24*time.Hour {
+		outputSize = "full"
+	}
+
+	body := alphaVantageResponse{}
+	err = client.RetryPolicy.Do(ctx, func() error {
+		var err error
+		body, err = client.fetch(ctx, symbol, outputSize)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stocks := []models.StockData{}
+	for date, bar := range body.TimeSeries {
+		d, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return nil, err
+		}
+		if d.Before(fromDate) || d.After(toDate) {
+			continue
+		}
+		stock, err := bar.toStockData(symbol, date)
+		if err != nil {
+			return nil, err
+		}
+		stocks = append(stocks, stock)
+	}
+
+	sort.Slice(stocks, func(i, j int) bool {
+		return stocks[i].From < stocks[j].From
+	})
+
+	return stocks, nil
+}
+
+func (client *AlphaVantageClient) fetch(ctx context.Context, symbol, outputSize string) (alphaVantageResponse, error) {
+	body := alphaVantageResponse{}
+
+	apiKey, err := client.roundRobinGetApiKey(ctx)
+	if err != nil {
+		return body, err
+	}
+	endpoint := url.URL{
+		Scheme:   client.scheme,
+		Host:     client.host,
+		Path:     "query",
+		RawQuery: fmt.Sprintf("function=TIME_SERIES_DAILY&symbol=%s&outputsize=%s", url.QueryEscape(symbol), outputSize),
+	}
+	// errors carry the url without the api key
+	unsigned := endpoint.String()
+	endpoint.RawQuery += "&apikey=" + url.QueryEscape(apiKey)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return body, err
+	}
+	res, err := client.Client.Do(req)
+	if err != nil {
+		return body, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode > 299 {
+		return body, newErrorResponse(res.StatusCode, res.Status, unsigned)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return body, err
+	}
+
+	// alpha vantage reports errors and throttling with a 200
+	if body.Note != "" || body.Information != "" {
+		e := &ErrorResponse{
+			Message:    body.Note + body.Information,
+			Url:        unsigned,
+			StatusCode: res.StatusCode,
+			Err:        alphaVantageNotice(body),
+		}
+		if e.Err == ErrRateLimited {
+			client.rest(apiKey, defaultRetryAfter)
+		}
+		return body, e
+	}
+	if body.ErrorMessage != "" {
+		return body, &ErrorResponse{
+			Message:    body.ErrorMessage,
+			Url:        unsigned,
+			StatusCode: res.StatusCode,
+			Err:        ErrNoData,
+		}
+	}
+
+	return body, nil
+}
+
+// alphaVantageNotice classifies the Note and Information of a response. Note
+// is only sent for throttling, Information also announces premium endpoints
+// and rejected parameters, which fail the same way when sent again.
+func alphaVantageNotice(body alphaVantageResponse) error {
+	information := strings.ToLower(body.Information)
+	switch {
+	case body.Note != "" || strings.Contains(information, "call frequency") || strings.Contains(information, "rate limit"):
+		return ErrRateLimited
+	case strings.Contains(information, "premium"):
+		return ErrNotEntitled
+	default:
+		return ErrUpstream
+	}
+}
+
+func (bar alphaVantageBar) toStockData(symbol, date string) (models.StockData, error) {
+	values := []string{bar.Open, bar.High, bar.Low, bar.Close, bar.Volume}
+	parsed := make([]float64, len(values))
+	for i, v := range values {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return models.StockData{}, err
+		}
+		parsed[i] = f
+	}
+
+	return models.StockData{
+		Status: "OK",
+		From:   date,
+		Symbol: symbol,
+		Open:   parsed[0],
+		High:   parsed[1],
+		Low:    parsed[2],
+		Close:  parsed[3],
+		Volume: parsed[4],
+	}, nil
+}
@@ -0,0 +1,81 @@
+package stonkapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAlphaVantageGetPricesRange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("apikey") {
+		case "demo":
+		case "throttled":
+			fmt.Fprint(w, `{"Information": "Thank you for using Alpha Vantage! Our standard API rate limit is 25 requests per day."}`)
+			return
+		case "free":
+			fmt.Fprint(w, `{"Information": "Thank you for using Alpha Vantage! This is a premium endpoint."}`)
+			return
+		default:
+			fmt.Fprint(w, `{"Error Message": "the parameter apikey is invalid or missing."}`)
+			return
+		}
+		fmt.Fprint(w, `{
+			"Meta Data": {"2. Symbol": "IBM"},
+			"Time Series (Daily)": {
+				"2025-02-11": {"1. open": "261.5000", "2. high": "263.0000", "3. low": "259.0000", "4. close": "262.5000", "5. volume": "3100000"},
+				"2025-02-10": {"1. open": "255.2800", "2. high": "256.9300", "3. low": "252.0200", "4. close": "252.3400", "5. volume": "3370284"},
+				"2025-02-07": {"1. open": "254.0000", "2. high": "256.0000", "3. low": "251.0000", "4. close": "252.0000", "5. volume": "3000000"},
+				"2025-02-06": {"1. open": "253.0000", "2. high": "255.0000", "3. low": "250.0000", "4. close": "254.0000", "5. volume": "2900000"}
+			}
+		}`)
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		apiKey   string
+		from     string
+		to       string
+		expected []string
+		err      error
+	}{
+		{apiKey: "demo", from: "2025-02-07", to: "2025-02-10", expected: []string{"2025-02-07", "2025-02-10"}},
+		{apiKey: "demo", from: "2025-02-10", to: "2025-02-10", expected: []string{"2025-02-10"}},
+		{apiKey: "bad", from: "2025-02-10", to: "2025-02-10", err: ErrNoData},
+		{apiKey: "throttled", from: "2025-02-10", to: "2025-02-10", err: ErrRateLimited},
+		// premium endpoints fail at once and leave the key usable
+		{apiKey: "free", from: "2025-02-10", to: "2025-02-10", err: ErrNotEntitled},
+	}
+
+	for i, c := range cases {
+		client := InitAlphaVantageClient([]string{c.apiKey})
+		client.RetryPolicy = NO_RETRY
+		client.scheme = "http"
+		client.host = server.Listener.Addr().String()
+
+		stocks, err := client.GetPricesRange(context.Background(), "IBM", c.from, c.to)
+		if c.err != nil {
+			if !errors.Is(err, c.err) {
+				t.Fatalf("Test case %d: expected error %v, got %v", i, c.err, err)
+			}
+			if status := client.KeyStatus()[0]; (c.err == ErrRateLimited) != (status.State == KEY_RATE_LIMITED) {
+				t.Fatalf("Test case %d: expected only rate limits to rest the key, got %+v", i, status)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test case %d: error: %v", i, err)
+		}
+		if len(stocks) != len(c.expected) {
+			t.Fatalf("Test case %d: expected %d bars, got %d", i, len(c.expected), len(stocks))
+		}
+		for j, s := range stocks {
+			if s.From != c.expected[j] {
+				t.Fatalf("Test case %d: expected date %s, got %s", i, c.expected[j], s.From)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package stonkapi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+var (
+	ErrNoData       = errors.New("no data")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotEntitled is a 403 for data the plan of a valid key does not cover
+	ErrNotEntitled = errors.New("not entitled")
+	ErrUpstream    = errors.New("upstream error")
+)
+
+// ErrorResponse is the error returned for any non 2xx response. It unwraps to
+// one of ErrNoData, ErrRateLimited, ErrUnauthorized, ErrNotEntitled or
+// ErrUpstream depending on the status code.
+type ErrorResponse struct {
+	Message    string `json:"message"`
+	Url        string
+	StatusCode int
+	Err        error
+}
+
+func newErrorResponse(statusCode int, message, url string) *ErrorResponse {
+	e := &ErrorResponse{
+		Message:    message,
+		Url:        url,
+		StatusCode: statusCode,
+	}
+
+	switch {
+	case statusCode == 404:
+		e.Err = ErrNoData
+	case statusCode == 429:
+		e.Err = ErrRateLimited
+	case statusCode == 401 || (statusCode == 403 && invalidApiKey(message)):
+		e.Err = ErrUnauthorized
+	case statusCode == 403:
+		e.Err = ErrNotEntitled
+	default:
+		e.Err = ErrUpstream
+	}
+
+	return e
+}
+
+// invalidApiKey reports whether the message of a 403 blames the api key itself
+// rather than what it asked for.
+func invalidApiKey(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "api key") || strings.Contains(message, "apikey")
+}
+
+func (e *ErrorResponse) Error() string {
+	return fmt.Sprintf("%v (status %d), url: %s,\n message: %s", e.Err, e.StatusCode, e.Url, e.Message)
+}
+
+func (e *ErrorResponse) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether err is transient: rate limits, 5xx responses,
+// timeouts and connections dropped mid response.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+
+	var e *ErrorResponse
+	if errors.As(err, &e) {
+		return e.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
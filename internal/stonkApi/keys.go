@@ -0,0 +1,263 @@
+package stonkapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Quota is the number of requests a single api key may make per period. A
+// zero quota is unlimited.
+type Quota struct {
+	Requests int
+	Per      time.Duration
+}
+
+var (
+	POLYGON_FREE_TIER       = Quota{Requests: 5, Per: time.Minute}
+	ALPHA_VANTAGE_FREE_TIER = Quota{Requests: 5, Per: time.Minute}
+)
+
+// wait used when a 429 comes without a usable Retry-After header
+const defaultRetryAfter = time.Minute
+
+type KeyState string
+
+const (
+	KEY_HEALTHY      KeyState = "healthy"
+	KEY_RATE_LIMITED KeyState = "rate limited"
+	KEY_REVOKED      KeyState = "revoked"
+)
+
+var ErrNoUsableApiKey = fmt.Errorf("every api key has been revoked: %w", ErrUnauthorized)
+
+// KeyStatus describes one api key, which is masked down to its last 4 characters.
+type KeyStatus struct {
+	Key      string
+	State    KeyState
+	Until    time.Time
+	Requests int
+}
+
+// KeyReporter is implemented by providers that rotate api keys.
+type KeyReporter interface {
+	KeyStatus() []KeyStatus
+}
+
+// keyRing hands out api keys in round robin order while keeping every key
+// within its quota. Callers block until a key has budget left.
+type keyRing struct {
+	mu              sync.Mutex
+	roundRobinIndex int
+	apiKeys         []string
+	buckets         []*tokenBucket
+}
+
+type tokenBucket struct {
+	quota        Quota
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+	revoked      bool
+	requests     int
+}
+
+func initKeyRing(apiKeys []string, quota Quota) *keyRing {
+	buckets := make([]*tokenBucket, len(apiKeys))
+	for i := range apiKeys {
+		buckets[i] = newTokenBucket(quota)
+	}
+
+	return &keyRing{
+		roundRobinIndex: 0,
+		apiKeys:         apiKeys,
+		buckets:         buckets,
+	}
+}
+
+func newTokenBucket(quota Quota) *tokenBucket {
+	return &tokenBucket{
+		quota:  quota,
+		tokens: float64(quota.Requests),
+		last:   time.Now(),
+	}
+}
+
+// SetQuota replaces the quota of every key, e.g. for paid plans.
+func (ring *keyRing) SetQuota(quota Quota) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	for i := range ring.buckets {
+		ring.buckets[i] = newTokenBucket(quota)
+	}
+}
+
+// SetKeyQuota replaces the quota of a single key.
+func (ring *keyRing) SetKeyQuota(apiKey string, quota Quota) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	for i, k := range ring.apiKeys {
+		if k == apiKey {
+			ring.buckets[i] = newTokenBucket(quota)
+		}
+	}
+}
+
+// roundRobinGetApiKey returns the next key in turn that has budget left,
+// blocking until one does or ctx is done. Revoked keys are skipped for good.
+func (ring *keyRing) roundRobinGetApiKey(ctx context.Context) (string, error) {
+	for {
+		ring.mu.Lock()
+		now := time.Now()
+		shortest := time.Duration(-1)
+
+		for i := 0; i < len(ring.apiKeys); i++ {
+			index := (ring.roundRobinIndex + i) % len(ring.apiKeys)
+			if ring.buckets[index].revoked {
+				continue
+			}
+			wait := ring.buckets[index].take(now)
+
+			if wait == 0 {
+				ring.roundRobinIndex = (index + 1) % len(ring.apiKeys)
+				ring.buckets[index].requests++
+				ring.mu.Unlock()
+				return ring.apiKeys[index], nil
+			}
+			if shortest < 0 || wait < shortest {
+				shortest = wait
+			}
+		}
+
+		ring.mu.Unlock()
+		if shortest < 0 {
+			return "", ErrNoUsableApiKey
+		}
+		err := sleep(ctx, shortest)
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// rest stops apiKey from being handed out for d, draining its bucket.
+func (ring *keyRing) rest(apiKey string, d time.Duration) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	now := time.Now()
+	for i, k := range ring.apiKeys {
+		if k != apiKey {
+			continue
+		}
+		b := ring.buckets[i]
+		b.tokens = 0
+		b.last = now
+		if now.Add(d).After(b.blockedUntil) {
+			b.blockedUntil = now.Add(d)
+		}
+	}
+}
+
+// revoke stops apiKey from being handed out again, after a 401 or a 403
+// blaming the key.
+func (ring *keyRing) revoke(apiKey string) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	for i, k := range ring.apiKeys {
+		if k == apiKey {
+			ring.buckets[i].revoked = true
+		}
+	}
+}
+
+func (ring *keyRing) KeyStatus() []KeyStatus {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	now := time.Now()
+	status := make([]KeyStatus, len(ring.apiKeys))
+	for i, k := range ring.apiKeys {
+		b := ring.buckets[i]
+		status[i] = KeyStatus{
+			Key:      maskApiKey(k),
+			State:    KEY_HEALTHY,
+			Requests: b.requests,
+		}
+		if b.revoked {
+			status[i].State = KEY_REVOKED
+		} else if now.Before(b.blockedUntil) {
+			status[i].State = KEY_RATE_LIMITED
+			status[i].Until = b.blockedUntil
+		}
+	}
+
+	return status
+}
+
+func maskApiKey(apiKey string) string {
+	if len(apiKey) <= 4 {
+		return "****"
+	}
+	return "****" + apiKey[len(apiKey)-4:]
+}
+
+// take spends a token and returns 0, or returns how long until one is available.
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	if now.Before(b.blockedUntil) {
+		return b.blockedUntil.Sub(now)
+	}
+	if b.quota.Requests <= 0 || b.quota.Per <= 0 {
+		return 0
+	}
+
+	rate := float64(b.quota.Requests) / b.quota.Per.Seconds()
+	b.tokens = min(float64(b.quota.Requests), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// sleep waits for d unless ctx is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryAfter reads the Retry-After header, given either in seconds or as a date.
+func retryAfter(res *http.Response) time.Duration {
+	header := res.Header.Get("Retry-After")
+	if header == "" {
+		return defaultRetryAfter
+	}
+
+	seconds, err := strconv.Atoi(header)
+	if err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(header)
+	if err == nil && date.After(time.Now()) {
+		return time.Until(date)
+	}
+
+	return defaultRetryAfter
+}
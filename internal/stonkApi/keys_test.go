@@ -0,0 +1,198 @@
+package stonkapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyRingQuota(t *testing.T) {
+	ring := initKeyRing([]string{"1", "2"}, Quota{Requests: 2, Per: 200 * time.Millisecond})
+
+	start := time.Now()
+	counts := map[string]int{}
+	// 4 keys are available straight away, the 5th has to wait for a refill
+	for i := 0; i < 5; i++ {
+		key, err := ring.roundRobinGetApiKey(context.Background())
+		if err != nil {
+			t.Fatalf("error getting api key: %v", err)
+		}
+		counts[key]++
+	}
+	elapsed := time.Since(start)
+
+	if elapsed < 80*time.Millisecond {
+		t.Fatalf("expected to block for a refill, took %s", elapsed)
+	}
+	if counts["1"] < 2 || counts["2"] < 2 {
+		t.Fatalf("expected requests spread over both keys, got %v", counts)
+	}
+}
+
+func TestKeyRingRest(t *testing.T) {
+	ring := initKeyRing([]string{"1", "2"}, Quota{})
+	ring.rest("1", time.Hour)
+
+	for i := 0; i < 4; i++ {
+		if key, _ := ring.roundRobinGetApiKey(context.Background()); key != "2" {
+			t.Fatalf("expected rested key to be skipped, got key %s", key)
+		}
+	}
+
+	status := ring.KeyStatus()
+	if status[0].State != KEY_RATE_LIMITED || status[1].State != KEY_HEALTHY {
+		t.Fatalf("unexpected key status: %+v", status)
+	}
+}
+
+func TestKeyRingRevoke(t *testing.T) {
+	ring := initKeyRing([]string{"first-key", "second-key"}, Quota{})
+	ring.revoke("first-key")
+
+	key, err := ring.roundRobinGetApiKey(context.Background())
+	if err != nil || key != "second-key" {
+		t.Fatalf("expected second-key, got key %s error %v", key, err)
+	}
+
+	ring.revoke("second-key")
+	_, err = ring.roundRobinGetApiKey(context.Background())
+	if err != ErrNoUsableApiKey {
+		t.Fatalf("expected ErrNoUsableApiKey, got %v", err)
+	}
+
+	status := ring.KeyStatus()
+	if status[0].Key != "****-key" || status[0].State != KEY_REVOKED || status[1].Requests != 1 {
+		t.Fatalf("unexpected key status: %+v", status)
+	}
+}
+
+func TestKeyRingConcurrent(t *testing.T) {
+	quota := Quota{Requests: 2, Per: 100 * time.Millisecond}
+	rate := float64(quota.Requests) / quota.Per.Seconds()
+	start := time.Now()
+	ring := initKeyRing([]string{"1", "2", "3"}, quota)
+
+	type handout struct {
+		key string
+		at  time.Time
+	}
+	handouts := []handout{}
+	mu := sync.Mutex{}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key, err := ring.roundRobinGetApiKey(context.Background())
+			if err != nil {
+				t.Errorf("error getting api key: %v", err)
+				return
+			}
+			mu.Lock()
+			handouts = append(handouts, handout{key: key, at: time.Now()})
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(handouts) != 20 {
+		t.Fatalf("expected 20 keys handed out, got %d", len(handouts))
+	}
+	// a key is never handed out more often than its bucket holds plus what
+	// refilled since it was created
+	for _, h := range handouts {
+		count := 0
+		for _, other := range handouts {
+			if other.key == h.key && !other.at.After(h.at) {
+				count++
+			}
+		}
+		allowed := float64(quota.Requests) + h.at.Sub(start).Seconds()*rate
+		if float64(count) > allowed+1e-9 {
+			t.Fatalf("key %s handed out %d times by %s, its bucket allows %.2f", h.key, count, h.at.Sub(start), allowed)
+		}
+	}
+
+	requests := 0
+	for _, s := range ring.KeyStatus() {
+		requests += s.Requests
+	}
+	if requests != 20 {
+		t.Fatalf("expected 20 requests in the key status, got %d", requests)
+	}
+}
+
+func TestGetSkipsUnusableKeys(t *testing.T) {
+	calls := map[string]int{}
+	mu := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := r.URL.Query().Get("apiKey")
+		calls[key]++
+		if key == "3" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if key == "1" {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"status":"OK","from":"2025-02-10","symbol":"AAPL","open":229.57,"high":230.585,"low":227.2,"close":227.65,"volume":30219759}`))
+	}))
+	defer server.Close()
+
+	client := InitStonkApiClient([]string{"1", "2", "3"})
+	client.SetQuota(Quota{})
+	client.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	client.scheme = "http"
+	client.host = server.Listener.Addr().String()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.GetPrices(context.Background(), "AAPL", "2025-02-10")
+		if err != nil {
+			t.Fatalf("error getting price: %v", err)
+		}
+	}
+
+	if calls["1"] != 1 {
+		t.Fatalf("expected rate limited key to be used once, used %d times", calls["1"])
+	}
+	if calls["3"] != 1 {
+		t.Fatalf("expected revoked key to be used once, used %d times", calls["3"])
+	}
+	if calls["2"] != 3 {
+		t.Fatalf("expected 3 calls with the healthy key, got %d", calls["2"])
+	}
+}
+
+func TestGetRateLimitedRetries(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := InitStonkApiClient([]string{"1"})
+	client.SetQuota(Quota{})
+	client.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	client.scheme = "http"
+	client.host = server.Listener.Addr().String()
+
+	_, err := client.GetPrices(context.Background(), "AAPL", "2025-02-10")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected the request to be rate limited, got %v", err)
+	}
+	// the retry policy alone decides how often a rate limited request is sent
+	if calls != 3 {
+		t.Fatalf("expected 3 requests, got %d", calls)
+	}
+}
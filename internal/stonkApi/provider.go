@@ -0,0 +1,50 @@
+package stonkapi
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+)
+
+const (
+	POLYGON       = "polygon"
+	ALPHA_VANTAGE = "alphavantage"
+	YAHOO         = "yahoo"
+)
+
+// PriceProvider is a source of daily bars. Dates are formatted as 2006-01-02
+// and ranges include both ends.
+type PriceProvider interface {
+	Name() string
+	Capabilities() Capabilities
+	GetPrices(ctx context.Context, symbol, date string) (models.StockData, error)
+	GetPricesRange(ctx context.Context, symbol, from, to string) ([]models.StockData, error)
+}
+
+type Capabilities struct {
+	// RangeQueries is false when GetPricesRange costs one request per day
+	RangeQueries   bool
+	Adjusted       bool
+	RequiresApiKey bool
+}
+
+// InitPriceProvider returns the provider registered under name, defaulting to
+// polygon when name is empty.
+func InitPriceProvider(name string, apiKeys []string) (PriceProvider, error) {
+	switch name {
+	case POLYGON, "":
+		if len(apiKeys) == 0 {
+			return nil, fmt.Errorf("provider %s requires at least one api key", POLYGON)
+		}
+		return InitStonkApiClient(apiKeys), nil
+	case ALPHA_VANTAGE:
+		if len(apiKeys) == 0 {
+			return nil, fmt.Errorf("provider %s requires at least one api key", ALPHA_VANTAGE)
+		}
+		return InitAlphaVantageClient(apiKeys), nil
+	case YAHOO:
+		return InitYahooClient(), nil
+	}
+	return nil, fmt.Errorf("unknown price provider: %s", name)
+}
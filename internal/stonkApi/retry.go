@@ -0,0 +1,72 @@
+package stonkapi
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy retries transient failures with exponential backoff. The delay
+// before attempt n is BaseDelay * 2^(n-1), capped at MaxDelay, then moved by
+// up to Jitter (a fraction) in either direction.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+var DEFAULT_RETRY_POLICY = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+	Jitter:      0.2,
+}
+
+// NO_RETRY makes a single attempt.
+var NO_RETRY = RetryPolicy{MaxAttempts: 1}
+
+func (policy RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt <= 0 || policy.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := policy.BaseDelay
+	for i := 1; i < attempt && (policy.MaxDelay <= 0 || delay < policy.MaxDelay); i++ {
+		delay *= 2
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+
+	if policy.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * policy.Jitter * float64(delay))
+	}
+
+	return delay
+}
+
+// Do runs fn until it succeeds, fails with an error IsRetryable rejects,
+// MaxAttempts is used up or ctx is done, returning the last error.
+func (policy RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	attempts := max(policy.MaxAttempts, 1)
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			wait := policy.Backoff(attempt)
+			fmt.Printf("Retrying in %s after: %v\n", wait.Round(time.Millisecond), err)
+			if sleepErr := sleep(ctx, wait); sleepErr != nil {
+				return fmt.Errorf("%w after: %w", sleepErr, err)
+			}
+		}
+
+		err = fn()
+		if !IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return err
+}
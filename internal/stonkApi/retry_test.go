@@ -0,0 +1,71 @@
+package stonkapi
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    300 * time.Millisecond,
+		Jitter:      0.2,
+	}
+
+	cases := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{attempt: 0, expected: 0},
+		{attempt: 1, expected: 100 * time.Millisecond},
+		{attempt: 2, expected: 200 * time.Millisecond},
+		{attempt: 3, expected: 300 * time.Millisecond},
+		{attempt: 10, expected: 300 * time.Millisecond},
+	}
+
+	for i, c := range cases {
+		for round := 0; round < 20; round++ {
+			actual := policy.Backoff(c.attempt)
+			low := time.Duration(float64(c.expected) * (1 - policy.Jitter))
+			high := time.Duration(float64(c.expected) * (1 + policy.Jitter))
+			if actual < low || actual > high {
+				t.Fatalf("Test case %d: expected backoff within %s and %s, actual: %s", i, low, high, actual)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	cases := []struct {
+		errs     []error
+		attempts int
+		expected error
+	}{
+		{errs: []error{nil}, attempts: 1, expected: nil},
+		{errs: []error{newErrorResponse(502, "", ""), nil}, attempts: 2, expected: nil},
+		{errs: []error{newErrorResponse(404, "", "")}, attempts: 1, expected: ErrNoData},
+		{errs: []error{newErrorResponse(401, "", "")}, attempts: 1, expected: ErrUnauthorized},
+		{errs: []error{newErrorResponse(429, "", ""), newErrorResponse(500, "", ""), newErrorResponse(503, "", "")}, attempts: 3, expected: ErrUpstream},
+	}
+
+	for i, c := range cases {
+		attempts := 0
+		err := policy.Do(context.Background(), func() error {
+			err := c.errs[attempts]
+			attempts++
+			return err
+		})
+
+		if attempts != c.attempts {
+			t.Fatalf("Test case %d: expected %d attempts, actual: %d", i, c.attempts, attempts)
+		}
+		if !errors.Is(err, c.expected) {
+			t.Fatalf("Test case %d: expected error %v, actual: %v", i, c.expected, err)
+		}
+	}
+}
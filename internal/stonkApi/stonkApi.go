@@ -1,6 +1,7 @@
 package stonkapi
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,14 +15,26 @@ import (
 const POLYGON_IO_HOST_NAME = "api.polygon.io"
 
 type StonkApiClient struct {
-	Client          http.Client
-	roundRobinIndex int
-	apiKeys         []string
+	*keyRing
+	Client      http.Client
+	RetryPolicy RetryPolicy
+	scheme      string
+	host        string
 }
 
-type ErrorResponse struct {
-	Message string `json:"message"`
-	Url     string
+type aggregatesResponse struct {
+	Ticker       string `json:"ticker"`
+	Status       string `json:"status"`
+	ResultsCount int    `json:"resultsCount"`
+	Results      []struct {
+		Open   float64 `json:"o"`
+		High   float64 `json:"h"`
+		Low    float64 `json:"l"`
+		Close  float64 `json:"c"`
+		Volume float64 `json:"v"`
+		Time   int64   `json:"t"`
+	} `json:"results"`
+	NextUrl string `json:"next_url"`
 }
 
 func InitStonkApiClient(apiKeys []string) *StonkApiClient {
@@ -30,59 +43,42 @@ func InitStonkApiClient(apiKeys []string) *StonkApiClient {
 	}
 
 	return &StonkApiClient{
-		Client:          client,
-		roundRobinIndex: 0,
-		apiKeys:         apiKeys,
+		keyRing:     initKeyRing(apiKeys, POLYGON_FREE_TIER),
+		Client:      client,
+		RetryPolicy: DEFAULT_RETRY_POLICY,
+		scheme:      "https",
+		host:        POLYGON_IO_HOST_NAME,
 	}
 }
 
-func (client *StonkApiClient) roundRobinGetApiKey() string {
-	curIndex := client.roundRobinIndex
-
-	apiKey := client.apiKeys[curIndex]
+func (client *StonkApiClient) Name() string {
+	return POLYGON
+}
 
-	if curIndex == len(client.apiKeys)-1 {
-		client.roundRobinIndex = 0
-	} else {
-		client.roundRobinIndex = curIndex + 1
+func (client *StonkApiClient) Capabilities() Capabilities {
+	return Capabilities{
+		RangeQueries:   true,
+		Adjusted:       true,
+		RequiresApiKey: true,
 	}
-
-	return apiKey
 }
 
-func (client *StonkApiClient) GetPrices(symbol, date string) (models.StockData, error) {
+func (client *StonkApiClient) GetPrices(ctx context.Context, symbol, date string) (models.StockData, error) {
 	endpoint := url.URL{
-		Scheme:   "https",
-		Host:     POLYGON_IO_HOST_NAME,
+		Scheme:   client.scheme,
+		Host:     client.host,
 		Path:     "v1/open-close/" + symbol + "/" + date,
-		RawQuery: fmt.Sprintf("adjusted=true&apiKey=%s", client.roundRobinGetApiKey()),
+		RawQuery: "adjusted=true",
 	}
-	res, err := client.Client.Get(endpoint.String())
-
 	stockData := models.StockData{}
 
+	res, err := client.get(ctx, endpoint)
 	if err != nil {
 		return stockData, err
 	}
-
-	if res.StatusCode == 429 {
-		fmt.Println("Cooling down stonk api")
-		time.Sleep(1 * time.Minute)
-		res, err = client.Client.Get(endpoint.String())
-		if err != nil {
-			return stockData, err
-		}
-	}
-
-	if res.StatusCode > 299 {
-		e := ErrorResponse{}
-		json.NewDecoder(res.Body).Decode(&e)
-		e.Url = endpoint.String()
-		return stockData, errors.New(fmt.Sprintf("url: %s,\n message: %s", e.Url, e.Message))
-	}
+	defer res.Body.Close()
 
 	err = json.NewDecoder(res.Body).Decode(&stockData)
-	defer res.Body.Close()
 
 	if err != nil {
 		return stockData, err
@@ -90,3 +86,127 @@ func (client *StonkApiClient) GetPrices(symbol, date string) (models.StockData,
 
 	return stockData, nil
 }
+
+// GetPricesRange fetches the daily bars of symbol between from and to (both
+// inclusive, formatted as 2006-01-02) using the aggregates endpoint, following
+// next_url until every page has been read.
+func (client *StonkApiClient) GetPricesRange(ctx context.Context, symbol, from, to string) ([]models.StockData, error) {
+	endpoint := url.URL{
+		Scheme:   client.scheme,
+		Host:     client.host,
+		Path:     "v2/aggs/ticker/" + symbol + "/range/1/day/" + from + "/" + to,
+		RawQuery: "adjusted=true&sort=asc&limit=50000",
+	}
+
+	stocks := []models.StockData{}
+
+	for {
+		res, err := client.get(ctx, endpoint)
+		if err != nil {
+			return stocks, err
+		}
+
+		aggs := aggregatesResponse{}
+		err = json.NewDecoder(res.Body).Decode(&aggs)
+		res.Body.Close()
+
+		if err != nil {
+			return stocks, err
+		}
+
+		for _, r := range aggs.Results {
+			stocks = append(stocks, models.StockData{
+				Status: aggs.Status,
+				// bars are stamped at midnight New York time, which is still the same day in UTC
+				From:   time.UnixMilli(r.Time).UTC().Format("2006-01-02"),
+				Symbol: symbol,
+				Open:   r.Open,
+				High:   r.High,
+				Low:    r.Low,
+				Close:  r.Close,
+				Volume: r.Volume,
+			})
+		}
+
+		if aggs.NextUrl == "" {
+			break
+		}
+
+		next, err := url.Parse(aggs.NextUrl)
+		if err != nil {
+			return stocks, err
+		}
+		endpoint = *next
+	}
+
+	return stocks, nil
+}
+
+// get sends the request under the client's retry policy.
+func (client *StonkApiClient) get(ctx context.Context, endpoint url.URL) (*http.Response, error) {
+	var res *http.Response
+	err := client.RetryPolicy.Do(ctx, func() error {
+		var err error
+		res, err = client.getOnce(ctx, endpoint)
+		return err
+	})
+	return res, err
+}
+
+// getOnce signs endpoint with the next api key that has budget left. A 429
+// rests that key for as long as the server asks and fails with ErrRateLimited,
+// the retry policy sends it again with the next available key. A 401, or a
+// 403 blaming the key, revokes it and moves on to the next key. Other 403s
+// only fail this request, the key may be entitled to the next.
+func (client *StonkApiClient) getOnce(ctx context.Context, endpoint url.URL) (*http.Response, error) {
+	// errors carry the url without the api key
+	unsigned := endpoint.String()
+
+	for {
+		apiKey, err := client.roundRobinGetApiKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		query := endpoint.Query()
+		query.Set("apiKey", apiKey)
+		endpoint.RawQuery = query.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := client.Client.Do(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode == 429 {
+			wait := retryAfter(res)
+			fmt.Printf("Cooling down stonk api key for %s\n", wait)
+			client.rest(apiKey, wait)
+		}
+
+		if res.StatusCode > 299 {
+			body := struct {
+				Message string `json:"message"`
+				Error   string `json:"error"`
+			}{}
+			json.NewDecoder(res.Body).Decode(&body)
+			res.Body.Close()
+			if body.Message == "" {
+				body.Message = body.Error
+			}
+
+			e := newErrorResponse(res.StatusCode, body.Message, unsigned)
+			if errors.Is(e, ErrUnauthorized) {
+				fmt.Printf("Revoking stonk api key %s\n", maskApiKey(apiKey))
+				client.revoke(apiKey)
+				continue
+			}
+			return nil, e
+		}
+
+		return res, nil
+	}
+}
@@ -1,8 +1,14 @@
 package stonkapi
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestRoundRobinGetApiKey(t *testing.T) {
@@ -59,7 +65,11 @@ func TestRoundRobinGetApiKey(t *testing.T) {
 		client := InitStonkApiClient(c.Inputs)
 		apiKey := ""
 		for round := 0; round < c.RoundRobinRound; round++ {
-			apiKey = client.roundRobinGetApiKey()
+			key, err := client.roundRobinGetApiKey(context.Background())
+			if err != nil {
+				t.Fatalf("Test case %d: error getting api key: %v", i, err)
+			}
+			apiKey = key
 		}
 
 		if apiKey != c.ExpectedKey {
@@ -71,7 +81,7 @@ func TestRoundRobinGetApiKey(t *testing.T) {
 func TestGetPrices(t *testing.T) {
 	apiKey := os.Getenv("POLYGON_IO_KEY_1")
 	client := InitStonkApiClient([]string{apiKey})
-	stonkData, err := client.GetPrices("AAPL", "2025-02-10")
+	stonkData, err := client.GetPrices(context.Background(), "AAPL", "2025-02-10")
 	if err != nil {
 		t.Fatalf("error getting price from api, error: %v", err)
 	}
@@ -84,10 +94,101 @@ func TestGetPricesRateLimit(t *testing.T) {
 	apiKey := os.Getenv("POLYGON_IO_KEY_2")
 	client := InitStonkApiClient([]string{apiKey})
 	for i := 0; i < 6; i++ {
-		_, err := client.GetPrices("AAPL", "2025-02-10")
+		_, err := client.GetPrices(context.Background(), "AAPL", "2025-02-10")
 
 		if err != nil {
 			t.Fatalf("error getting price from api rate limit, error: %v", err)
 		}
 	}
 }
+
+func TestGetPricesRange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("apiKey") != "1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"status":"ERROR","message":"Unknown API Key"}`)
+			return
+		}
+		if r.URL.Query().Get("cursor") == "" {
+			fmt.Fprintf(w, `{"ticker":"AAPL","status":"OK","resultsCount":2,"results":[
+				{"o":229.99,"h":234.00,"l":227.26,"c":227.63,"v":39707224,"t":1738558800000},
+				{"o":227.25,"h":233.13,"l":226.65,"c":232.80,"v":45067301,"t":1738645200000}
+			],"next_url":"http://%s/v2/aggs/ticker/AAPL/range/1/day/1738731600000/2025-02-05?cursor=abc"}`, r.Host)
+			return
+		}
+		fmt.Fprint(w, `{"ticker":"AAPL","status":"OK","resultsCount":1,"results":[
+			{"o":228.53,"h":232.67,"l":228.27,"c":232.47,"v":39620300,"t":1738731600000}
+		]}`)
+	}))
+	defer server.Close()
+
+	client := InitStonkApiClient([]string{"1"})
+	client.scheme = "http"
+	client.host = server.Listener.Addr().String()
+
+	stocks, err := client.GetPricesRange(context.Background(), "AAPL", "2025-02-03", "2025-02-05")
+	if err != nil {
+		t.Fatalf("error getting price range from api, error: %v", err)
+	}
+
+	expected := []string{"2025-02-03", "2025-02-04", "2025-02-05"}
+	if len(stocks) != len(expected) {
+		t.Fatalf("expected %d bars, got %d", len(expected), len(stocks))
+	}
+	for i, s := range stocks {
+		if s.From != expected[i] || s.Symbol != "AAPL" {
+			t.Fatalf("bar %d: expected AAPL %s, got %s %s", i, expected[i], s.Symbol, s.From)
+		}
+	}
+}
+
+func TestGetPricesErrors(t *testing.T) {
+	failures := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/open-close/AAPL/2025-02-08":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"status":"NOT_FOUND","message":"Data not found."}`)
+		case "/v1/open-close/AAPL/2025-02-10":
+			if failures < 2 {
+				failures++
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			fmt.Fprint(w, `{"status":"OK","from":"2025-02-10","symbol":"AAPL","open":229.57,"high":230.585,"low":227.2,"close":227.65,"volume":30219759}`)
+		case "/v1/open-close/AAPL/2025-02-12":
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"status":"ERROR","error":"Unknown API Key"}`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"status":"NOT_AUTHORIZED","message":"You are not entitled to this data."}`)
+		}
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		date     string
+		expected error
+	}{
+		{date: "2025-02-08", expected: ErrNoData},
+		{date: "2025-02-10", expected: nil},
+		// the key stays usable after a request it is not entitled to
+		{date: "2025-02-11", expected: ErrNotEntitled},
+		{date: "2025-02-10", expected: nil},
+		{date: "2025-02-12", expected: ErrUnauthorized},
+		{date: "2025-02-10", expected: ErrNoUsableApiKey},
+	}
+
+	client := InitStonkApiClient([]string{"1"})
+	client.SetQuota(Quota{})
+	client.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	client.scheme = "http"
+	client.host = server.Listener.Addr().String()
+
+	for i, c := range cases {
+		_, err := client.GetPrices(context.Background(), "AAPL", c.date)
+		if !errors.Is(err, c.expected) {
+			t.Fatalf("Test case %d: expected error %v, actual: %v", i, c.expected, err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package stonkapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jingen11/stonk-tracker/internal/models"
+)
+
+const YAHOO_HOST_NAME = "query1.finance.yahoo.com"
+
+const yahooUserAgent = "Mozilla/5.0 (compatible; stonk-tracker)"
+
+// YahooClient reads daily bars from the yahoo v8 chart endpoint, the csv
+// download endpoint it replaced is retired. It does not need an api key.
+type YahooClient struct {
+	Client      http.Client
+	RetryPolicy RetryPolicy
+	scheme      string
+	host        string
+}
+
+func InitYahooClient() *YahooClient {
+	client := http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	return &YahooClient{
+		Client:      client,
+		RetryPolicy: DEFAULT_RETRY_POLICY,
+		scheme:      "https",
+		host:        YAHOO_HOST_NAME,
+	}
+}
+
+func (client *YahooClient) Name() string {
+	return YAHOO
+}
+
+func (client *YahooClient) Capabilities() Capabilities {
+	return Capabilities{
+		RangeQueries:   true,
+		Adjusted:       false,
+		RequiresApiKey: false,
+	}
+}
+
+func (client *YahooClient) GetPrices(ctx context.Context, symbol, date string) (models.StockData, error) {
+	stocks, err := client.GetPricesRange(ctx, symbol, date, date)
+	if err != nil {
+		return models.StockData{}, err
+	}
+	if len(stocks) == 0 {
+		return models.StockData{}, fmt.Errorf("%w for symbol: %s on %s", ErrNoData, symbol, date)
+	}
+	return stocks[0], nil
+}
+
+func (client *YahooClient) GetPricesRange(ctx context.Context, symbol, from, to string) ([]models.StockData, error) {
+	fromDate, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return nil, err
+	}
+	toDate, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return nil, err
+	}
+
+	// period2 is exclusive
+	endpoint := url.URL{
+		Scheme:   client.scheme,
+		Host:     client.host,
+		Path:     "v8/finance/chart/" + url.PathEscape(symbol),
+		RawQuery: fmt.Sprintf("period1=%d&period2=%d&interval=1d&events=history", fromDate.Unix(), toDate.Add(24*time.Hour).Unix()),
+	}
+
+	stocks := []models.StockData{}
+	err = client.RetryPolicy.Do(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+		if err != nil {
+			return err
+		}
+		// the default go user agent is throttled
+		req.Header.Set("User-Agent", yahooUserAgent)
+		res, err := client.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		stocks, err = parseYahooChart(res.Body, symbol)
+		if res.StatusCode > 299 {
+			message := res.Status
+			if err != nil {
+				message = err.Error()
+			}
+			return newErrorResponse(res.StatusCode, message, endpoint.String())
+		}
+		return err
+	})
+
+	return stocks, err
+}
+
+type yahooChartResponse struct {
+	Chart struct {
+		Result []struct {
+			Meta struct {
+				// GmtOffset is the offset of the exchange time zone in seconds
+				GmtOffset int64 `json:"gmtoffset"`
+			} `json:"meta"`
+			Timestamp  []int64 `json:"timestamp"`
+			Indicators struct {
+				Quote []struct {
+					Open   []*float64 `json:"open"`
+					High   []*float64 `json:"high"`
+					Low    []*float64 `json:"low"`
+					Close  []*float64 `json:"close"`
+					Volume []*float64 `json:"volume"`
+				} `json:"quote"`
+			} `json:"indicators"`
+		} `json:"result"`
+		Error *struct {
+			Code        string `json:"code"`
+			Description string `json:"description"`
+		} `json:"error"`
+	} `json:"chart"`
+}
+
+// parseYahooChart reads the bars of a chart response, the error it reports
+// is returned as is.
+func parseYahooChart(r io.Reader, symbol string) ([]models.StockData, error) {
+	body := yahooChartResponse{}
+	err := json.NewDecoder(r).Decode(&body)
+	if err != nil {
+		return nil, err
+	}
+	if body.Chart.Error != nil {
+		return nil, fmt.Errorf("%s: %s", body.Chart.Error.Code, body.Chart.Error.Description)
+	}
+
+	stocks := []models.StockData{}
+	if len(body.Chart.Result) == 0 {
+		return stocks, nil
+	}
+	result := body.Chart.Result[0]
+	if len(result.Indicators.Quote) == 0 {
+		return stocks, nil
+	}
+	quote := result.Indicators.Quote[0]
+
+	for i, timestamp := range result.Timestamp {
+		values := []float64{}
+		for _, series := range [][]*float64{quote.Open, quote.High, quote.Low, quote.Close, quote.Volume} {
+			// days without trades are reported as null
+			if i >= len(series) || series[i] == nil {
+				break
+			}
+			values = append(values, *series[i])
+		}
+		if len(values) < 5 {
+			continue
+		}
+
+		stocks = append(stocks, models.StockData{
+			Status: "OK",
+			From:   time.Unix(timestamp+result.Meta.GmtOffset, 0).UTC().Format("2006-01-02"),
+			Symbol: symbol,
+			Open:   values[0],
+			High:   values[1],
+			Low:    values[2],
+			Close:  values[3],
+			Volume: values[4],
+		})
+	}
+
+	return stocks, nil
+}
@@ -0,0 +1,37 @@
+package stonkapi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseYahooChart(t *testing.T) {
+	input := `{"chart":{"result":[{
+		"meta":{"symbol":"AAPL","gmtoffset":-18000,"exchangeTimezoneName":"America/New_York"},
+		"timestamp":[1738852200,1738938600,1739025000,1739197800],
+		"indicators":{"quote":[{
+			"open":[231.289993,232.600006,null,229.570007],
+			"high":[233.800003,234.000000,null,230.589996],
+			"low":[230.429993,227.259995,null,227.199997],
+			"close":[233.220001,227.630005,null,227.649994],
+			"volume":[29925300,39707200,null,33115600]
+		}],"adjclose":[{"adjclose":[232.967484,227.383545,null,227.403503]}]}
+	}],"error":null}}`
+	stocks, err := parseYahooChart(strings.NewReader(input), "AAPL")
+	if err != nil {
+		t.Fatalf("error parsing yahoo chart: %v", err)
+	}
+
+	if len(stocks) != 3 {
+		t.Fatalf("expected 3 bars, got %d", len(stocks))
+	}
+
+	if stocks[0].From != "2025-02-06" || stocks[2].From != "2025-02-10" || stocks[2].Symbol != "AAPL" || int(stocks[2].Close*100) != 22764 {
+		t.Fatalf("unexpected bars: %+v", stocks)
+	}
+
+	_, err = parseYahooChart(strings.NewReader(`{"chart":{"result":null,"error":{"code":"Not Found","description":"No data found, symbol may be delisted"}}}`), "GONE")
+	if err == nil || !strings.Contains(err.Error(), "No data found") {
+		t.Fatalf("expected the chart error, got %v", err)
+	}
+}
@@ -6,7 +6,8 @@ import (
 )
 
 type ProjectConfig struct {
-	ApiClient           *stonkapi.StonkApiClient
-	Query               *db.Query
+	ApiClient           stonkapi.PriceProvider
+	Query               db.Store
 	HistoricalTimeFrame int
+	FetchConcurrency    int
 }
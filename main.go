@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/jingen11/stonk-tracker/internal/command"
 	"github.com/jingen11/stonk-tracker/internal/db"
@@ -11,6 +18,11 @@ import (
 	"github.com/joho/godotenv"
 )
 
+const (
+	defaultRunTimeout = 30 * time.Minute
+	defaultSqlitePath = "stonk.db"
+)
+
 type commands struct {
 	Commands map[string]func(*command.Command) error
 }
@@ -34,53 +46,147 @@ func (c *commands) run(name string, command *command.Command) error {
 	return nil
 }
 
-func main() {
-	cfg := utils.ProjectConfig{}
-	cfg.HistoricalTimeFrame = 100
-	godotenv.Load()
-	dbUrl := os.Getenv("MONGODB_URL")
+// envApiKeys returns the non empty values of every variable starting with
+// prefix, ordered by variable name.
+func envApiKeys(prefix string) []string {
+	names := []string{}
+	values := map[string]string{}
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if strings.HasPrefix(name, prefix) && value != "" {
+			names = append(names, name)
+			values[name] = value
+		}
+	}
+	sort.Strings(names)
 
-	dbClient, err := db.Init(dbUrl)
-	defer db.Disconnect(dbClient)
+	apiKeys := make([]string, 0, len(names))
+	for _, name := range names {
+		apiKeys = append(apiKeys, values[name])
+	}
+	return apiKeys
+}
 
-	if err != nil {
-		log.Fatalf("failed to start mongodb server, error: %s", err.Error())
-		os.Exit(1)
+// initStore opens the store selected by STONK_STORE, mongo by default, and
+// returns a func releasing it.
+func initStore(ctx context.Context) (db.Store, func(), error) {
+	switch os.Getenv("STONK_STORE") {
+	case db.MEMORY:
+		return db.InitMemoryStore(), func() {}, nil
+	case db.SQLITE:
+		path := os.Getenv("STONK_SQLITE_PATH")
+		if path == "" {
+			path = defaultSqlitePath
+		}
+		store, err := db.InitSqliteStore(ctx, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, func() { store.Close() }, nil
 	}
-	stonkDb := dbClient.Database("stonk")
-	priceColl, err := db.InitPriceCollection(stonkDb)
+
+	dbClient, err := db.Init(os.Getenv("MONGODB_URL"))
 	if err != nil {
-		log.Fatalf("failed to intialise price collection, error: %s", err.Error())
-		os.Exit(1)
+		return nil, nil, err
 	}
-	symbolColl, err := db.InitSymbolCollection(stonkDb)
+	closeStore := func() { db.Disconnect(dbClient) }
+	stonkDb := dbClient.Database("stonk")
+	// collections and indexes are set up by the migrations applied before every command
+	priceColl := stonkDb.Collection("price")
+	symbolColl := stonkDb.Collection("symbol")
+	layout, err := db.GetPriceLayout(ctx, priceColl)
 	if err != nil {
-		log.Fatalf("failed to intialise symbol collection, error: %s", err.Error())
-		os.Exit(1)
+		closeStore()
+		return nil, nil, err
 	}
-
-	// polygonKey1 := os.Getenv("POLYGON_IO_KEY_1")
-	polygonKey2 := os.Getenv("POLYGON_IO_KEY_2")
-	cfg.ApiClient = stonkapi.InitStonkApiClient([]string{polygonKey2})
-	cfg.Query = &db.Query{
+	return &db.Query{
 		PriceColl:  priceColl,
 		SymbolColl: symbolColl,
+		TimeSeries: layout == db.TIMESERIES_LAYOUT,
+	}, closeStore, nil
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	runTimeout := defaultRunTimeout
+	if timeout := os.Getenv("STONK_RUN_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			log.Fatalf("invalid STONK_RUN_TIMEOUT, error: %s", err.Error())
+		}
+		runTimeout = d
 	}
+	ctx, cancel := context.WithTimeout(ctx, runTimeout)
+	defer cancel()
 
+	cfg := utils.ProjectConfig{}
+	cfg.HistoricalTimeFrame = 100
+	cfg.FetchConcurrency = 4
+	godotenv.Load()
+	store, closeStore, err := initStore(ctx)
+	if err != nil {
+		log.Fatalf("failed to initialise %s store, error: %s", os.Getenv("STONK_STORE"), err.Error())
+	}
+	defer closeStore()
+	cfg.Query = store
+
+	provider := os.Getenv("STONK_PROVIDER")
+	apiKeys := []string{}
+	switch provider {
+	case stonkapi.ALPHA_VANTAGE:
+		apiKeys = envApiKeys("ALPHA_VANTAGE_KEY")
+	case stonkapi.YAHOO:
+	default:
+		apiKeys = envApiKeys("POLYGON_IO_KEY_")
+	}
+	cfg.ApiClient, err = stonkapi.InitPriceProvider(provider, apiKeys)
+	if err != nil {
+		log.Fatalf("failed to initialise price provider, error: %s", err.Error())
+	}
 	c := newCommands()
 
 	c.register("refresh", command.HandleRefresh)
 	c.register("add", command.HandlerAddNewSymbol)
 	c.register("info", command.HandleGetInfo)
+	c.register("signals", command.HandleSignals)
+	c.register("import", command.HandleImport)
+	c.register("export", command.HandleExport)
+	c.register("remove", command.HandleRemove)
+	c.register("archive", command.HandleArchive)
+	c.register("rename", command.HandleRename)
+	c.register("watchlist", command.HandleWatchlist)
+	c.register("tag", command.HandleTag)
+	c.register("trade", command.HandleTrade)
+	c.register("portfolio", command.HandlePortfolio)
+	c.register("gains", command.HandleGains)
+	c.register("backtest", command.HandleBacktest)
+	c.register("rebuild-ha", command.HandleRebuildHeikinAshi)
+	c.register("repair", command.HandleRepair)
+	c.register("migrate", command.HandleMigrate)
+	c.register("migrate-timeseries", command.HandleMigrateTimeSeries)
 
 	comm := os.Args[1]
 	args := os.Args[2:]
+
+	// migrate shows and applies the pending migrations itself
+	if comm != "migrate" {
+		applied, err := db.UpgradeSchema(ctx, store)
+		for _, m := range applied {
+			fmt.Printf("Applied migration %d: %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("cannot run %s, error: %v", comm, err)
+		}
+	}
+
 	err = c.run(comm, &command.Command{
+		Ctx:   ctx,
 		Cfg:   cfg,
 		Input: args,
 	})
 	if err != nil {
 		log.Fatalf("error running command: %v", err)
-		os.Exit(1)
 	}
 }